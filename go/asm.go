@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// asmToken matches the words of an assembly line: mnemonics, immediates,
+// label references and, with a trailing colon, label definitions. Everything
+// else separates words, like in scripts/assembler.js.
+var asmToken = regexp.MustCompile(`[A-Za-z_\d]+:?`)
+
+// asmLabel matches valid label names.
+var asmLabel = regexp.MustCompile(`^[A-Za-z_][A-Za-z_\d]*$`)
+
+// asmNumber matches the immediates scripts/assembler.js accepts, the words
+// BigInt parses: decimal numbers, and hex, octal and binary numbers with a
+// 0x, 0o or 0b prefix.
+var asmNumber = regexp.MustCompile(`^(0[xX][\dA-Fa-f]+|0[oO][0-7]+|0[bB][01]+|\d+)$`)
+
+// parseAsmNumber parses an immediate matched by asmNumber. Leading zeros
+// don't make a decimal number octal.
+func parseAsmNumber(s string) *big.Int {
+	base := 10
+	if len(s) > 2 {
+		switch s[:2] {
+		case "0x", "0X":
+			base = 16
+		case "0o", "0O":
+			base = 8
+		case "0b", "0B":
+			base = 2
+		}
+	}
+	if base != 10 {
+		s = s[2:]
+	}
+	n, _ := new(big.Int).SetString(s, base)
+	return n
+}
+
+// asmInstruction is an instruction of the assembly source, before its PUSH
+// size is known.
+type asmInstruction struct {
+	op OpCode
+	// value is the immediate of a PUSH, unless it pushes a label
+	value *big.Int
+	// label is the label whose offset a PUSH pushes
+	label string
+	// auto is set for a PUSH without a size, the smallest PUSH that fits the
+	// immediate is used
+	auto bool
+	line int
+}
+
+// size returns the number of bytes of the instruction's immediate.
+func (ins *asmInstruction) size(labels map[string]uint64) int {
+	if !ins.op.IsPush() {
+		return 0
+	}
+	if !ins.auto {
+		return int(ins.op-PUSH1) + 1
+	}
+	value := ins.value
+	if ins.label != "" {
+		value = new(big.Int).SetUint64(labels[ins.label])
+	}
+	if n := (value.BitLen() + 7) / 8; n > 1 {
+		return n
+	}
+	return 1
+}
+
+// Assemble compiles assembly source to bytecode. The source is a list of
+// mnemonics, each PUSH1 to PUSH32 followed by an immediate matched by
+// asmNumber. As in scripts/assembler.js, any character but a letter or digit
+// separates words, so there are no comments. Unlike there:
+//
+//   - PUSH without a size uses the smallest PUSH that fits its immediate,
+//   - `name:` defines a label for the offset of the next instruction, usually
+//     a JUMPDEST, and a PUSH of `name` pushes that offset,
+//   - `_` is part of words, for label names.
+func Assemble(src string) ([]byte, error) {
+	var (
+		program []*asmInstruction
+		// labels maps label names to the index of the instruction they mark
+		labels = make(map[string]int)
+	)
+	for i, line := range strings.Split(src, "\n") {
+		words := asmToken.FindAllString(line, -1)
+		for len(words) > 0 {
+			word := words[0]
+			words = words[1:]
+
+			if name := strings.TrimSuffix(word, ":"); name != word {
+				if !asmLabel.MatchString(name) {
+					return nil, fmt.Errorf("line %d: invalid label name: %s", i+1, name)
+				}
+				if _, ok := labels[name]; ok {
+					return nil, fmt.Errorf("line %d: label %s already defined", i+1, name)
+				}
+				labels[name] = len(program)
+				continue
+			}
+
+			ins := &asmInstruction{line: i + 1}
+			if word == "PUSH" {
+				ins.op, ins.auto = PUSH1, true
+			} else if op, ok := StringToOp(word); ok {
+				ins.op = op
+			} else {
+				return nil, fmt.Errorf("line %d: unknown opcode: %s", i+1, word)
+			}
+			if ins.op.IsPush() {
+				if len(words) == 0 {
+					return nil, fmt.Errorf("line %d: missing value for %s", i+1, word)
+				}
+				value := words[0]
+				words = words[1:]
+				if asmNumber.MatchString(value) {
+					ins.value = parseAsmNumber(value)
+				} else if asmLabel.MatchString(value) {
+					ins.label = value
+				} else {
+					return nil, fmt.Errorf("line %d: invalid value %s for %s", i+1, value, word)
+				}
+			}
+			program = append(program, ins)
+		}
+	}
+	for _, ins := range program {
+		if _, ok := labels[ins.label]; ins.label != "" && !ok {
+			return nil, fmt.Errorf("line %d: undefined label: %s", ins.line, ins.label)
+		}
+	}
+
+	// Lay out the program. Labels start at the offsets they'd have with one
+	// byte pushes, and move forward as long as pushing them needs more.
+	offsets := make(map[string]uint64, len(labels))
+	for {
+		pcs := make([]uint64, len(program)+1)
+		for i, ins := range program {
+			pcs[i+1] = pcs[i] + 1 + uint64(ins.size(offsets))
+		}
+		changed := false
+		for name, idx := range labels {
+			if offsets[name] != pcs[idx] {
+				offsets[name] = pcs[idx]
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+
+	var code []byte
+	for _, ins := range program {
+		size := ins.size(offsets)
+		if !ins.op.IsPush() {
+			code = append(code, byte(ins.op))
+			continue
+		}
+		value := ins.value
+		if ins.label != "" {
+			value = new(big.Int).SetUint64(offsets[ins.label])
+		}
+		if value.BitLen() > 8*size {
+			return nil, fmt.Errorf("line %d: value %v is not in range for PUSH%d", ins.line, value, size)
+		}
+		code = append(code, byte(PUSH1)+byte(size-1))
+		code = append(code, value.FillBytes(make([]byte, size))...)
+	}
+	return code, nil
+}
+
+// asmCommand prints the hex encoded bytecode of the assembly given as
+// arguments, one instruction per argument, read from a file with -f, or from
+// stdin.
+func asmCommand(args []string) int {
+	fs := flag.NewFlagSet("asm", flag.ContinueOnError)
+	file := fs.String("f", "", "read the assembly from `file`, - for stdin")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: asm [-f file | instructions...]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	var src string
+	switch {
+	case fs.NArg() > 0:
+		src = strings.Join(fs.Args(), "\n")
+	case *file != "" && *file != "-":
+		data, err := os.ReadFile(*file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error reading assembly:", err)
+			return 1
+		}
+		src = string(data)
+	default:
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error reading assembly:", err)
+			return 1
+		}
+		src = string(data)
+	}
+
+	code, err := Assemble(src)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error assembling:", err)
+		return 1
+	}
+	fmt.Println(hex.EncodeToString(code))
+	return 0
+}
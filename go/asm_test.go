@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// TestAssembleMatchesJS assembles the code of the test cases in evm.json,
+// which scripts/assembler.js assembled from scripts/evm.yaml.
+func TestAssembleMatchesJS(t *testing.T) {
+	tests, err := LoadTestCases("../evm.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(name string, c code) {
+		if c.Asm == "" {
+			return
+		}
+		bin, err := Assemble(c.Asm)
+		if err != nil {
+			t.Errorf("%v: %v", name, err)
+			return
+		}
+		if got := hex.EncodeToString(bin); got != c.Bin {
+			t.Errorf("%v: assembled %v, want %v", name, got, c.Bin)
+		}
+	}
+	for _, test := range tests {
+		check(test.Name, test.Code)
+		for addr, account := range test.State {
+			check(test.Name+" "+hexAddress(addr), account.Code)
+		}
+	}
+}
+
+// TestLoadYAMLMatchesJSON checks that loading scripts/evm.yaml gives the
+// code scripts/index.js generated evm.json with.
+func TestLoadYAMLMatchesJSON(t *testing.T) {
+	want, err := LoadTestCases("../evm.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := LoadTestCases("../scripts/evm.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d test cases, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].Name != want[i].Name || got[i].Code != want[i].Code {
+			t.Errorf("test case %d: got %v %+v, want %v %+v", i, got[i].Name, got[i].Code, want[i].Name, want[i].Code)
+		}
+	}
+}
+
+func TestAssemble(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"PUSH1 1 PUSH1 2 ADD", "6001600201"},
+		{"PUSH2 0x0102", "610102"},
+		{"PUSH1 0XFF", "60ff"},
+		// Leading zeros don't make the number octal, unlike in Go
+		{"PUSH1 010", "600a"},
+		{"PUSH1 0b1", "6001"},
+		{"PUSH1 0o7", "6007"},
+		{"PUSH1 0B11 PUSH1 0O17", "6003600f"},
+		// Anything but letters and digits separates words
+		{"PUSH1 1; PUSH1 2", "60016002"},
+		{"PUSH1 1,PUSH1 2\tADD", "6001600201"},
+		{"PUSH 0x123456", "62123456"},
+		{"PUSH 0", "6000"},
+		{"start: JUMPDEST PUSH start JUMP", "5b600056"},
+		{"PUSH end JUMP\nend: JUMPDEST", "6003565b"},
+		{"PUSH end_of_code JUMP STOP end_of_code: JUMPDEST", "600456005b"},
+	}
+	for _, test := range tests {
+		bin, err := Assemble(test.src)
+		if err != nil {
+			t.Errorf("%q: %v", test.src, err)
+			continue
+		}
+		if got := hex.EncodeToString(bin); got != test.want {
+			t.Errorf("%q: got %v, want %v", test.src, got, test.want)
+		}
+	}
+}
+
+func TestAssembleErrors(t *testing.T) {
+	// Immediates BigInt doesn't parse or that are out of range, missing
+	// immediates, labels and opcodes
+	tests := []string{
+		"PUSH1 1_000",
+		"PUSH1 0b2",
+		"PUSH1 0o8",
+		"PUSH1 0x",
+		"PUSH1 0b",
+		"PUSH1 0xg",
+		"PUSH1 256",
+		"PUSH1",
+		"PUSH1 nowhere",
+		"FOO",
+	}
+	for _, src := range tests {
+		if bin, err := Assemble(src); err == nil {
+			t.Errorf("%q: assembled %x, want an error", src, bin)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/holiman/uint256"
+)
+
+// CallFrame is a call frame recorded by the CallTracer, with the frames of
+// the calls it made. It has the JSON shape of geth's callTracer.
+type CallFrame struct {
+	Type         string       `json:"type"`
+	From         string       `json:"from"`
+	To           string       `json:"to,omitempty"`
+	Value        string       `json:"value,omitempty"`
+	Gas          string       `json:"gas"`
+	GasUsed      string       `json:"gasUsed"`
+	Input        string       `json:"input"`
+	Output       string       `json:"output,omitempty"`
+	Error        string       `json:"error,omitempty"`
+	RevertReason string       `json:"revertReason,omitempty"`
+	Calls        []*CallFrame `json:"calls,omitempty"`
+
+	create bool
+}
+
+func newCallFrame(typ OpCode, from, to Address, input []byte, gas uint64, value *uint256.Int) *CallFrame {
+	frame := &CallFrame{
+		Type:   typ.String(),
+		From:   hexAddress(from),
+		To:     hexAddress(to),
+		Gas:    fmt.Sprintf("0x%x", gas),
+		Input:  "0x" + hex.EncodeToString(input),
+		create: typ == CREATE || typ == CREATE2,
+	}
+	// Delegate and static calls don't transfer value
+	if value != nil {
+		frame.Value = value.Hex()
+	}
+	return frame
+}
+
+// end records the outcome of the frame. The output of a failed frame is only
+// kept when it reverted, along with the reason it reverted with, decoded with
+// the custom errors of errs.
+func (f *CallFrame) end(output []byte, gasUsed uint64, err error, errs *abi.ABI) {
+	f.GasUsed = fmt.Sprintf("0x%x", gasUsed)
+	if err == nil {
+		if len(output) > 0 {
+			f.Output = "0x" + hex.EncodeToString(output)
+		}
+		return
+	}
+	f.Error = err.Error()
+	// A failed creation creates no contract
+	if f.create {
+		f.To = ""
+	}
+	if err != ErrExecutionReverted || len(output) == 0 {
+		return
+	}
+	f.Output = "0x" + hex.EncodeToString(output)
+	f.RevertReason = revertReason(err, output, errs)
+}
+
+// hexAddress returns the lowercase hex of addr, the way geth's tracers
+// write addresses.
+func hexAddress(addr Address) string {
+	return "0x" + hex.EncodeToString(addr[:])
+}
+
+// CallTracer is a Tracer recording the calls, creations and self-destructs
+// of a transaction as a tree of CallFrames. The tree is written to w as JSON
+// once the transaction is done.
+type CallTracer struct {
+	w io.Writer
+
+	// callstack holds the frames being executed, the outermost first
+	callstack []*CallFrame
+	result    *CallFrame
+	errs      *abi.ABI
+}
+
+// NewCallTracer returns a CallTracer writing the call tree of every
+// transaction to w, or not writing it when w is nil.
+func NewCallTracer(w io.Writer) *CallTracer {
+	return &CallTracer{w: w}
+}
+
+// Result returns the call tree of the last transaction.
+func (t *CallTracer) Result() *CallFrame {
+	return t.result
+}
+
+func (t *CallTracer) CaptureTxStart(gasLimit uint64) {}
+
+func (t *CallTracer) CaptureTxEnd(restGas uint64) {}
+
+func (t *CallTracer) CaptureStart(vm *VM, from Address, to Address, create bool, input []byte, gas uint64, value *uint256.Int) {
+	typ := CALL
+	if create {
+		typ = CREATE
+	}
+	t.callstack = []*CallFrame{newCallFrame(typ, from, to, input, gas, value)}
+	t.errs = vm.Config.ABI
+}
+
+func (t *CallTracer) CaptureState(pc uint64, op OpCode, gas, cost uint64, scope *executionContext, rData []byte, depth int, err error) {
+}
+
+func (t *CallTracer) CaptureFault(pc uint64, op OpCode, gas, cost uint64, scope *executionContext, depth int, err error) {
+}
+
+func (t *CallTracer) CaptureEnd(output []byte, gasUsed uint64, err error) {
+	t.result = t.callstack[0]
+	t.result.end(output, gasUsed, err, t.errs)
+	t.callstack = nil
+	if t.w == nil {
+		return
+	}
+	enc := json.NewEncoder(t.w)
+	enc.SetIndent("", "  ")
+	enc.Encode(t.result)
+}
+
+func (t *CallTracer) CaptureEnter(typ OpCode, from Address, to Address, input []byte, gas uint64, value *uint256.Int) {
+	t.callstack = append(t.callstack, newCallFrame(typ, from, to, input, gas, value))
+}
+
+func (t *CallTracer) CaptureExit(output []byte, gasUsed uint64, err error) {
+	size := len(t.callstack)
+	frame := t.callstack[size-1]
+	frame.end(output, gasUsed, err, t.errs)
+	t.callstack = t.callstack[:size-1]
+	parent := t.callstack[size-2]
+	parent.Calls = append(parent.Calls, frame)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// TestCallTracerTree traces a contract that calls a contract reverting with
+// Error("no"), creates a contract which self-destructs while being created,
+// and then calls with more value than it has.
+func TestCallTracerTree(t *testing.T) {
+	reverter := HexToAddress("0x3000000000000000000000000000000000000003")
+	state := newTestState(t, GenesisAlloc{
+		testSender: {Balance: "1000000000"},
+		testRecipient: {Balance: "10", Code: code{Bin: hex.EncodeToString(assemble(t, `
+			PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 0 PUSH20 0x3000000000000000000000000000000000000003 GAS CALL POP
+			PUSH3 0x60bbff PUSH1 0 MSTORE PUSH1 3 PUSH1 29 PUSH1 5 CREATE POP
+			PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 0 PUSH2 1000 PUSH1 0xaa GAS CALL POP
+			STOP`))}},
+		reverter: {Code: code{Bin: hex.EncodeToString(assemble(t, `
+			PUSH4 0x08c379a0 PUSH1 0xe0 SHL PUSH1 0 MSTORE
+			PUSH1 0x20 PUSH1 4 MSTORE
+			PUSH1 2 PUSH1 0x24 MSTORE
+			PUSH2 0x6e6f PUSH1 0xf0 SHL PUSH1 0x44 MSTORE
+			PUSH1 0x64 PUSH1 0 REVERT`))}},
+	})
+	var out bytes.Buffer
+	tx := &Transaction{To: &testRecipient, From: testSender, Gas: "200000", GasPrice: "1", Data: "c0ffee"}
+	if _, err := ApplyTransaction(state, &Block{}, tx, Config{Tracer: NewCallTracer(&out)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The top frame has the gas left after the intrinsic gas of 21048. The
+	// self-destruct pays for the new account 0xbb, the transfer that can't
+	// be paid for fails before the callee runs.
+	want := `{
+  "type": "CALL",
+  "from": "0x1000000000000000000000000000000000000001",
+  "to": "0x2000000000000000000000000000000000000002",
+  "value": "0x0",
+  "gas": "0x2bb08",
+  "gasUsed": "0x18d06",
+  "input": "0xc0ffee",
+  "calls": [
+    {
+      "type": "CALL",
+      "from": "0x2000000000000000000000000000000000000002",
+      "to": "0x3000000000000000000000000000000000000003",
+      "value": "0x0",
+      "gas": "0x2a609",
+      "gasUsed": "0x42",
+      "input": "0x",
+      "output": "0x08c379a0000000000000000000000000000000000000000000000000000000000000002000000000000000000000000000000000000000000000000000000000000000026e6f000000000000000000000000000000000000000000000000000000000000",
+      "error": "execution reverted",
+      "revertReason": "no"
+    },
+    {
+      "type": "CREATE",
+      "from": "0x2000000000000000000000000000000000000002",
+      "to": "0x092cac6db1de08ae9590c7528a5e050fea367552",
+      "value": "0x5",
+      "gas": "0x22aa4",
+      "gasUsed": "0x7f5b",
+      "input": "0x60bbff",
+      "calls": [
+        {
+          "type": "SELFDESTRUCT",
+          "from": "0x092cac6db1de08ae9590c7528a5e050fea367552",
+          "to": "0x00000000000000000000000000000000000000bb",
+          "value": "0x5",
+          "gas": "0x0",
+          "gasUsed": "0x0",
+          "input": "0x"
+        }
+      ]
+    },
+    {
+      "type": "CALL",
+      "from": "0x2000000000000000000000000000000000000002",
+      "to": "0x00000000000000000000000000000000000000aa",
+      "value": "0x3e8",
+      "gas": "0x12970",
+      "gasUsed": "0x0",
+      "input": "0x",
+      "error": "insufficient balance for transfer"
+    }
+  ]
+}
+`
+	if out.String() != want {
+		t.Errorf("got\n%v\nwant\n%v", out.String(), want)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// precompiledTest is a test vector of a precompiled contract, in the format
+// of the files in testdata/precompiles.
+type precompiledTest struct {
+	Input, Expected string
+	Gas             uint64
+	Name            string
+}
+
+// precompiledFailureTest is a test vector of a precompiled contract that
+// fails, in the format of the fail-*.json files in testdata/precompiles.
+type precompiledFailureTest struct {
+	Input, ExpectedError, Name string
+}
+
+// loadPrecompiledTests decodes the vectors of testdata/precompiles/name.json
+// into v.
+func loadPrecompiledTests(t *testing.T, name string, v interface{}) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "precompiles", name+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		t.Fatalf("%v: %v", name, err)
+	}
+}
+
+// testPrecompiled runs the vector against the precompiled contract at addr,
+// with the gas it requires and with one less.
+func testPrecompiled(t *testing.T, addr byte, test precompiledTest) {
+	t.Helper()
+	p := PrecompiledContracts[BytesToAddress([]byte{addr})]
+	input, err := hex.DecodeString(test.Input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gas := p.RequiredGas(input); gas != test.Gas {
+		t.Errorf("%v: got required gas %d, want %d", test.Name, gas, test.Gas)
+	}
+	ret, gasLeft, err := RunPrecompiledContract(p, input, test.Gas)
+	if err != nil {
+		t.Fatalf("%v: %v", test.Name, err)
+	}
+	if got := hex.EncodeToString(ret); got != test.Expected {
+		t.Errorf("%v: got %v, want %v", test.Name, got, test.Expected)
+	}
+	if gasLeft != 0 {
+		t.Errorf("%v: got %d gas left, want 0", test.Name, gasLeft)
+	}
+	if test.Gas > 0 {
+		if _, _, err := RunPrecompiledContract(p, input, test.Gas-1); !errors.Is(err, ErrOutOfGas) {
+			t.Errorf("%v: got error %v with too little gas, want %v", test.Name, err, ErrOutOfGas)
+		}
+	}
+}
+
+func TestPrecompiledContracts(t *testing.T) {
+	tests := []struct {
+		addr byte
+		name string
+	}{
+		{1, "ecRecover"},
+		{5, "modexp_eip2565"},
+		{6, "bn256Add"},
+		{7, "bn256ScalarMul"},
+		{8, "bn256Pairing"},
+		{9, "blake2F"},
+		{10, "pointEvaluation"},
+	}
+	for _, test := range tests {
+		var vectors []precompiledTest
+		loadPrecompiledTests(t, test.name, &vectors)
+		if len(vectors) == 0 {
+			t.Fatalf("%v: no test vectors", test.name)
+		}
+		for _, vector := range vectors {
+			testPrecompiled(t, test.addr, vector)
+		}
+	}
+}
+
+func TestPrecompiledFailures(t *testing.T) {
+	tests := []struct {
+		addr byte
+		name string
+	}{
+		{9, "fail-blake2f"},
+		{10, "fail-pointEvaluation"},
+	}
+	for _, test := range tests {
+		var vectors []precompiledFailureTest
+		loadPrecompiledTests(t, test.name, &vectors)
+		if len(vectors) == 0 {
+			t.Fatalf("%v: no test vectors", test.name)
+		}
+		p := PrecompiledContracts[BytesToAddress([]byte{test.addr})]
+		for _, vector := range vectors {
+			input, err := hex.DecodeString(vector.Input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, gasLeft, err := RunPrecompiledContract(p, input, p.RequiredGas(input))
+			// The errors may carry details after the expected message
+			if err == nil || !strings.HasPrefix(err.Error(), vector.ExpectedError) {
+				t.Errorf("%v: got error %v, want %v", vector.Name, err, vector.ExpectedError)
+			}
+			if gasLeft != 0 {
+				t.Errorf("%v: got %d gas left, want 0", vector.Name, gasLeft)
+			}
+		}
+	}
+}
+
+func TestPrecompiledHashes(t *testing.T) {
+	const abc = "616263"
+	tests := []struct {
+		addr byte
+		test precompiledTest
+	}{
+		{2, precompiledTest{Name: "sha256 empty", Expected: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", Gas: 60}},
+		{2, precompiledTest{Name: "sha256 abc", Input: abc, Expected: "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad", Gas: 72}},
+		{3, precompiledTest{Name: "ripemd160 empty", Expected: "0000000000000000000000009c1185a5c5e9fc54612808977ee8f548b2258d31", Gas: 600}},
+		{3, precompiledTest{Name: "ripemd160 abc", Input: abc, Expected: "0000000000000000000000008eb208f7e05d987a9b044a8e98c6b087f15a0bfc", Gas: 720}},
+		{4, precompiledTest{Name: "identity empty", Gas: 15}},
+		{4, precompiledTest{Name: "identity abc", Input: abc, Expected: abc, Gas: 18}},
+		// 33 bytes are 2 words
+		{4, precompiledTest{Name: "identity 2 words", Input: strings.Repeat("ab", 33), Expected: strings.Repeat("ab", 33), Gas: 21}},
+	}
+	for _, test := range tests {
+		testPrecompiled(t, test.addr, test.test)
+	}
+}
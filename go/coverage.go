@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/holiman/uint256"
+)
+
+// branchCoverage is the way a JUMPI went, when it executed.
+type branchCoverage struct {
+	taken, notTaken bool
+}
+
+// codeCoverage is what executed of a code.
+type codeCoverage struct {
+	// address is the first account seen running the code
+	address  Address
+	code     []byte
+	executed map[uint64]bool
+	jumpis   map[uint64]*branchCoverage
+}
+
+// basicBlock is a run of instructions that execute together: the first
+// instructions[start:end] of a code.
+type basicBlock struct {
+	start, end int
+	reachable  bool
+}
+
+// terminates reports whether ins ends the execution of its basic block
+// without falling through to the next.
+func terminates(ins disasmInstruction) bool {
+	switch ins.Op {
+	case STOP, JUMP, RETURN, REVERT, INVALID, SELFDESTRUCT:
+		return true
+	}
+	return !ins.Valid()
+}
+
+// basicBlocks splits instructions at JUMPDESTs and after the instructions
+// that jump or halt. A block is reachable when it is at the start of the
+// code, starts with a JUMPDEST or is fallen through to from a reachable
+// block, so that the data at the end of contracts is not counted.
+func basicBlocks(instructions []disasmInstruction) []basicBlock {
+	var blocks []basicBlock
+	start := 0
+	for i, ins := range instructions {
+		if ins.Op == JUMPDEST && i > start {
+			blocks = append(blocks, basicBlock{start: start, end: i})
+			start = i
+		}
+		if terminates(ins) || ins.Op == JUMPI {
+			blocks = append(blocks, basicBlock{start: start, end: i + 1})
+			start = i + 1
+		}
+	}
+	if start < len(instructions) {
+		blocks = append(blocks, basicBlock{start: start, end: len(instructions)})
+	}
+	for i := range blocks {
+		switch {
+		case i == 0, instructions[blocks[i].start].Op == JUMPDEST:
+			blocks[i].reachable = true
+		default:
+			prev := blocks[i-1]
+			blocks[i].reachable = prev.reachable && !terminates(instructions[prev.end-1])
+		}
+	}
+	return blocks
+}
+
+// Coverage is a Tracer recording the pcs executed of every code, and the
+// way the JUMPIs went, over all the transactions it observes. Codes are
+// told apart by their hash, whatever account runs them.
+type Coverage struct {
+	codes map[Hash]*codeCoverage
+	// order holds the hashes of the codes in the order they first ran
+	order []Hash
+
+	// frames holds the code of the frames being executed, set as they run
+	// their first opcode
+	frames []*codeCoverage
+	// sources are the source maps of the last transaction that had some
+	sources *SourceMaps
+}
+
+// NewCoverage returns an empty Coverage.
+func NewCoverage() *Coverage {
+	return &Coverage{codes: make(map[Hash]*codeCoverage)}
+}
+
+func (c *Coverage) CaptureTxStart(gasLimit uint64) {}
+
+func (c *Coverage) CaptureTxEnd(restGas uint64) {}
+
+func (c *Coverage) CaptureStart(vm *VM, from Address, to Address, create bool, input []byte, gas uint64, value *uint256.Int) {
+	c.frames = []*codeCoverage{nil}
+	if vm.Config.Sources != nil {
+		c.sources = vm.Config.Sources
+	}
+}
+
+func (c *Coverage) CaptureState(pc uint64, op OpCode, gas, cost uint64, scope *executionContext, rData []byte, depth int, err error) {
+	if err != nil {
+		return
+	}
+	frame := c.frames[len(c.frames)-1]
+	if frame == nil {
+		hash := BytesToHash(crypto.Keccak256(scope.code))
+		if frame = c.codes[hash]; frame == nil {
+			frame = &codeCoverage{
+				address:  scope.contract.Address,
+				code:     scope.code,
+				executed: make(map[uint64]bool),
+				jumpis:   make(map[uint64]*branchCoverage),
+			}
+			c.codes[hash] = frame
+			c.order = append(c.order, hash)
+		}
+		c.frames[len(c.frames)-1] = frame
+	}
+	frame.executed[pc] = true
+	if op == JUMPI {
+		branch, ok := frame.jumpis[pc]
+		if !ok {
+			branch = &branchCoverage{}
+			frame.jumpis[pc] = branch
+		}
+		if scope.stack.Back(1).IsZero() {
+			branch.notTaken = true
+		} else {
+			branch.taken = true
+		}
+	}
+}
+
+func (c *Coverage) CaptureFault(pc uint64, op OpCode, gas, cost uint64, scope *executionContext, depth int, err error) {
+}
+
+func (c *Coverage) CaptureEnd(output []byte, gasUsed uint64, err error) {
+	c.frames = nil
+}
+
+func (c *Coverage) CaptureEnter(typ OpCode, from Address, to Address, input []byte, gas uint64, value *uint256.Int) {
+	c.frames = append(c.frames, nil)
+}
+
+func (c *Coverage) CaptureExit(output []byte, gasUsed uint64, err error) {
+	c.frames = c.frames[:len(c.frames)-1]
+}
+
+// count returns the number of reachable instructions of cov, and how many
+// of them executed.
+func (cov *codeCoverage) count(instructions []disasmInstruction, blocks []basicBlock) (executed, reachable int) {
+	for _, block := range blocks {
+		if !block.reachable {
+			continue
+		}
+		for _, ins := range instructions[block.start:block.end] {
+			reachable++
+			if cov.executed[ins.PC] {
+				executed++
+			}
+		}
+	}
+	return executed, reachable
+}
+
+// WriteSummary writes the share of the reachable instructions that executed,
+// over all the codes.
+func (c *Coverage) WriteSummary(w io.Writer) {
+	var executed, reachable int
+	for _, hash := range c.order {
+		cov := c.codes[hash]
+		instructions := Disassemble(cov.code)
+		e, r := cov.count(instructions, basicBlocks(instructions))
+		executed, reachable = executed+e, reachable+r
+	}
+	fmt.Fprintf(w, "Coverage: %.1f%% of %d reachable instructions in %d codes\n", percent(uint64(executed), uint64(reachable)), reachable, len(c.order))
+}
+
+// WriteReport writes the coverage of every code, in the order they first
+// ran: the share of its reachable instructions that executed, the reachable
+// basic blocks that never did and the JUMPIs that only went one way.
+func (c *Coverage) WriteReport(w io.Writer) error {
+	for _, hash := range c.order {
+		cov := c.codes[hash]
+		instructions := Disassemble(cov.code)
+		blocks := basicBlocks(instructions)
+		executed, reachable := cov.count(instructions, blocks)
+		fmt.Fprintf(w, "Code %v run by %v: %d of %d reachable instructions, %.1f%%\n", hash, cov.address, executed, reachable, percent(uint64(executed), uint64(reachable)))
+
+		var unexecuted []basicBlock
+		for _, block := range blocks {
+			if block.reachable && !cov.executed[instructions[block.start].PC] {
+				unexecuted = append(unexecuted, block)
+			}
+		}
+		if len(unexecuted) > 0 {
+			fmt.Fprintln(w, "  Blocks never executed:")
+			for _, block := range unexecuted {
+				first, last := instructions[block.start], instructions[block.end-1]
+				if block.end-block.start == 1 {
+					fmt.Fprintf(w, "    %05x: %v%v\n", first.PC, first, c.at(cov.code, first.PC))
+				} else {
+					fmt.Fprintf(w, "    %05x-%05x: %v ... %v%v\n", first.PC, last.PC, first, last, c.at(cov.code, first.PC))
+				}
+			}
+		}
+
+		var oneWay []string
+		for _, ins := range instructions {
+			branch, ok := cov.jumpis[ins.PC]
+			switch {
+			case !ok:
+			case !branch.taken:
+				oneWay = append(oneWay, fmt.Sprintf("    %05x: never taken%v", ins.PC, c.at(cov.code, ins.PC)))
+			case !branch.notTaken:
+				oneWay = append(oneWay, fmt.Sprintf("    %05x: always taken%v", ins.PC, c.at(cov.code, ins.PC)))
+			}
+		}
+		if len(oneWay) > 0 {
+			fmt.Fprintln(w, "  JUMPIs going one way only:")
+			for _, line := range oneWay {
+				fmt.Fprintln(w, line)
+			}
+		}
+		if _, err := fmt.Fprintln(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// at describes the source position of the instruction at pc of code, if
+// known.
+func (c *Coverage) at(code []byte, pc uint64) string {
+	if pos, ok := c.sources.Position(code, pc); ok {
+		return fmt.Sprintf(", at %v", pos)
+	}
+	return ""
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestBasicBlocks(t *testing.T) {
+	// 00 PUSH1 1, 02 PUSH1 9, 04 JUMPI, 05 PUSH1 2, 07 STOP, 08 ADD,
+	// 09 JUMPDEST, 0a STOP, then a data tail read as 0b LOG2, 0c an invalid
+	// opcode and 0d a truncated PUSH1
+	code, _ := hex.DecodeString("600160095760020001" + "5b00" + "a20c60")
+	want := []basicBlock{
+		{start: 0, end: 3, reachable: true},
+		// Fallen through to from the JUMPI
+		{start: 3, end: 5, reachable: true},
+		// The ADD after the STOP can't be reached
+		{start: 5, end: 6},
+		// A JUMPDEST after a STOP can be jumped to
+		{start: 6, end: 8, reachable: true},
+		// Neither can the data tail, even past the invalid opcode ending it
+		{start: 8, end: 10},
+		{start: 10, end: 11},
+	}
+	got := basicBlocks(Disassemble(code))
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got blocks %v, want %v", got, want)
+	}
+	if got := basicBlocks(nil); len(got) != 0 {
+		t.Errorf("got blocks %v for no code, want none", got)
+	}
+}
+
+// coverageCode has a JUMPI at 0x05 going the way of its calldata, one at
+// 0x0d always taken and one at 0x14 never taken:
+//
+//	00 PUSH1 0, CALLDATALOAD, PUSH1 8, JUMPI, PUSH1 1
+//	08 JUMPDEST, PUSH1 1, PUSH1 0x0f, JUMPI, STOP
+//	0f JUMPDEST, PUSH1 0, PUSH1 0x16, JUMPI, STOP
+//	16 JUMPDEST, STOP
+const coverageCode = "6000356008576001" + "5b6001600f5700" + "5b600060165700" + "5b00"
+
+func TestCoverageJumpis(t *testing.T) {
+	bin, _ := hex.DecodeString(coverageCode)
+	state := NewStateDB()
+	coverage := NewCoverage()
+	// The first JUMPI goes both ways over the two transactions
+	for _, data := range []string{"00", "01"} {
+		tx := &Transaction{To: &testRecipient, From: testSender, Data: data}
+		if _, err := evm(bin, tx, state, &Block{}, Config{Tracer: coverage}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cov := coverage.codes[BytesToHash(crypto.Keccak256(bin))]
+	if cov == nil || len(coverage.order) != 1 {
+		t.Fatalf("got %d codes, want the one code run twice", len(coverage.order))
+	}
+	for pc, want := range map[uint64]branchCoverage{
+		0x05: {taken: true, notTaken: true},
+		0x0d: {taken: true},
+		0x14: {notTaken: true},
+	} {
+		if branch := cov.jumpis[pc]; branch == nil || *branch != want {
+			t.Errorf("JUMPI at %#x: got %+v, want %+v", pc, branch, want)
+		}
+	}
+
+	var report bytes.Buffer
+	if err := coverage.WriteReport(&report); err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("Code %v run by %v: 14 of 17 reachable instructions, 82.4%%\n", BytesToHash(crypto.Keccak256(bin)), testRecipient) +
+		"  Blocks never executed:\n" +
+		"    0000e: STOP\n" +
+		"    00016-00017: JUMPDEST ... STOP\n" +
+		"  JUMPIs going one way only:\n" +
+		"    0000d: always taken\n" +
+		"    00014: never taken\n\n"
+	if report.String() != want {
+		t.Errorf("got report\n%v\nwant\n%v", report.String(), want)
+	}
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/holiman/uint256"
+)
+
+// debugMode is how the Debugger runs until it stops again.
+type debugMode int
+
+const (
+	debugStep     debugMode = iota // stop after a number of opcodes
+	debugNext                      // stop at the next opcode of the frame, stepping over calls
+	debugContinue                  // stop at a breakpoint
+	debugQuit                      // run to the end without stopping
+)
+
+// breakpoint stops the execution at a pc, at any depth, or at an opcode.
+type breakpoint struct {
+	pc   uint64
+	op   OpCode
+	isOp bool
+}
+
+func (b breakpoint) String() string {
+	if b.isOp {
+		return b.op.String()
+	}
+	return fmt.Sprintf("pc %05x", b.pc)
+}
+
+func (b breakpoint) matches(pc uint64, op OpCode) bool {
+	if b.isOp {
+		return op == b.op
+	}
+	return pc == b.pc
+}
+
+// Debugger is a Tracer pausing the execution before opcodes to inspect it,
+// reading commands from in. It stops before the first opcode and on faults.
+type Debugger struct {
+	in  *bufio.Scanner
+	out io.Writer
+
+	mode        debugMode
+	steps       int // opcodes left to step over in debugStep mode
+	depth       int // the depth of the frame to stop in, in debugNext mode
+	breakpoints []breakpoint
+	last        string // the last command, repeated by an empty line
+	sources     *SourceMaps
+}
+
+// NewDebugger returns a Debugger reading commands from in and writing to out.
+func NewDebugger(in io.Reader, out io.Writer) *Debugger {
+	return &Debugger{in: bufio.NewScanner(in), out: out, mode: debugStep, steps: 1}
+}
+
+func (d *Debugger) CaptureTxStart(gasLimit uint64) {}
+
+func (d *Debugger) CaptureTxEnd(restGas uint64) {}
+
+func (d *Debugger) CaptureStart(vm *VM, from Address, to Address, create bool, input []byte, gas uint64, value *uint256.Int) {
+	d.sources = vm.Config.Sources
+}
+
+func (d *Debugger) CaptureState(pc uint64, op OpCode, gas, cost uint64, scope *executionContext, rData []byte, depth int, err error) {
+	if err != nil {
+		d.stop(pc, gas, cost, scope, fmt.Sprintf("fault: %v", err))
+		return
+	}
+	var stop bool
+	switch d.mode {
+	case debugStep:
+		d.steps--
+		stop = d.steps <= 0
+	case debugNext:
+		stop = depth <= d.depth
+	case debugQuit:
+		return
+	}
+	for _, b := range d.breakpoints {
+		if b.matches(pc, op) {
+			stop = true
+		}
+	}
+	if stop {
+		d.stop(pc, gas, cost, scope, "")
+	}
+}
+
+func (d *Debugger) CaptureFault(pc uint64, op OpCode, gas, cost uint64, scope *executionContext, depth int, err error) {
+	d.stop(pc, gas, cost, scope, fmt.Sprintf("fault: %v", err))
+}
+
+func (d *Debugger) CaptureEnd(output []byte, gasUsed uint64, err error) {}
+
+func (d *Debugger) CaptureEnter(typ OpCode, from Address, to Address, input []byte, gas uint64, value *uint256.Int) {
+	if d.mode == debugStep {
+		fmt.Fprintf(d.out, "=> %v from %v to %v, gas %d, input 0x%x\n", typ, from, to, gas, input)
+	}
+}
+
+func (d *Debugger) CaptureExit(output []byte, gasUsed uint64, err error) {
+	if d.mode == debugStep {
+		fmt.Fprintf(d.out, "<= gas used %d, output 0x%x", gasUsed, output)
+		if err != nil {
+			fmt.Fprintf(d.out, ", error: %v", err)
+		}
+		fmt.Fprintln(d.out)
+	}
+}
+
+// stop shows where the execution is and reads commands until one resumes it.
+func (d *Debugger) stop(pc, gas, cost uint64, scope *executionContext, note string) {
+	if d.mode == debugQuit {
+		return
+	}
+	d.printLocation(pc, gas, cost, scope)
+	if note != "" {
+		fmt.Fprintln(d.out, note)
+	}
+	for {
+		fmt.Fprint(d.out, "(debug) ")
+		if !d.in.Scan() {
+			// Without more commands, run to the end
+			fmt.Fprintln(d.out)
+			d.mode = debugQuit
+			return
+		}
+		line := strings.TrimSpace(d.in.Text())
+		if line == "" {
+			line = d.last
+		}
+		d.last = line
+		if d.command(line, pc, gas, cost, scope) {
+			return
+		}
+	}
+}
+
+// command runs a command typed at a stop, and returns whether it resumes
+// the execution.
+func (d *Debugger) command(line string, pc, gas, cost uint64, scope *executionContext) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return false
+	}
+	args := fields[1:]
+	switch fields[0] {
+	case "s", "step":
+		d.mode, d.steps = debugStep, 1
+		if len(args) > 0 {
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n < 1 {
+				fmt.Fprintf(d.out, "Invalid number of steps %q\n", args[0])
+				return false
+			}
+			d.steps = n
+		}
+		return true
+	case "n", "next":
+		d.mode, d.depth = debugNext, scope.depth
+		return true
+	case "c", "continue":
+		d.mode = debugContinue
+		return true
+	case "q", "quit":
+		d.mode = debugQuit
+		return true
+	case "b", "break":
+		if len(args) == 0 {
+			d.printBreakpoints()
+			return false
+		}
+		b, err := parseBreakpoint(args[0])
+		if err != nil {
+			fmt.Fprintln(d.out, err)
+			return false
+		}
+		d.breakpoints = append(d.breakpoints, b)
+		fmt.Fprintf(d.out, "Breakpoint %d at %v\n", len(d.breakpoints), b)
+	case "d", "delete":
+		if len(args) == 0 {
+			d.breakpoints = nil
+			return false
+		}
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 1 || n > len(d.breakpoints) {
+			fmt.Fprintf(d.out, "No breakpoint %q\n", args[0])
+			return false
+		}
+		d.breakpoints = append(d.breakpoints[:n-1], d.breakpoints[n:]...)
+	case "i", "info":
+		d.printLocation(pc, gas, cost, scope)
+	case "l", "list":
+		d.printCode(pc, scope.code)
+	case "st", "stack":
+		printStack(d.out, scope.stack.data)
+	case "m", "memory":
+		printMemory(d.out, scope.memory.data)
+	case "sto", "storage":
+		printStorage(d.out, scope.state.storage(scope.contract.Address))
+	case "r", "returndata":
+		fmt.Fprintf(d.out, "0x%x\n", scope.callReturnData)
+	case "h", "help":
+		fmt.Fprint(d.out, debugHelp)
+	default:
+		fmt.Fprintf(d.out, "Unknown command %q, type help for the list of commands\n", fields[0])
+	}
+	return false
+}
+
+const debugHelp = `Commands:
+  s, step [n]        execute the next n opcodes, 1 by default
+  n, next            execute the next opcode, stepping over calls
+  c, continue        run to the next breakpoint
+  b, break [pc|op]   stop at a pc or an opcode, list the breakpoints without argument
+  d, delete [n]      delete breakpoint n, all of them without argument
+  i, info            show the current opcode
+  l, list            show the code around the current opcode
+  st, stack          show the stack, its top first
+  m, memory          show the memory
+  sto, storage       show the storage of the current contract
+  r, returndata      show the data returned by the last call
+  q, quit            run to the end without stopping
+An empty line repeats the last command.
+`
+
+// parseBreakpoint parses a pc, in decimal or 0x prefixed hex, or an opcode.
+func parseBreakpoint(s string) (breakpoint, error) {
+	if op, ok := StringToOp(strings.ToUpper(s)); ok {
+		return breakpoint{op: op, isOp: true}, nil
+	}
+	pc, err := strconv.ParseUint(s, 0, 64)
+	if err != nil {
+		return breakpoint{}, fmt.Errorf("Invalid breakpoint %q, expected a pc or an opcode", s)
+	}
+	return breakpoint{pc: pc}, nil
+}
+
+func (d *Debugger) printBreakpoints() {
+	if len(d.breakpoints) == 0 {
+		fmt.Fprintln(d.out, "No breakpoints")
+	}
+	for i, b := range d.breakpoints {
+		fmt.Fprintf(d.out, "%d: %v\n", i+1, b)
+	}
+}
+
+func (d *Debugger) printLocation(pc, gas, cost uint64, scope *executionContext) {
+	fmt.Fprintf(d.out, "[%d] %05x: %-20v gas: %d, cost: %d", scope.depth, pc, instructionAt(scope.code, pc), gas, cost)
+	if pos, ok := d.sources.Position(scope.code, pc); ok {
+		fmt.Fprintf(d.out, ", at %v", pos)
+	}
+	fmt.Fprintln(d.out)
+}
+
+// printCode shows the instructions around pc.
+func (d *Debugger) printCode(pc uint64, code []byte) {
+	const context = 5
+	instructions := Disassemble(code)
+	current := sort.Search(len(instructions), func(i int) bool { return instructions[i].PC >= pc })
+	for i := current - context; i <= current+context; i++ {
+		if i < 0 || i >= len(instructions) {
+			continue
+		}
+		marker := " "
+		if i == current {
+			marker = ">"
+		}
+		fmt.Fprintf(d.out, "%s %05x: %v\n", marker, instructions[i].PC, instructions[i])
+	}
+}
+
+// instructionAt decodes the instruction of code at pc.
+func instructionAt(code []byte, pc uint64) disasmInstruction {
+	if pc >= uint64(len(code)) {
+		return disasmInstruction{PC: pc, Op: STOP}
+	}
+	ins := Disassemble(code[pc:])[0]
+	ins.PC = pc
+	return ins
+}
+
+// printStack writes the stack items, its top first.
+func printStack(w io.Writer, stack []uint256.Int) {
+	if len(stack) == 0 {
+		fmt.Fprintln(w, "Empty stack")
+	}
+	for i := range stack {
+		fmt.Fprintf(w, "%4d: %v\n", i, stack[i].Hex())
+	}
+}
+
+// printMemory writes a hex dump of memory, one 32 bytes word per line.
+func printMemory(w io.Writer, memory []byte) {
+	if len(memory) == 0 {
+		fmt.Fprintln(w, "Empty memory")
+	}
+	for offset := 0; offset < len(memory); offset += 32 {
+		end := offset + 32
+		if end > len(memory) {
+			end = len(memory)
+		}
+		fmt.Fprintf(w, "%04x: %s\n", offset, hex.EncodeToString(memory[offset:end]))
+	}
+}
+
+// printStorage writes the slots of storage, ordered by key.
+func printStorage(w io.Writer, storage map[Hash]Hash) {
+	if len(storage) == 0 {
+		fmt.Fprintln(w, "Empty storage")
+	}
+	keys := make([]Hash, 0, len(storage))
+	for key := range storage {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Hex() < keys[j].Hex() })
+	for _, key := range keys {
+		fmt.Fprintf(w, "%v: %v\n", key, storage[key])
+	}
+}
+
+// debugCommand steps through the execution of the test case with the given
+// name, or of the bytecode given with -code.
+func debugCommand(args []string) int {
+	fs := flag.NewFlagSet("debug", flag.ContinueOnError)
+	tests := fs.String("tests", "../scripts/evm.yaml", "load the test case from `file`, scripts/evm.yaml or evm.json")
+	bytecode := fs.String("code", "", "debug the hex encoded `bytecode` instead of a test case")
+	tui := fs.Bool("tui", false, "show the execution full-screen, to step through it forwards and backwards")
+	abiFile := fs.String("abi", "", "decode the custom errors of revert data with the JSON ABI in `file`")
+	srcmap := fs.String("srcmap", "", "show source positions with the output of solc --combined-json bin-runtime,srcmap-runtime in `file`")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: debug [-tui] [-tests file] name | debug [-tui] -code hex")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	test := &TestCase{Name: "bytecode"}
+	switch {
+	case *bytecode != "" && fs.NArg() == 0:
+		test.Code.Bin = *bytecode
+	case *bytecode == "" && fs.NArg() > 0:
+		name := strings.Join(fs.Args(), " ")
+		var err error
+		if test, err = findTestCase(*tests, name); err != nil {
+			fmt.Fprintln(os.Stderr, "Error loading test case:", err)
+			return 1
+		}
+	default:
+		fs.Usage()
+		return 2
+	}
+
+	code, err := decodeBytecode(test.Code.Bin)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error decoding bytecode:", err)
+		return 1
+	}
+	state, err := NewStateDBFromAlloc(test.State)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid state:", err)
+		return 1
+	}
+	var config Config
+	if *srcmap != "" {
+		if config.Sources, err = LoadSourceMaps(*srcmap); err != nil {
+			fmt.Fprintln(os.Stderr, "Error loading source maps:", err)
+			return 1
+		}
+	}
+	if *abiFile != "" {
+		if config.ABI, err = LoadErrorsABI(*abiFile); err != nil {
+			fmt.Fprintln(os.Stderr, "Error loading ABI:", err)
+			return 1
+		}
+	}
+	if *tui {
+		return runTUI(test, code, state, config)
+	}
+
+	fmt.Printf("Debugging %v, type help for the list of commands\n", test.Name)
+	debugger := NewDebugger(os.Stdin, os.Stdout)
+	config.Tracer = debugger
+	res, err := evm(code, &test.Tx, state, &test.Block, config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+
+	fmt.Println("Success:", res.Success)
+	if res.Err != nil {
+		fmt.Println("Error:", res.Err)
+	}
+	fmt.Println("Return:", "0x"+res.Return)
+	if ret, err := hex.DecodeString(res.Return); err == nil {
+		if reason := revertReason(res.Err, ret, config.ABI); reason != "" {
+			fmt.Println("Revert reason:", reason)
+		}
+	}
+	fmt.Println("Gas used:", res.GasUsed)
+	fmt.Println("Stack:")
+	printStack(os.Stdout, res.Stack)
+	return 0
+}
+
+// findTestCase loads the test case with the given name from path.
+func findTestCase(path, name string) (*TestCase, error) {
+	tests, err := LoadTestCases(path)
+	if err != nil {
+		return nil, err
+	}
+	for i := range tests {
+		if tests[i].Name == name {
+			return &tests[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no test case named %q in %v", name, path)
+}
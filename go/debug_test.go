@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// debugLocation matches the location the debugger shows as it stops, the
+// depth and the pc.
+var debugLocation = regexp.MustCompile(`\[(\d+)\] ([0-9a-f]{5}):`)
+
+// debugStops runs the debugger with the given commands over code that calls
+// a contract at 0xc42, and returns where it stopped as depth:pc, the pc in
+// hex. The code has a CALL at 0xe, a POP at 0xf, PUSH1s at 0x10 and 0x12 and
+// an ADD at 0x14, the callee a POP at 2.
+func debugStops(t *testing.T, commands string) []string {
+	t.Helper()
+	state := newTestState(t, GenesisAlloc{
+		HexToAddress("0xc42"): {Code: code{Bin: hex.EncodeToString(assemble(t, "PUSH1 3 POP STOP"))}},
+	})
+	asm := "PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 0 PUSH2 0x0c42 GAS CALL POP PUSH1 1 PUSH1 2 ADD STOP"
+	var out bytes.Buffer
+	tx := &Transaction{To: &testRecipient, From: testSender}
+	res, err := evm(assemble(t, asm), tx, state, &Block{}, Config{Tracer: NewDebugger(strings.NewReader(commands), &out)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Whatever the commands, the execution runs to its end
+	if !res.Success || len(res.Stack) != 1 || res.Stack[0].Uint64() != 3 {
+		t.Fatalf("got success %v and stack %v, want the code to run to the end", res.Success, toStrings(res.Stack))
+	}
+	var stops []string
+	for _, m := range debugLocation.FindAllStringSubmatch(out.String(), -1) {
+		pc, _ := strconv.ParseUint(m[2], 16, 64)
+		stops = append(stops, fmt.Sprintf("%v:%x", m[1], pc))
+	}
+	return stops
+}
+
+func TestDebugger(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands string
+		stops    []string
+	}{
+		// It stops before the first opcode, and runs to the end without
+		// commands
+		{"eof", "", []string{"1:0"}},
+		{"step", "step\ns", []string{"1:0", "1:2", "1:4"}},
+		{"step n", "step 3\n", []string{"1:0", "1:6"}},
+		{"step into call", "b 14\nc\ns\ns", []string{"1:0", "1:e", "2:0", "2:2"}},
+		{"next over call", "b 14\nc\nnext\nn", []string{"1:0", "1:e", "1:f", "1:10"}},
+		{"breakpoint at pc", "b 0x10\nc\nc", []string{"1:0", "1:10"}},
+		{"breakpoint at decimal pc", "b 18\nc", []string{"1:0", "1:12"}},
+		// An opcode breakpoint stops in any frame
+		{"breakpoint at opcode", "b POP\nc\nc\nc", []string{"1:0", "2:2", "1:f"}},
+		{"breakpoint at lowercase opcode", "b add\nc", []string{"1:0", "1:14"}},
+		{"delete breakpoint", "b POP\nb ADD\nd 1\nc\nc", []string{"1:0", "1:14"}},
+		{"delete all breakpoints", "b POP\nb ADD\nd\nc", []string{"1:0"}},
+		{"empty line repeats", "s 2\n\n\n", []string{"1:0", "1:4", "1:8", "1:d"}},
+		// info shows the location again, and is repeated too
+		{"empty line after info", "s\ni\n\ns", []string{"1:0", "1:2", "1:2", "1:2", "1:4"}},
+		{"invalid commands", "s 0\nb nowhere\nd 1\nfoo\ns", []string{"1:0", "1:2"}},
+		{"quit", "s\nq\ns", []string{"1:0", "1:2"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stops := debugStops(t, test.commands)
+			if strings.Join(stops, " ") != strings.Join(test.stops, " ") {
+				t.Errorf("stopped at %v, want %v", stops, test.stops)
+			}
+		})
+	}
+}
+
+func TestDebuggerFault(t *testing.T) {
+	var out bytes.Buffer
+	tx := &Transaction{To: &testRecipient, From: testSender}
+	_, err := evm(assemble(t, "PUSH1 1 ADD"), tx, NewStateDB(), &Block{}, Config{Tracer: NewDebugger(strings.NewReader("c"), &out)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "fault: stack underflow (1 <=> 2)"; !strings.Contains(out.String(), want) {
+		t.Errorf("got output\n%v\nwant it to show %q", out.String(), want)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// disasmInstruction is a single instruction decoded from bytecode.
+type disasmInstruction struct {
+	PC uint64
+	Op OpCode
+	// Arg is the immediate of a PUSH instruction
+	Arg []byte
+	// Truncated is set when the code ends before the PUSH data does, Arg then
+	// holds the bytes that are there
+	Truncated bool
+}
+
+// Size returns the number of bytes of the instruction the PUSH data requires,
+// even when they are truncated.
+func (ins disasmInstruction) Size() uint64 {
+	if ins.Op.IsPush() {
+		return 1 + uint64(ins.Op-PUSH1+1)
+	}
+	return 1
+}
+
+// Valid reports whether the instruction is a defined opcode.
+func (ins disasmInstruction) Valid() bool {
+	_, ok := opCodeToString[ins.Op]
+	return ok
+}
+
+func (ins disasmInstruction) String() string {
+	if !ins.Valid() {
+		return fmt.Sprintf("0x%02x", byte(ins.Op))
+	}
+	// PUSH data cut off entirely leaves no immediate to print
+	if ins.Op.IsPush() && len(ins.Arg) > 0 {
+		return fmt.Sprintf("%v 0x%x", ins.Op, ins.Arg)
+	}
+	return ins.Op.String()
+}
+
+// Disassemble decodes code into its instructions. PUSH data is skipped over
+// and never decoded as instructions.
+func Disassemble(code []byte) []disasmInstruction {
+	var instructions []disasmInstruction
+	for pc := uint64(0); pc < uint64(len(code)); {
+		ins := disasmInstruction{PC: pc, Op: OpCode(code[pc])}
+		if ins.Op.IsPush() {
+			start, end := pc+1, pc+ins.Size()
+			if end > uint64(len(code)) {
+				end = uint64(len(code))
+				ins.Truncated = true
+			}
+			ins.Arg = code[start:end]
+		}
+		instructions = append(instructions, ins)
+		pc += ins.Size()
+	}
+	return instructions
+}
+
+// PrintDisassembly writes one line per instruction of code to w, with its
+// offset, mnemonic and immediate. Invalid opcodes and truncated PUSH data are
+// flagged; with annotate, JUMPDESTs and the targets of constant jumps are too.
+func PrintDisassembly(w io.Writer, code []byte, annotate bool) error {
+	var (
+		instructions = Disassemble(code)
+		jumpdests    = analyzeJumpDests(code)
+	)
+	for i, ins := range instructions {
+		var notes []string
+		if !ins.Valid() {
+			notes = append(notes, "invalid opcode")
+		}
+		if ins.Truncated {
+			notes = append(notes, fmt.Sprintf("truncated, %d of %d bytes", len(ins.Arg), ins.Size()-1))
+		}
+		if annotate {
+			if ins.Op == JUMPDEST {
+				notes = append(notes, "jumpdest")
+			}
+			// A PUSH right before a JUMP or JUMPI is the jump target
+			if ins.Op.IsPush() && i+1 < len(instructions) && (instructions[i+1].Op == JUMP || instructions[i+1].Op == JUMPI) {
+				notes = append(notes, jumpTarget(ins.Arg, jumpdests))
+			}
+		}
+
+		line := fmt.Sprintf("%05x: %v", ins.PC, ins)
+		if len(notes) > 0 {
+			line = fmt.Sprintf("%-40s ; %s", line, strings.Join(notes, ", "))
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// jumpTarget describes the destination of a jump to the pushed arg.
+func jumpTarget(arg []byte, jumpdests []bool) string {
+	dest := BytesToHash(arg).Uint256()
+	if !dest.IsUint64() || dest.Uint64() >= uint64(len(jumpdests)) || !jumpdests[dest.Uint64()] {
+		return fmt.Sprintf("jump to 0x%x, not a JUMPDEST", arg)
+	}
+	return fmt.Sprintf("jump to %05x", dest.Uint64())
+}
+
+// disasmCommand prints the disassembly of the hex encoded bytecode given as
+// argument, read from a file with -f, or from stdin.
+func disasmCommand(args []string) int {
+	fs := flag.NewFlagSet("disasm", flag.ContinueOnError)
+	file := fs.String("f", "", "read the hex encoded bytecode from `file`, - for stdin")
+	annotate := fs.Bool("annotate", false, "annotate JUMPDESTs and jump targets")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: disasm [-annotate] [-f file | hex]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	var input string
+	switch {
+	case fs.NArg() > 0:
+		input = strings.Join(fs.Args(), "")
+	case *file != "" && *file != "-":
+		data, err := os.ReadFile(*file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error reading bytecode:", err)
+			return 1
+		}
+		input = string(data)
+	default:
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error reading bytecode:", err)
+			return 1
+		}
+		input = string(data)
+	}
+
+	code, err := decodeBytecode(input)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error decoding bytecode:", err)
+		return 1
+	}
+	if err := PrintDisassembly(os.Stdout, code, *annotate); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	return 0
+}
+
+// decodeBytecode decodes hex encoded bytecode, ignoring an 0x prefix and
+// whitespace.
+func decodeBytecode(s string) ([]byte, error) {
+	s = strings.Join(strings.Fields(s), "")
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X")
+	return hex.DecodeString(s)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDisassemble(t *testing.T) {
+	tests := []struct {
+		name string
+		code string
+		// want holds the offset and text of each instruction
+		want      []string
+		truncated bool
+	}{
+		{name: "empty", code: ""},
+		{name: "push offsets", code: "600161020301", want: []string{"0 PUSH1 0x01", "2 PUSH2 0x0203", "5 ADD"}},
+		// The 0x5b inside the PUSH data is no JUMPDEST
+		{name: "push data", code: "605b5b", want: []string{"0 PUSH1 0x5b", "2 JUMPDEST"}},
+		{name: "push32", code: "7f" + strings.Repeat("ff", 32) + "00", want: []string{"0 PUSH32 0x" + strings.Repeat("ff", 32), "33 STOP"}},
+		{name: "truncated push", code: "00620102", want: []string{"0 STOP", "1 PUSH3 0x0102"}, truncated: true},
+		{name: "push without data", code: "0061", want: []string{"0 STOP", "1 PUSH2"}, truncated: true},
+		{name: "invalid", code: "fe", want: []string{"0 INVALID"}},
+		{name: "undefined opcodes", code: "0c21ef", want: []string{"0 0x0c", "1 0x21", "2 0xef"}},
+	}
+	for _, test := range tests {
+		code, err := hex.DecodeString(test.code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		truncated := false
+		for _, ins := range Disassemble(code) {
+			got = append(got, fmt.Sprintf("%d %v", ins.PC, ins))
+			truncated = truncated || ins.Truncated
+		}
+		if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+			t.Errorf("%v: got %q, want %q", test.name, got, test.want)
+		}
+		if truncated != test.truncated {
+			t.Errorf("%v: got truncated %v, want %v", test.name, truncated, test.truncated)
+		}
+	}
+}
+
+func TestPrintDisassembly(t *testing.T) {
+	// PUSH1 6 JUMP; PUSH1 0x42 JUMPI; JUMPDEST; 0x0c; PUSH2 with one byte
+	code, err := hex.DecodeString("6006566042575b0c6101")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `00000: PUSH1 0x06                        ; jump to 00006
+00002: JUMP
+00003: PUSH1 0x42                        ; jump to 0x42, not a JUMPDEST
+00005: JUMPI
+00006: JUMPDEST                          ; jumpdest
+00007: 0x0c                              ; invalid opcode
+00008: PUSH2 0x01                        ; truncated, 1 of 2 bytes
+`
+	var out bytes.Buffer
+	if err := PrintDisassembly(&out, code, true); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != want {
+		t.Errorf("got\n%v\nwant\n%v", out.String(), want)
+	}
+}
@@ -2,11 +2,8 @@ package main
 
 import (
 	"encoding/hex"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"log"
-	"math/big"
+	"os"
 
 	"github.com/holiman/uint256"
 )
@@ -16,28 +13,116 @@ type code struct {
 	Asm string
 }
 
+// defaultGasLimit is the gas available to test cases that don't set one.
+const defaultGasLimit = 30_000_000
+
 type Transaction struct {
-	To       string
-	From     string
-	Origin   string
+	// To is nil for contract creation transactions
+	To       *Address
+	From     Address
+	Origin   Address
+	Nonce    string
+	Gas      string
 	GasPrice string
-	Value    string
-	Data     string
+	// MaxFeePerGas and MaxPriorityFeePerGas are set by EIP-1559 transactions
+	// instead of GasPrice
+	MaxFeePerGas         string
+	MaxPriorityFeePerGas string
+	Value                string
+	Data                 string
+	AccessList           AccessList
+	// BlobVersionedHashes are the versioned hashes of the blobs carried by
+	// EIP-4844 transactions, which also set MaxFeePerBlobGas
+	BlobVersionedHashes []Hash
+	MaxFeePerBlobGas    string
 }
 
 type Block struct {
-	Coinbase   string
+	Coinbase   Address
 	Timestamp  string
 	Number     string
 	Difficulty string
 	GasLimit   string
-	ChainId    string
+	// GasUsed is only needed to derive the base fee of the next block
+	GasUsed string
+	BaseFee string
+	ChainId string
+	// ExcessBlobGas is the blob gas the previous blocks used above their
+	// target, which sets the price of blob gas (EIP-4844)
+	ExcessBlobGas string
+
+	// GetHash returns the hash of the block with the given number, for the
+	// BLOCKHASH instruction
+	GetHash func(number uint64) Hash `json:"-"`
+}
+
+// blockValues are the numbers of a Block, parsed before any code runs in it.
+type blockValues struct {
+	Number, Timestamp, Difficulty, GasLimit, BaseFee, ChainId, ExcessBlobGas *uint256.Int
+	// BlobBaseFee is the price of blob gas, derived from ExcessBlobGas
+	BlobBaseFee *uint256.Int
+}
+
+// values parses the numbers of b, which are decimal or 0x prefixed hex, and
+// zero when empty.
+func (b *Block) values() (*blockValues, error) {
+	values := new(blockValues)
+	for _, field := range []struct {
+		name  string
+		s     string
+		value **uint256.Int
+	}{
+		{"number", b.Number, &values.Number},
+		{"timestamp", b.Timestamp, &values.Timestamp},
+		{"difficulty", b.Difficulty, &values.Difficulty},
+		{"gas limit", b.GasLimit, &values.GasLimit},
+		{"base fee", b.BaseFee, &values.BaseFee},
+		{"chain id", b.ChainId, &values.ChainId},
+		{"excess blob gas", b.ExcessBlobGas, &values.ExcessBlobGas},
+	} {
+		v, err := parseUint256(field.s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid block %v: %v", field.name, err)
+		}
+		*field.value = v
+	}
+	blobBaseFee, err := CalcBlobFee(values.ExcessBlobGas)
+	if err != nil {
+		return nil, err
+	}
+	values.BlobBaseFee = blobBaseFee
+	return values, nil
 }
 
 type expect struct {
 	Stack   []string
-	Success bool
+	Success *bool
 	Return  string
+	// Storage is the storage of the account running the code once it's done
+	Storage map[Hash]Hash
+	Logs    []expectLog
+	// State holds accounts of the post-state, only their fields that are set
+	// are compared
+	State map[Address]expectAccount
+	// GasUsed is the gas used by the code, without the intrinsic gas of the
+	// transaction and refunds
+	GasUsed string
+	// Error is part of the message of the error the execution fails with, an
+	// empty Error expects no error
+	Error *string
+}
+
+type expectLog struct {
+	Address Address
+	Data    string
+	Topics  []Hash
+}
+
+type expectAccount struct {
+	Balance string
+	Nonce   string
+	Code    *code
+	Storage map[Hash]Hash
 }
 
 type TestCase struct {
@@ -45,106 +130,109 @@ type TestCase struct {
 	Tx     Transaction
 	Code   code
 	Expect expect
-	State  interface{}
+	State  GenesisAlloc
 	Block  Block
 }
 
-func evm(code []byte, t *Transaction, state interface{}, block *Block) ([]uint256.Int, string, bool) {
-	ctx := &executionContext{
-		pc:          0,
-		code:        code,
-		stack:       newStack(),
-		memory:      newMemory(),
-		state:       state,
-		block:       block,
-		storage:     newStorage(),
-		transaction: t,
-	}
-
-	vm := NewVM(ctx)
-	stack, returnData, success := vm.execute(code)
+// metered reports whether the test case runs with gas metering and balance
+// checks, which it does when it sets a gas limit or expects the gas used.
+// The cases of scripts/evm.yaml are written without either.
+func (t *TestCase) metered() bool {
+	return t.Tx.Gas != "" || t.Expect.GasUsed != ""
+}
 
-	return stack, returnData, success
+// evmResult is the outcome of running code with evm.
+type evmResult struct {
+	Stack   []uint256.Int
+	Return  string
+	Success bool
+	GasUsed uint64
+	// Err is the error the execution failed with
+	Err error
 }
 
-func main() {
-	content, err := ioutil.ReadFile("../evm.json")
+// evm runs code as the code of the transaction's destination. The state
+// changes of failed executions are reverted, which also uses up the gas
+// unless the code reverted. The execution is observed by the tracer of
+// config, if any. An error is returned, and nothing runs, when the
+// transaction or the block is malformed.
+func evm(code []byte, t *Transaction, state *StateDB, block *Block, config Config) (*evmResult, error) {
+	value, err := parseUint256(t.Value)
 	if err != nil {
-		log.Fatal("Error when opening file: ", err)
+		return nil, fmt.Errorf("invalid value: %v", err)
 	}
-
-	var payload []TestCase
-	err = json.Unmarshal(content, &payload)
+	data, err := hex.DecodeString(t.Data)
 	if err != nil {
-		log.Fatal("Error during json.Unmarshal(): ", err)
+		return nil, fmt.Errorf("invalid data: %v", err)
 	}
-
-	for index, test := range payload {
-		fmt.Printf("Test #%v of %v: %v\n", index+1, len(payload), test.Name)
-
-		bin, err := hex.DecodeString(test.Code.Bin)
-		if err != nil {
-			log.Fatal("Error during hex.DecodeString(): ", err)
-		}
-
-		var expectedStack []uint256.Int
-		var expectedReturn string
-		var expectedSuccess bool
-		var in = new(uint256.Int)
-		for _, s := range test.Expect.Stack {
-			i, ok := new(big.Int).SetString(s, 0)
-			if !ok {
-				log.Fatal("Error during big.Int.SetString(): ", err)
-			}
-			in.SetFromBig(i)
-			expectedStack = append(expectedStack, *in)
-		}
-
-		expectedReturn = test.Expect.Return
-		expectedSuccess = test.Expect.Success
-
-		// Note: as the test cases get more complex, you'll need to modify this
-		// to pass down more arguments to the evm function and return more than
-		// just the stack.
-		stack, returnData, success := evm(bin, &test.Tx, test.State, &test.Block)
-
-		match := len(stack) == len(expectedStack)
-		if match {
-			for i, s := range stack {
-				match = match && (s.Cmp(&expectedStack[i]) == 0)
-			}
+	gas := uint64(defaultGasLimit)
+	if t.Gas != "" {
+		limit, err := parseUint256(t.Gas)
+		if err != nil || !limit.IsUint64() {
+			return nil, fmt.Errorf("invalid gas limit %q", t.Gas)
 		}
+		gas = limit.Uint64()
+	}
+	var to Address
+	if t.To != nil {
+		to = *t.To
+	}
+	vm, err := NewVM(block, t, state, config)
+	if err != nil {
+		return nil, err
+	}
 
-		if !match {
-			fmt.Printf("Instructions: \n%v\n", test.Code.Asm)
-			fmt.Printf("Expected: %v\n", toStrings(expectedStack))
-			fmt.Printf("Got: %v\n\n", toStrings(stack))
-			fmt.Printf("Progress: %v/%v\n\n", index, len(payload))
-			log.Fatal("Stack mismatch")
+	state.PrepareAccessList(t.From, block.Coinbase, &to, PrecompiledAddresses, t.AccessList)
+
+	ctx := vm.newContext(&contract{
+		CallerAddress: t.From,
+		Address:       to,
+		CallValue:     value,
+		Input:         data,
+		Gas:           gas,
+		Code:          code,
+	})
+	if config.Tracer != nil {
+		config.Tracer.CaptureTxStart(gas)
+		config.Tracer.CaptureStart(vm, t.From, to, false, data, gas, value)
+	}
+	snapshot := state.Snapshot()
+	ret, err := vm.run(ctx, false)
+	gasLeft := ctx.contract.Gas
+	if err != nil {
+		state.RevertToSnapshot(snapshot)
+		if err != ErrExecutionReverted {
+			gasLeft = 0
 		}
+	}
+	if config.Tracer != nil {
+		config.Tracer.CaptureEnd(ret, gas-gasLeft, err)
+		config.Tracer.CaptureTxEnd(gasLeft)
+	}
 
-		if returnData != expectedReturn {
-			fmt.Printf("Instructions: \n%v\n", test.Code.Asm)
-			fmt.Printf("Expected: %v\n", expectedReturn)
-			fmt.Printf("Got: %v\n\n", returnData)
-			fmt.Printf("Progress: %v/%v\n\n", index, len(payload))
-			log.Fatal("Return data mismatch")
-		}
+	return &evmResult{
+		Stack:   ctx.stack.data,
+		Return:  hex.EncodeToString(ret),
+		Success: ctx.done,
+		GasUsed: gas - gasLeft,
+		Err:     err,
+	}, nil
+}
 
-		if success != expectedSuccess {
-			fmt.Printf("Instructions: \n%v\n", test.Code.Asm)
-			fmt.Printf("Expected: %v\n", expectedSuccess)
-			fmt.Printf("Got: %v\n\n", success)
-			fmt.Printf("Progress: %v/%v\n\n", index, len(payload))
-			log.Fatal("Success mismatch")
-		}
-	}
+// commands are the subcommands selected by the first argument. Without one,
+// the test cases are run.
+var commands = map[string]func(args []string) int{
+	"asm":       asmCommand,
+	"debug":     debugCommand,
+	"disasm":    disasmCommand,
+	"statetest": stateTestCommand,
 }
 
-func toStrings(stack []uint256.Int) []string {
-	var strings []string
-	for _, s := range stack {
-		strings = append(strings, s.String())
+func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			os.Exit(cmd(os.Args[2:]))
+		}
 	}
-	return strings
+	os.Exit(testCommand(os.Args[1:]))
 }
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// TestCases runs the test cases of scripts/evm.yaml.
+func TestCases(t *testing.T) {
+	tests, err := LoadTestCases("../scripts/evm.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range tests {
+		if result := runTest(&tests[i], Config{}); !result.Passed() {
+			t.Error(failureMessage(result))
+		}
+	}
+}
+
+// TestUnmetered checks that the cases of scripts/evm.yaml which call with no
+// gas or send value their sender doesn't have only pass unmetered.
+func TestUnmetered(t *testing.T) {
+	tests, err := LoadTestCases("../scripts/evm.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]bool{"CALL": true, "CALL (returns address)": true, "CALL (reverts)": true, "CREATE (empty)": true}
+	for i := range tests {
+		test := &tests[i]
+		if !cases[test.Name] {
+			continue
+		}
+		delete(cases, test.Name)
+		if result := runTest(test, Config{}); !result.Passed() || result.GasUsed != 0 {
+			t.Errorf("%v: unmetered, passed %v using %d gas, want passed using none", test.Name, result.Passed(), result.GasUsed)
+		}
+		test.Tx.Gas = "1000000"
+		if result := runTest(test, Config{}); result.Passed() {
+			t.Errorf("%v: passed metered", test.Name)
+		}
+	}
+	for name := range cases {
+		t.Errorf("no test case %v", name)
+	}
+}
+
+func TestBlockOpcodes(t *testing.T) {
+	block := &Block{
+		Number:     "0x10000000000000000",
+		Timestamp:  "1700000000",
+		Difficulty: "0x20000",
+		GasLimit:   "30000000",
+		BaseFee:    "7",
+		ChainId:    "0x1",
+		// Prices blob gas at 23 (EIP-4844)
+		ExcessBlobGas: "10485760",
+	}
+	tests := []struct {
+		op   string
+		want string
+	}{
+		{"NUMBER", "0x10000000000000000"},
+		{"TIMESTAMP", "0x6553f100"},
+		{"DIFFICULTY", "0x20000"},
+		{"GASLIMIT", "0x1c9c380"},
+		{"BASEFEE", "0x7"},
+		{"CHAINID", "0x1"},
+		{"GASPRICE", "0x9"},
+		{"BLOBBASEFEE", "0x17"},
+	}
+	for _, test := range tests {
+		tx := &Transaction{GasPrice: "9"}
+		res, err := evm(assemble(t, test.op), tx, NewStateDB(), block, Config{})
+		if err != nil {
+			t.Fatalf("%v: %v", test.op, err)
+		}
+		if len(res.Stack) != 1 || res.Stack[0].Hex() != test.want {
+			t.Errorf("%v: got %v, want [%v]", test.op, toStrings(res.Stack), test.want)
+		}
+	}
+}
+
+func TestBlobHash(t *testing.T) {
+	tx := &Transaction{BlobVersionedHashes: []Hash{{0: 1, 31: 0xaa}, {0: 1, 31: 0xbb}}}
+	tests := []struct {
+		index string
+		want  string
+	}{
+		{"0", "0x1000000000000000000000000000000000000000000000000000000000000aa"},
+		{"1", "0x1000000000000000000000000000000000000000000000000000000000000bb"},
+		// Indexes past the blobs give zero
+		{"2", "0x0"},
+		{"0x10000000000000000", "0x0"},
+	}
+	for _, test := range tests {
+		res, err := evm(assemble(t, "PUSH "+test.index+" BLOBHASH"), tx, NewStateDB(), &Block{}, Config{})
+		if err != nil {
+			t.Fatalf("%v: %v", test.index, err)
+		}
+		if len(res.Stack) != 1 || res.Stack[0].Hex() != test.want {
+			t.Errorf("index %v: got %v, want [%v]", test.index, toStrings(res.Stack), test.want)
+		}
+	}
+}
+
+func TestMcopy(t *testing.T) {
+	// Memory starts with the bytes 0x00 to 0x1f
+	const init = "PUSH32 0x000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f PUSH1 0 MSTORE "
+	tests := []struct {
+		name string
+		asm  string
+		want string
+	}{
+		{"copy", "PUSH1 32 PUSH1 0 PUSH1 32 MCOPY PUSH1 64 PUSH1 0 RETURN",
+			"000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"},
+		{"same offsets", "PUSH1 32 PUSH1 0 PUSH1 0 MCOPY PUSH1 32 PUSH1 0 RETURN",
+			"000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"},
+		{"overlap backwards", "PUSH1 8 PUSH1 1 PUSH1 0 MCOPY PUSH1 32 PUSH1 0 RETURN",
+			"010203040506070808090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"},
+		{"overlap forwards", "PUSH1 8 PUSH1 0 PUSH1 1 MCOPY PUSH1 32 PUSH1 0 RETURN",
+			"000001020304050607090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"},
+		{"zero size", "PUSH1 0 PUSH1 0 PUSH1 0xff MCOPY PUSH1 32 PUSH1 0 RETURN",
+			"000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"},
+	}
+	for _, test := range tests {
+		res, err := evm(assemble(t, init+test.asm), &Transaction{Gas: "100000"}, NewStateDB(), &Block{}, Config{})
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		if !res.Success || res.Return != test.want {
+			t.Errorf("%v: got %v (success %v), want %v", test.name, res.Return, res.Success, test.want)
+		}
+	}
+}
+
+func TestMcopyGas(t *testing.T) {
+	tests := []struct {
+		name  string
+		asm   string
+		msize string
+		gas   uint64
+	}{
+		// 3 PUSH1, MCOPY, 1 word copied and 1 word of memory expansion
+		{"one word", "PUSH1 32 PUSH1 0 PUSH1 0 MCOPY MSIZE", "0x20", 9 + 3 + 3 + 3 + 2},
+		// Memory expands to the destination end
+		{"expand to destination", "PUSH1 32 PUSH1 0 PUSH1 64 MCOPY MSIZE", "0x60", 9 + 3 + 3 + 9 + 2},
+		// Memory expands to the source end
+		{"expand to source", "PUSH1 32 PUSH1 64 PUSH1 0 MCOPY MSIZE", "0x60", 9 + 3 + 3 + 9 + 2},
+		// Nothing is copied, memory isn't expanded
+		{"zero size", "PUSH1 0 PUSH1 64 PUSH1 64 MCOPY MSIZE", "0x0", 9 + 3 + 2},
+	}
+	for _, test := range tests {
+		res, err := evm(assemble(t, test.asm), &Transaction{Gas: "100000"}, NewStateDB(), &Block{}, Config{})
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		if len(res.Stack) != 1 || res.Stack[0].Hex() != test.msize {
+			t.Errorf("%v: got %v, want [%v]", test.name, toStrings(res.Stack), test.msize)
+		}
+		if res.GasUsed != test.gas {
+			t.Errorf("%v: got gas used %d, want %d", test.name, res.GasUsed, test.gas)
+		}
+	}
+}
+
+func TestStackUnderflow(t *testing.T) {
+	tests := []struct {
+		asm      string
+		required int
+	}{
+		{"PUSH1 1 SWAP1", 2},
+		{"PUSH1 1 PUSH1 1 SWAP16", 17},
+		{"DUP1", 1},
+		{"PUSH1 1 PUSH1 1 DUP3", 3},
+		{"PUSH1 1 ADD", 2},
+	}
+	for _, test := range tests {
+		res, err := evm(assemble(t, test.asm), &Transaction{}, NewStateDB(), &Block{}, Config{})
+		if err != nil {
+			t.Fatalf("%v: %v", test.asm, err)
+		}
+		var underflow *ErrStackUnderflow
+		if !errors.As(res.Err, &underflow) || underflow.required != test.required {
+			t.Errorf("%v: got error %v, want a stack underflow requiring %d", test.asm, res.Err, test.required)
+		}
+	}
+}
+
+func TestEVMRejectsMalformedCases(t *testing.T) {
+	tests := []struct {
+		name  string
+		tx    Transaction
+		block Block
+	}{
+		{"value", Transaction{Value: "ten"}, Block{}},
+		{"data", Transaction{Data: "0g"}, Block{}},
+		{"gas", Transaction{Gas: "0x10000000000000000"}, Block{}},
+		{"gas price", Transaction{GasPrice: "-1"}, Block{}},
+		{"number", Transaction{}, Block{Number: "1e3"}},
+		{"timestamp", Transaction{}, Block{Timestamp: "0x"}},
+		{"difficulty", Transaction{}, Block{Difficulty: "0x1" + strings.Repeat("0", 64)}},
+		{"chain id", Transaction{}, Block{ChainId: "one"}},
+	}
+	for _, test := range tests {
+		if _, err := evm([]byte{byte(STOP)}, &test.tx, NewStateDB(), &test.block, Config{}); err == nil {
+			t.Errorf("%v: no error", test.name)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2021 The go-ethereum Authors
+// This file is derived from consensus/misc/eip1559.go and
+// consensus/misc/eip4844/eip4844.go of the go-ethereum library, and
+// modified for this EVM.
+//
+// This file is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This file is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this file. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/holiman/uint256"
+)
+
+const (
+	BaseFeeChangeDenominator uint64 = 8          // Bounds the amount the base fee can change between blocks.
+	ElasticityMultiplier     uint64 = 2          // Bounds the maximum gas limit an EIP-1559 block may have.
+	InitialBaseFee           uint64 = 1000000000 // Initial base fee for EIP-1559 blocks.
+)
+
+// List of fee market validation errors.
+var (
+	ErrFeeCapTooLow   = errors.New("max fee per gas less than block base fee")
+	ErrTipAboveFeeCap = errors.New("max priority fee per gas higher than max fee per gas")
+
+	ErrBlobFeeCapTooLow = errors.New("max fee per blob gas less than block blob gas fee")
+)
+
+// IsDynamicFee reports whether tx is an EIP-1559 transaction, that is one
+// that sets a fee cap and a tip instead of a gas price.
+func (tx *Transaction) IsDynamicFee() bool {
+	return tx.MaxFeePerGas != "" || tx.MaxPriorityFeePerGas != ""
+}
+
+// IsBlob reports whether tx is an EIP-4844 transaction, one carrying blobs.
+func (tx *Transaction) IsBlob() bool {
+	return tx.MaxFeePerBlobGas != "" || tx.BlobVersionedHashes != nil
+}
+
+// BlobGas returns the blob gas tx uses, for the blobs it carries.
+func (tx *Transaction) BlobGas() uint64 {
+	return uint64(len(tx.BlobVersionedHashes)) * BlobTxBlobGasPerBlob
+}
+
+// fees returns the fee cap and the tip of tx. Both equal the gas price for
+// legacy transactions.
+func (tx *Transaction) fees() (feeCap, tip *uint256.Int, err error) {
+	if !tx.IsDynamicFee() {
+		gasPrice, err := parseUint256(tx.GasPrice)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid gas price: %v", err)
+		}
+		return gasPrice, gasPrice, nil
+	}
+	if feeCap, err = parseUint256(tx.MaxFeePerGas); err != nil {
+		return nil, nil, fmt.Errorf("invalid max fee per gas: %v", err)
+	}
+	if tip, err = parseUint256(tx.MaxPriorityFeePerGas); err != nil {
+		return nil, nil, fmt.Errorf("invalid max priority fee per gas: %v", err)
+	}
+	return feeCap, tip, nil
+}
+
+// EffectiveGasPrice returns the price per gas tx pays in block:
+// min(tip + baseFee, feeCap). A block without a base fee has a base fee of
+// zero, in which case legacy transactions pay their gas price.
+func (tx *Transaction) EffectiveGasPrice(block *Block) (*uint256.Int, error) {
+	feeCap, tip, err := tx.fees()
+	if err != nil {
+		return nil, err
+	}
+	baseFee, err := parseUint256(block.BaseFee)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base fee: %v", err)
+	}
+	price, overflow := new(uint256.Int).AddOverflow(tip, baseFee)
+	if overflow || price.Gt(feeCap) {
+		price.Set(feeCap)
+	}
+	return price, nil
+}
+
+// CalcBaseFee calculates the base fee of the block following parent. The
+// base fee moves towards the point where blocks are half full, by at most
+// 1/8th per block.
+func CalcBaseFee(parent *Block) (*uint256.Int, error) {
+	parentBaseFee, err := parseUint256(parent.BaseFee)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base fee: %v", err)
+	}
+	parentGasLimit, err := parseUint256(parent.GasLimit)
+	if err != nil || !parentGasLimit.IsUint64() {
+		return nil, fmt.Errorf("invalid gas limit %q", parent.GasLimit)
+	}
+	parentGasUsed, err := parseUint256(parent.GasUsed)
+	if err != nil || !parentGasUsed.IsUint64() {
+		return nil, fmt.Errorf("invalid gas used %q", parent.GasUsed)
+	}
+	// A parent without a base fee is the fork block, the first EIP-1559
+	// block starts at the initial base fee.
+	if parent.BaseFee == "" {
+		return new(uint256.Int).SetUint64(InitialBaseFee), nil
+	}
+
+	parentGasTarget := parentGasLimit.Uint64() / ElasticityMultiplier
+	gasUsed := parentGasUsed.Uint64()
+	// If the parent gasUsed is the same as the target, the baseFee remains unchanged.
+	if gasUsed == parentGasTarget {
+		return parentBaseFee, nil
+	}
+	if parentGasTarget == 0 {
+		return nil, fmt.Errorf("gas limit %d too low for a base fee", parentGasLimit.Uint64())
+	}
+
+	var (
+		num   = new(uint256.Int)
+		denom = new(uint256.Int).SetUint64(parentGasTarget * BaseFeeChangeDenominator)
+	)
+	if gasUsed > parentGasTarget {
+		// If the parent block used more gas than its target, the baseFee should increase.
+		// max(1, parentBaseFee * gasUsedDelta / parentGasTarget / baseFeeChangeDenominator)
+		num.SetUint64(gasUsed - parentGasTarget)
+		num.Mul(num, parentBaseFee)
+		num.Div(num, denom)
+		if num.IsZero() {
+			num.SetOne()
+		}
+		return num.Add(num, parentBaseFee), nil
+	}
+	// Otherwise if the parent block used less gas than its target, the baseFee should decrease.
+	// max(0, parentBaseFee * gasUsedDelta / parentGasTarget / baseFeeChangeDenominator)
+	num.SetUint64(parentGasTarget - gasUsed)
+	num.Mul(num, parentBaseFee)
+	num.Div(num, denom)
+	if num.Gt(parentBaseFee) {
+		return new(uint256.Int), nil
+	}
+	return num.Sub(parentBaseFee, num), nil
+}
+
+// CalcBlobFee calculates the price of blob gas given the excess blob gas of
+// the block: it grows exponentially with the blob gas used above the target,
+// by at most 1/8th per block (EIP-4844).
+func CalcBlobFee(excessBlobGas *uint256.Int) (*uint256.Int, error) {
+	fee := fakeExponential(new(big.Int).SetUint64(BlobTxMinBlobGasprice), excessBlobGas.ToBig(), new(big.Int).SetUint64(BlobTxBlobGaspriceUpdateFraction))
+	blobFee, overflow := uint256.FromBig(fee)
+	if overflow {
+		return nil, fmt.Errorf("excess blob gas %v too high for a blob fee", excessBlobGas)
+	}
+	return blobFee, nil
+}
+
+// fakeExponential approximates factor * e ** (numerator / denominator) using
+// Taylor expansion.
+func fakeExponential(factor, numerator, denominator *big.Int) *big.Int {
+	var (
+		output = new(big.Int)
+		accum  = new(big.Int).Mul(factor, denominator)
+	)
+	for i := 1; accum.Sign() > 0; i++ {
+		output.Add(output, accum)
+
+		accum.Mul(accum, numerator)
+		accum.Div(accum, denominator)
+		accum.Div(accum, big.NewInt(int64(i)))
+	}
+	return output.Div(output, denominator)
+}
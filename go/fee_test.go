@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/holiman/uint256"
+)
+
+func TestCalcBaseFee(t *testing.T) {
+	tests := []struct {
+		name    string
+		parent  Block
+		baseFee uint64
+	}{
+		{"fork block", Block{GasLimit: "20000000", GasUsed: "10000000"}, InitialBaseFee},
+		{"usage equals target", Block{BaseFee: "1000000000", GasLimit: "20000000", GasUsed: "10000000"}, 1000000000},
+		{"usage below target", Block{BaseFee: "1000000000", GasLimit: "20000000", GasUsed: "9000000"}, 987500000},
+		{"usage above target", Block{BaseFee: "1000000000", GasLimit: "20000000", GasUsed: "11000000"}, 1012500000},
+		{"empty block", Block{BaseFee: "1000000000", GasLimit: "20000000", GasUsed: "0"}, 875000000},
+		{"full block", Block{BaseFee: "1000000000", GasLimit: "20000000", GasUsed: "20000000"}, 1125000000},
+		// The base fee grows by at least 1
+		{"minimum increase", Block{BaseFee: "7", GasLimit: "20000000", GasUsed: "10000001"}, 8},
+	}
+	for _, test := range tests {
+		baseFee, err := CalcBaseFee(&test.parent)
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		if !baseFee.Eq(uint256.NewInt(test.baseFee)) {
+			t.Errorf("%v: got base fee %v, want %d", test.name, baseFee, test.baseFee)
+		}
+	}
+}
+
+func TestEffectiveGasPrice(t *testing.T) {
+	tests := []struct {
+		name    string
+		tx      Transaction
+		baseFee string
+		price   uint64
+	}{
+		{"legacy", Transaction{GasPrice: "15"}, "10", 15},
+		{"legacy without base fee", Transaction{GasPrice: "15"}, "", 15},
+		{"tip below fee cap", Transaction{MaxFeePerGas: "20", MaxPriorityFeePerGas: "3"}, "10", 13},
+		{"tip capped by fee cap", Transaction{MaxFeePerGas: "12", MaxPriorityFeePerGas: "5"}, "10", 12},
+		{"dynamic fee without base fee", Transaction{MaxFeePerGas: "20", MaxPriorityFeePerGas: "3"}, "", 3},
+	}
+	for _, test := range tests {
+		price, err := test.tx.EffectiveGasPrice(&Block{BaseFee: test.baseFee})
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		if !price.Eq(uint256.NewInt(test.price)) {
+			t.Errorf("%v: got price %v, want %d", test.name, price, test.price)
+		}
+	}
+}
+
+func TestCalcBlobFee(t *testing.T) {
+	tests := []struct {
+		excessBlobGas uint64
+		blobFee       uint64
+	}{
+		{0, 1},
+		{2314057, 1},
+		{2314058, 2},
+		{10 * 1024 * 1024, 23},
+	}
+	for _, test := range tests {
+		fee, err := CalcBlobFee(uint256.NewInt(test.excessBlobGas))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !fee.Eq(uint256.NewInt(test.blobFee)) {
+			t.Errorf("excess blob gas %d: got blob fee %v, want %d", test.excessBlobGas, fee, test.blobFee)
+		}
+	}
+}
@@ -0,0 +1,613 @@
+// Copyright 2015 The go-ethereum Authors
+// This file is derived from core/vm/gas.go, core/vm/gas_table.go and
+// core/vm/operations_acl.go of the go-ethereum library, and modified for
+// this EVM.
+//
+// This file is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This file is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this file. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"errors"
+	"math"
+
+	"github.com/holiman/uint256"
+)
+
+// Gas costs of the instructions, grouped the way the yellow paper does.
+const (
+	GasQuickStep   uint64 = 2
+	GasFastestStep uint64 = 3
+	GasFastStep    uint64 = 5
+	GasMidStep     uint64 = 8
+	GasSlowStep    uint64 = 10
+	GasExtStep     uint64 = 20
+)
+
+const (
+	StackLimit      uint64 = 1024  // Maximum size of VM stack allowed.
+	CallCreateDepth uint64 = 1024  // Maximum depth of call/create stack.
+	MaxCodeSize            = 24576 // Maximum bytecode to permit for a contract
+	MaxInitCodeSize        = 2 * MaxCodeSize
+
+	MemoryGas        uint64 = 3   // Times the address of the (highest referenced byte in memory + 1). NOTE: referencing happens on read, write and in instructions such as RETURN and CALL.
+	QuadCoeffDiv     uint64 = 512 // Divisor for the quadratic particle of the memory cost equation.
+	CopyGas          uint64 = 3   // Per word cost of CALLDATACOPY, CODECOPY, EXTCODECOPY and RETURNDATACOPY.
+	JumpdestGas      uint64 = 1   // Once per JUMPDEST operation.
+	Keccak256Gas     uint64 = 30  // Once per KECCAK256 operation.
+	Keccak256WordGas uint64 = 6   // Once per word of the KECCAK256 operation's data.
+	ExpByteGas       uint64 = 50  // One per byte of the EXP exponent.
+
+	LogGas      uint64 = 375 // Per LOG* operation.
+	LogTopicGas uint64 = 375 // Multiplied by the * of the LOG*, per LOG transaction. e.g. LOG0 incurs 0 * c_txLogTopicGas, LOG4 incurs 4 * c_txLogTopicGas.
+	LogDataGas  uint64 = 8   // Per byte in a LOG* operation's data.
+
+	ColdAccountAccessCostEIP2929 uint64 = 2600 // COLD_ACCOUNT_ACCESS_COST
+	ColdSloadCostEIP2929         uint64 = 2100 // COLD_SLOAD_COST
+	WarmStorageReadCostEIP2929   uint64 = 100  // WARM_STORAGE_READ_COST
+
+	SstoreSentryGasEIP2200 uint64 = 2300  // Minimum gas required to be present for an SSTORE call, not consumed
+	SstoreSetGasEIP2200    uint64 = 20000 // Once per SSTORE operation from clean zero to non-zero
+	SstoreResetGasEIP2200  uint64 = 5000  // Once per SSTORE operation from clean non-zero to something else
+
+	// In EIP-2200: SstoreResetGas was 5000.
+	// In EIP-2929: SstoreResetGas was changed to '5000 - COLD_SLOAD_COST'.
+	// In EIP-3529: SSTORE_CLEARS_SCHEDULE is defined as SSTORE_RESET_GAS + ACCESS_LIST_STORAGE_KEY_COST
+	// Which becomes: 5000 - 2100 + 1900 = 4800
+	SstoreClearsScheduleRefundEIP3529 uint64 = SstoreResetGasEIP2200 - ColdSloadCostEIP2929 + TxAccessListStorageKeyGas
+
+	CallValueTransferGas uint64 = 9000  // Paid for CALL when the value transfer is non-zero.
+	CallNewAccountGas    uint64 = 25000 // Paid for CALL when the destination address didn't exist prior.
+	CallStipend          uint64 = 2300  // Free gas given at beginning of call.
+
+	CreateGas       uint64 = 32000 // Once per CREATE operation & contract-creation transaction.
+	Create2Gas      uint64 = 32000 // Once per CREATE2 operation
+	CreateDataGas   uint64 = 200   // Per byte of deployed code.
+	InitCodeWordGas uint64 = 2     // Once per word of the init code when creating a contract.
+
+	SelfdestructGasEIP150   uint64 = 5000  // Cost of SELFDESTRUCT post EIP 150 (Tangerine)
+	CreateBySelfdestructGas uint64 = 25000 // Paid for SELFDESTRUCT when the beneficiary didn't exist prior.
+
+	TxGas                     uint64 = 21000 // Per transaction not creating a contract.
+	TxGasContractCreation     uint64 = 53000 // Per transaction that creates a contract.
+	TxDataZeroGas             uint64 = 4     // Per byte of data attached to a transaction that equals zero.
+	TxDataNonZeroGasEIP2028   uint64 = 16    // Per byte of non zero data attached to a transaction.
+	TxAccessListAddressGas    uint64 = 2400  // Per address specified in EIP 2930 access list
+	TxAccessListStorageKeyGas uint64 = 1900  // Per storage key specified in EIP 2930 access list
+
+	// Precompiled contract gas prices
+	EcrecoverGas        uint64 = 3000 // Elliptic curve sender recovery gas price
+	Sha256BaseGas       uint64 = 60   // Base price for a SHA256 operation
+	Sha256PerWordGas    uint64 = 12   // Per-word price for a SHA256 operation
+	Ripemd160BaseGas    uint64 = 600  // Base price for a RIPEMD160 operation
+	Ripemd160PerWordGas uint64 = 120  // Per-word price for a RIPEMD160 operation
+	IdentityBaseGas     uint64 = 15   // Base price for a data copy operation
+	IdentityPerWordGas  uint64 = 3    // Per-work price for a data copy operation
+	ModExpMinGas        uint64 = 200  // Minimum price for a modular exponentiation (EIP-2565)
+
+	Bn256AddGasIstanbul             uint64 = 150   // Gas needed for an elliptic curve addition
+	Bn256ScalarMulGasIstanbul       uint64 = 6000  // Gas needed for an elliptic curve scalar multiplication
+	Bn256PairingBaseGasIstanbul     uint64 = 45000 // Base price for an elliptic curve pairing check
+	Bn256PairingPerPointGasIstanbul uint64 = 34000 // Per-point price for an elliptic curve pairing check
+
+	BlobTxPointEvaluationPrecompileGas uint64 = 50000 // Gas price for the point evaluation precompile.
+
+	BlobTxBlobGasPerBlob             uint64 = 1 << 17                  // Gas consumption of a single data blob (== blob byte size)
+	BlobTxMinBlobGasprice            uint64 = 1                        // Minimum gas price for data blobs
+	BlobTxBlobGaspriceUpdateFraction uint64 = 3338477                  // Controls the maximum rate of change for blob gas price
+	MaxBlobGasPerBlock               uint64 = 6 * BlobTxBlobGasPerBlob // Maximum consumable blob gas for data blobs per block
+
+	RefundQuotientEIP3529 uint64 = 5 // Maximum refund quotient; max gas refund is gasUsed / RefundQuotientEIP3529
+)
+
+type gasFunc func(ctx *executionContext, stack *stackStruct, memorySize uint64) (uint64, error)
+
+// memoryGasCost calculates the quadratic gas for memory expansion. It does so
+// only for the memory region that is expanded, not the total memory.
+func memoryGasCost(mem *memoryStruct, newMemSize uint64) (uint64, error) {
+	if newMemSize == 0 {
+		return 0, nil
+	}
+	// The maximum that will fit in a uint64 is max_word_count - 1. Anything above
+	// that will result in an overflow. Additionally, a newMemSize which results in
+	// a newMemSizeWords larger than 0xFFFFFFFF will cause the square operation to
+	// overflow. The constant 0x1FFFFFFFE0 is the highest number that can be used
+	// without overflowing the gas calculation.
+	if newMemSize > 0x1FFFFFFFE0 {
+		return 0, ErrGasUintOverflow
+	}
+	newMemSizeWords := toWordSize(newMemSize)
+	newMemSize = newMemSizeWords * 32
+
+	if newMemSize > uint64(len(mem.data)) {
+		square := newMemSizeWords * newMemSizeWords
+		linCoef := newMemSizeWords * MemoryGas
+		quadCoef := square / QuadCoeffDiv
+		newTotalFee := linCoef + quadCoef
+
+		fee := newTotalFee - mem.lastGasCost
+		mem.lastGasCost = newTotalFee
+
+		return fee, nil
+	}
+	return 0, nil
+}
+
+func pureMemoryGascost(ctx *executionContext, stack *stackStruct, memorySize uint64) (uint64, error) {
+	return memoryGasCost(ctx.memory, memorySize)
+}
+
+var (
+	gasReturn  = pureMemoryGascost
+	gasRevert  = pureMemoryGascost
+	gasMLoad   = pureMemoryGascost
+	gasMStore8 = pureMemoryGascost
+	gasMStore  = pureMemoryGascost
+)
+
+// memoryCopierGas creates the gas functions for the following opcodes, and takes
+// the stack position of the operand which determines the size of the data to copy
+// as argument:
+// CALLDATACOPY (stack position 2)
+// CODECOPY (stack position 2)
+// EXTCODECOPY (stack position 3)
+// RETURNDATACOPY (stack position 2)
+// MCOPY (stack position 2)
+func memoryCopierGas(stackpos int64) gasFunc {
+	return func(ctx *executionContext, stack *stackStruct, memorySize uint64) (uint64, error) {
+		// Gas for expanding the memory
+		gas, err := memoryGasCost(ctx.memory, memorySize)
+		if err != nil {
+			return 0, err
+		}
+		// And gas for copying data, charged per word at param.CopyGas
+		words, overflow := stack.Back(stackpos).Uint64WithOverflow()
+		if overflow {
+			return 0, ErrGasUintOverflow
+		}
+
+		if words, overflow = SafeMul(toWordSize(words), CopyGas); overflow {
+			return 0, ErrGasUintOverflow
+		}
+
+		if gas, overflow = SafeAdd(gas, words); overflow {
+			return 0, ErrGasUintOverflow
+		}
+		return gas, nil
+	}
+}
+
+var (
+	gasCallDataCopy   = memoryCopierGas(2)
+	gasCodeCopy       = memoryCopierGas(2)
+	gasExtCodeCopy    = memoryCopierGas(3)
+	gasReturnDataCopy = memoryCopierGas(2)
+	gasMcopy          = memoryCopierGas(2)
+)
+
+func gasKeccak256(ctx *executionContext, stack *stackStruct, memorySize uint64) (uint64, error) {
+	gas, err := memoryGasCost(ctx.memory, memorySize)
+	if err != nil {
+		return 0, err
+	}
+	wordGas, overflow := stack.Back(1).Uint64WithOverflow()
+	if overflow {
+		return 0, ErrGasUintOverflow
+	}
+	if wordGas, overflow = SafeMul(toWordSize(wordGas), Keccak256WordGas); overflow {
+		return 0, ErrGasUintOverflow
+	}
+	if gas, overflow = SafeAdd(gas, wordGas); overflow {
+		return 0, ErrGasUintOverflow
+	}
+	return gas, nil
+}
+
+func gasExp(ctx *executionContext, stack *stackStruct, memorySize uint64) (uint64, error) {
+	expByteLen := uint64((stack.Back(1).BitLen() + 7) / 8)
+
+	return expByteLen * ExpByteGas, nil // no overflow check required. Max is 256 * ExpByte gas
+}
+
+func makeGasLog(n uint64) gasFunc {
+	return func(ctx *executionContext, stack *stackStruct, memorySize uint64) (uint64, error) {
+		requestedSize, overflow := stack.Back(1).Uint64WithOverflow()
+		if overflow {
+			return 0, ErrGasUintOverflow
+		}
+
+		gas, err := memoryGasCost(ctx.memory, memorySize)
+		if err != nil {
+			return 0, err
+		}
+
+		if gas, overflow = SafeAdd(gas, LogGas); overflow {
+			return 0, ErrGasUintOverflow
+		}
+		if gas, overflow = SafeAdd(gas, n*LogTopicGas); overflow {
+			return 0, ErrGasUintOverflow
+		}
+
+		var memorySizeGas uint64
+		if memorySizeGas, overflow = SafeMul(requestedSize, LogDataGas); overflow {
+			return 0, ErrGasUintOverflow
+		}
+		if gas, overflow = SafeAdd(gas, memorySizeGas); overflow {
+			return 0, ErrGasUintOverflow
+		}
+		return gas, nil
+	}
+}
+
+// gasCreateEip3860 charges the memory expansion and the per word init code
+// cost of CREATE.
+func gasCreateEip3860(ctx *executionContext, stack *stackStruct, memorySize uint64) (uint64, error) {
+	gas, err := memoryGasCost(ctx.memory, memorySize)
+	if err != nil {
+		return 0, err
+	}
+	size, overflow := stack.Back(2).Uint64WithOverflow()
+	if overflow || size > MaxInitCodeSize {
+		return 0, ErrGasUintOverflow
+	}
+	// Since size <= MaxInitCodeSize, these multiplication cannot overflow
+	moreGas := InitCodeWordGas * ((size + 31) / 32)
+	if gas, overflow = SafeAdd(gas, moreGas); overflow {
+		return 0, ErrGasUintOverflow
+	}
+	return gas, nil
+}
+
+// gasCreate2Eip3860 charges the memory expansion of CREATE2, and per word of
+// the init code both the init code cost and the cost of hashing it.
+func gasCreate2Eip3860(ctx *executionContext, stack *stackStruct, memorySize uint64) (uint64, error) {
+	gas, err := memoryGasCost(ctx.memory, memorySize)
+	if err != nil {
+		return 0, err
+	}
+	size, overflow := stack.Back(2).Uint64WithOverflow()
+	if overflow || size > MaxInitCodeSize {
+		return 0, ErrGasUintOverflow
+	}
+	// Since size <= MaxInitCodeSize, these multiplication cannot overflow
+	moreGas := (InitCodeWordGas + Keccak256WordGas) * ((size + 31) / 32)
+	if gas, overflow = SafeAdd(gas, moreGas); overflow {
+		return 0, ErrGasUintOverflow
+	}
+	return gas, nil
+}
+
+func gasCall(ctx *executionContext, stack *stackStruct, memorySize uint64) (uint64, error) {
+	var (
+		gas            uint64
+		transfersValue = !stack.Back(2).IsZero()
+		address        = Address(stack.Back(1).Bytes20())
+	)
+	if transfersValue && ctx.state.Empty(address) {
+		gas += CallNewAccountGas
+	}
+	if transfersValue {
+		gas += CallValueTransferGas
+	}
+	memoryGas, err := memoryGasCost(ctx.memory, memorySize)
+	if err != nil {
+		return 0, err
+	}
+	var overflow bool
+	if gas, overflow = SafeAdd(gas, memoryGas); overflow {
+		return 0, ErrGasUintOverflow
+	}
+
+	ctx.callGasTemp, err = callGas(ctx.contract.Gas, gas, stack.Back(0))
+	if err != nil {
+		return 0, err
+	}
+	if gas, overflow = SafeAdd(gas, ctx.callGasTemp); overflow {
+		return 0, ErrGasUintOverflow
+	}
+	return gas, nil
+}
+
+func gasCallCode(ctx *executionContext, stack *stackStruct, memorySize uint64) (uint64, error) {
+	memoryGas, err := memoryGasCost(ctx.memory, memorySize)
+	if err != nil {
+		return 0, err
+	}
+	var (
+		gas      uint64
+		overflow bool
+	)
+	if stack.Back(2).Sign() != 0 {
+		gas += CallValueTransferGas
+	}
+	if gas, overflow = SafeAdd(gas, memoryGas); overflow {
+		return 0, ErrGasUintOverflow
+	}
+
+	ctx.callGasTemp, err = callGas(ctx.contract.Gas, gas, stack.Back(0))
+	if err != nil {
+		return 0, err
+	}
+	if gas, overflow = SafeAdd(gas, ctx.callGasTemp); overflow {
+		return 0, ErrGasUintOverflow
+	}
+	return gas, nil
+}
+
+func gasDelegateCall(ctx *executionContext, stack *stackStruct, memorySize uint64) (uint64, error) {
+	gas, err := memoryGasCost(ctx.memory, memorySize)
+	if err != nil {
+		return 0, err
+	}
+	ctx.callGasTemp, err = callGas(ctx.contract.Gas, gas, stack.Back(0))
+	if err != nil {
+		return 0, err
+	}
+	var overflow bool
+	if gas, overflow = SafeAdd(gas, ctx.callGasTemp); overflow {
+		return 0, ErrGasUintOverflow
+	}
+	return gas, nil
+}
+
+func gasStaticCall(ctx *executionContext, stack *stackStruct, memorySize uint64) (uint64, error) {
+	gas, err := memoryGasCost(ctx.memory, memorySize)
+	if err != nil {
+		return 0, err
+	}
+	ctx.callGasTemp, err = callGas(ctx.contract.Gas, gas, stack.Back(0))
+	if err != nil {
+		return 0, err
+	}
+	var overflow bool
+	if gas, overflow = SafeAdd(gas, ctx.callGasTemp); overflow {
+		return 0, ErrGasUintOverflow
+	}
+	return gas, nil
+}
+
+// callGas returns the actual gas cost of the call.
+//
+// The cost of gas was changed during the homestead price change HF.
+// As part of EIP 150 (TangerineWhistle), the returned gas is gas - base * 63 / 64.
+func callGas(availableGas, base uint64, callCost *uint256.Int) (uint64, error) {
+	availableGas = availableGas - base
+	gas := availableGas - availableGas/64
+	// If the bit length exceeds 64 bit we know that the newly calculated "gas" for EIP150
+	// is smaller than the requested amount. Therefore we return the new gas instead
+	// of returning an error.
+	if !callCost.IsUint64() || gas < callCost.Uint64() {
+		return gas, nil
+	}
+	return callCost.Uint64(), nil
+}
+
+// gasSLoadEIP2929 calculates dynamic gas for SLOAD according to EIP-2929
+// For SLOAD, if the (address, storage_key) pair (where address is the address of the contract
+// whose storage is being read) is not yet in accessed_storage_keys,
+// charge 2100 gas and add the pair to accessed_storage_keys.
+// If the pair is already in accessed_storage_keys, charge 100 gas.
+func gasSLoadEIP2929(ctx *executionContext, stack *stackStruct, memorySize uint64) (uint64, error) {
+	loc := stack.peek()
+	slot := Hash(loc.Bytes32())
+	// Check slot presence in the access list
+	if _, slotPresent := ctx.state.SlotInAccessList(ctx.contract.Address, slot); !slotPresent {
+		// If the caller cannot afford the cost, this change will be rolled back
+		// If he does afford it, we can skip checking the same thing later on, during execution
+		ctx.state.AddSlotToAccessList(ctx.contract.Address, slot)
+		return ColdSloadCostEIP2929, nil
+	}
+	return WarmStorageReadCostEIP2929, nil
+}
+
+// makeGasSStoreFunc creates the net gas metered SSTORE of EIP-2200, with the
+// EIP-2929 access costs and the given refund for clearing a slot.
+//
+// The legacy gas metering has only two dimensions: the value of the slot
+// before and after the operation. Net gas metering also takes the original
+// value of the slot, the value at the start of the transaction, into account:
+//
+//  1. If current value equals new value (this is a no-op), SLOAD_GAS is deducted.
+//  2. If current value does not equal new value:
+//     2.1. If original value equals current value (this storage slot has not
+//     been changed by the current execution context):
+//     2.1.1. If original value is 0, SSTORE_SET_GAS is deducted.
+//     2.1.2. Otherwise, SSTORE_RESET_GAS gas is deducted. If new value is 0,
+//     add SSTORE_CLEARS_SCHEDULE to refund counter.
+//     2.2. If original value does not equal current value (this storage slot
+//     is dirty), SLOAD_GAS gas is deducted. Apply both of the following clauses:
+//     2.2.1. If original value is not 0:
+//     2.2.1.1. If current value is 0 (also means that new value is not 0),
+//     subtract SSTORE_CLEARS_SCHEDULE gas from refund counter.
+//     2.2.1.2. If new value is 0 (also means that current value is not 0),
+//     add SSTORE_CLEARS_SCHEDULE gas to refund counter.
+//     2.2.2. If original value equals new value (this storage slot is reset):
+//     2.2.2.1. If original value is 0, add SSTORE_SET_GAS - SLOAD_GAS to refund counter.
+//     2.2.2.2. Otherwise, add SSTORE_RESET_GAS - SLOAD_GAS gas to refund counter.
+//
+// An SSTORE fails when the gas left is not above the 2300 stipend, so a call
+// made with only the stipend can never write storage, unless it is unmetered.
+func makeGasSStoreFunc(clearingRefund uint64) gasFunc {
+	return func(ctx *executionContext, stack *stackStruct, memorySize uint64) (uint64, error) {
+		// If we fail the minimum gas availability invariant, fail (0)
+		if !ctx.unmetered && ctx.contract.Gas <= SstoreSentryGasEIP2200 {
+			return 0, errors.New("not enough gas for reentrancy sentry")
+		}
+		// Gas sentry honoured, do the actual gas calculation based on the stored value
+		var (
+			y, x    = stack.Back(1), stack.peek()
+			slot    = Hash(x.Bytes32())
+			current = ctx.state.GetState(ctx.contract.Address, slot)
+			cost    = uint64(0)
+		)
+		// Check slot presence in the access list
+		if _, slotPresent := ctx.state.SlotInAccessList(ctx.contract.Address, slot); !slotPresent {
+			cost = ColdSloadCostEIP2929
+			// If the caller cannot afford the cost, this change will be rolled back
+			ctx.state.AddSlotToAccessList(ctx.contract.Address, slot)
+		}
+		value := Hash(y.Bytes32())
+
+		if current == value { // noop (1)
+			return cost + WarmStorageReadCostEIP2929, nil // SLOAD_GAS
+		}
+		original := ctx.state.GetCommittedState(ctx.contract.Address, slot)
+		if original == current {
+			if original == (Hash{}) { // create slot (2.1.1)
+				return cost + SstoreSetGasEIP2200, nil
+			}
+			if value == (Hash{}) { // delete slot (2.1.2b)
+				ctx.state.AddRefund(clearingRefund)
+			}
+			return cost + (SstoreResetGasEIP2200 - ColdSloadCostEIP2929), nil // write existing slot (2.1.2)
+		}
+		if original != (Hash{}) {
+			if current == (Hash{}) { // recreate slot (2.2.1.1)
+				ctx.state.SubRefund(clearingRefund)
+			} else if value == (Hash{}) { // delete slot (2.2.1.2)
+				ctx.state.AddRefund(clearingRefund)
+			}
+		}
+		if original == value {
+			if original == (Hash{}) { // reset to original inexistent slot (2.2.2.1)
+				ctx.state.AddRefund(SstoreSetGasEIP2200 - WarmStorageReadCostEIP2929)
+			} else { // reset to original existing slot (2.2.2.2)
+				// - SSTORE_RESET_GAS redefined as (5000 - COLD_SLOAD_COST)
+				// - SLOAD_GAS redefined as WARM_STORAGE_READ_COST
+				// Final: (5000 - COLD_SLOAD_COST) - WARM_STORAGE_READ_COST
+				ctx.state.AddRefund((SstoreResetGasEIP2200 - ColdSloadCostEIP2929) - WarmStorageReadCostEIP2929)
+			}
+		}
+		return cost + WarmStorageReadCostEIP2929, nil // dirty update (2.2)
+	}
+}
+
+// gasSStoreEIP3529 implements gas cost for SSTORE according to EIP-3529, which
+// reduces the refund for clearing a slot.
+var gasSStoreEIP3529 = makeGasSStoreFunc(SstoreClearsScheduleRefundEIP3529)
+
+// gasExtCodeCopyEIP2929 implements extcodecopy according to EIP-2929
+// EIP spec:
+// > If the target is not in accessed_addresses,
+// > charge COLD_ACCOUNT_ACCESS_COST gas, and add the address to accessed_addresses.
+// > Otherwise, charge WARM_STORAGE_READ_COST gas.
+func gasExtCodeCopyEIP2929(ctx *executionContext, stack *stackStruct, memorySize uint64) (uint64, error) {
+	// memory expansion first (dynamic part of pre-2929 implementation)
+	gas, err := gasExtCodeCopy(ctx, stack, memorySize)
+	if err != nil {
+		return 0, err
+	}
+	addr := Address(stack.peek().Bytes20())
+	// Check slot presence in the access list
+	if !ctx.state.AddressInAccessList(addr) {
+		ctx.state.AddAddressToAccessList(addr)
+		var overflow bool
+		// We charge (cold-warm), since 'warm' is already charged as constantGas
+		if gas, overflow = SafeAdd(gas, ColdAccountAccessCostEIP2929-WarmStorageReadCostEIP2929); overflow {
+			return 0, ErrGasUintOverflow
+		}
+		return gas, nil
+	}
+	return gas, nil
+}
+
+// gasEip2929AccountCheck checks whether the first stack item (as address) is present in the access list.
+// If it is, this method returns '0', otherwise 'cold-warm' gas, presuming that the opcode using it
+// is also using 'warm' as constant factor.
+// This method is used by:
+// - extcodehash,
+// - extcodesize,
+// - (ext) balance
+func gasEip2929AccountCheck(ctx *executionContext, stack *stackStruct, memorySize uint64) (uint64, error) {
+	addr := Address(stack.peek().Bytes20())
+	// Check slot presence in the access list
+	if !ctx.state.AddressInAccessList(addr) {
+		// If the caller cannot afford the cost, this change will be rolled back
+		ctx.state.AddAddressToAccessList(addr)
+		// The warm storage read cost is already charged as constantGas
+		return ColdAccountAccessCostEIP2929 - WarmStorageReadCostEIP2929, nil
+	}
+	return 0, nil
+}
+
+func makeCallVariantGasCallEIP2929(oldCalculator gasFunc) gasFunc {
+	return func(ctx *executionContext, stack *stackStruct, memorySize uint64) (uint64, error) {
+		addr := Address(stack.Back(1).Bytes20())
+		// Check slot presence in the access list
+		warmAccess := ctx.state.AddressInAccessList(addr)
+		// The WarmStorageReadCostEIP2929 (100) is already deducted in the form of a constant cost, so
+		// the cost to charge for cold access, if any, is Cold - Warm
+		coldCost := ColdAccountAccessCostEIP2929 - WarmStorageReadCostEIP2929
+		if !warmAccess {
+			ctx.state.AddAddressToAccessList(addr)
+			// Charge the remaining difference here already, to correctly calculate available
+			// gas for call
+			if !ctx.useGas(coldCost) {
+				return 0, ErrOutOfGas
+			}
+		}
+		// Now call the old calculator, which takes into account
+		// - create new account
+		// - transfer value
+		// - memory expansion
+		// - 63/64ths rule
+		gas, err := oldCalculator(ctx, stack, memorySize)
+		if warmAccess || err != nil {
+			return gas, err
+		}
+		// In case of a cold access, we temporarily add the cold charge back, and also
+		// add it to the returned gas. By adding it to the return, it will be charged
+		// outside of this function, as part of the dynamic gas, and that will make it
+		// also become correctly reported to tracers.
+		ctx.contract.Gas += coldCost
+
+		var overflow bool
+		if gas, overflow = SafeAdd(gas, coldCost); overflow {
+			return 0, ErrGasUintOverflow
+		}
+		return gas, nil
+	}
+}
+
+// gasSelfdestructEIP3529 charges the cold access of the beneficiary and the
+// creation of its account, when the balance sent to it brings it into
+// existence. Since EIP-3529 SELFDESTRUCT no longer earns a refund.
+func gasSelfdestructEIP3529(ctx *executionContext, stack *stackStruct, memorySize uint64) (uint64, error) {
+	var (
+		gas     uint64
+		address = Address(stack.peek().Bytes20())
+	)
+	if !ctx.state.AddressInAccessList(address) {
+		// If the caller cannot afford the cost, this change will be rolled back
+		ctx.state.AddAddressToAccessList(address)
+		gas = ColdAccountAccessCostEIP2929
+	}
+	// if empty and transfers value
+	if ctx.state.Empty(address) && !ctx.state.GetBalance(ctx.contract.Address).IsZero() {
+		gas += CreateBySelfdestructGas
+	}
+	return gas, nil
+}
+
+var (
+	gasCallEIP2929         = makeCallVariantGasCallEIP2929(gasCall)
+	gasDelegateCallEIP2929 = makeCallVariantGasCallEIP2929(gasDelegateCall)
+	gasStaticCallEIP2929   = makeCallVariantGasCallEIP2929(gasStaticCall)
+	gasCallCodeEIP2929     = makeCallVariantGasCallEIP2929(gasCallCode)
+)
+
+// SafeAdd returns x+y and checks for overflow.
+func SafeAdd(x, y uint64) (uint64, bool) {
+	return x + y, y > math.MaxUint64-x
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestAccessListGas(t *testing.T) {
+	const other = "0xaa00000000000000000000000000000000000001"
+	otherAddress := HexToAddress(other)
+	tests := []struct {
+		name       string
+		asm        string
+		accessList AccessList
+		gas        uint64
+	}{
+		{name: "cold balance", asm: "PUSH20 " + other + " BALANCE", gas: 3 + 2600},
+		{name: "warm balance", asm: "PUSH20 " + other + " BALANCE PUSH20 " + other + " BALANCE", gas: 3 + 2600 + 3 + 100},
+		{name: "cold extcodesize", asm: "PUSH20 " + other + " EXTCODESIZE", gas: 3 + 2600},
+		{name: "cold extcodehash", asm: "PUSH20 " + other + " EXTCODEHASH", gas: 3 + 2600},
+		{name: "cold extcodecopy", asm: "PUSH1 0 PUSH1 0 PUSH1 0 PUSH20 " + other + " EXTCODECOPY", gas: 12 + 2600},
+		{name: "cold call", asm: "PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 0 PUSH20 " + other + " PUSH1 0 CALL", gas: 21 + 2600},
+		{name: "warm call", asm: "PUSH20 " + other + " BALANCE PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 0 PUSH20 " + other + " PUSH1 0 CALL", gas: 3 + 2600 + 21 + 100},
+		// The transaction starts with its access list, the sender, the
+		// destination, the coinbase and the precompiles warm
+		{name: "access list address", asm: "PUSH20 " + other + " BALANCE", accessList: AccessList{{Address: otherAddress}}, gas: 3 + 100},
+		{name: "sender", asm: "CALLER BALANCE", gas: 2 + 100},
+		{name: "destination", asm: "ADDRESS BALANCE", gas: 2 + 100},
+		{name: "coinbase", asm: "COINBASE BALANCE", gas: 2 + 100},
+		{name: "precompile", asm: "PUSH1 1 BALANCE", gas: 3 + 100},
+
+		{name: "cold sload", asm: "PUSH1 0 SLOAD", gas: 3 + 2100},
+		{name: "warm sload", asm: "PUSH1 0 SLOAD PUSH1 0 SLOAD", gas: 3 + 2100 + 3 + 100},
+		{name: "access list slot", asm: "PUSH1 0 SLOAD", accessList: AccessList{{Address: testRecipient, StorageKeys: []Hash{{}}}}, gas: 3 + 100},
+		// The slot is warm for its own address only
+		{name: "access list slot of another address", asm: "PUSH1 0 SLOAD", accessList: AccessList{{Address: otherAddress, StorageKeys: []Hash{{}}}}, gas: 3 + 2100},
+	}
+	for _, test := range tests {
+		tx := &Transaction{To: &testRecipient, From: testSender, AccessList: test.accessList}
+		block := &Block{Coinbase: testCoinbase}
+		res, err := evm(assemble(t, test.asm), tx, NewStateDB(), block, Config{})
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		if !res.Success {
+			t.Fatalf("%v: failed with %v", test.name, res.Err)
+		}
+		if res.GasUsed != test.gas {
+			t.Errorf("%v: got gas used %d, want %d", test.name, res.GasUsed, test.gas)
+		}
+	}
+}
+
+// TestAccessListRevert checks that the addresses and slots warmed up by a
+// call are cold again when it reverts.
+func TestAccessListRevert(t *testing.T) {
+	var (
+		callee = HexToAddress("0x3000000000000000000000000000000000000003")
+		other  = HexToAddress("0xaa00000000000000000000000000000000000001")
+	)
+	tests := []struct {
+		name string
+		asm  string
+		warm bool
+	}{
+		{"returns", "PUSH20 0xaa00000000000000000000000000000000000001 BALANCE PUSH1 0 SLOAD STOP", true},
+		{"reverts", "PUSH20 0xaa00000000000000000000000000000000000001 BALANCE PUSH1 0 SLOAD PUSH1 0 DUP1 REVERT", false},
+	}
+	for _, test := range tests {
+		state := newTestState(t, GenesisAlloc{
+			callee: {Code: code{Bin: hex.EncodeToString(assemble(t, test.asm))}},
+		})
+		caller := assemble(t, "PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 0 PUSH20 0x3000000000000000000000000000000000000003 GAS CALL")
+		tx := &Transaction{To: &testRecipient, From: testSender}
+		if _, err := evm(caller, tx, state, &Block{}, Config{}); err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		if got := state.AddressInAccessList(other); got != test.warm {
+			t.Errorf("%v: got address warm %v, want %v", test.name, got, test.warm)
+		}
+		if _, got := state.SlotInAccessList(callee, Hash{}); got != test.warm {
+			t.Errorf("%v: got slot warm %v, want %v", test.name, got, test.warm)
+		}
+		// The callee itself was warmed up by the caller
+		if !state.AddressInAccessList(callee) {
+			t.Errorf("%v: callee is cold", test.name)
+		}
+	}
+}
+
+// TestSstoreGas runs the test cases of EIP-3529, which start with the slot
+// warm.
+func TestSstoreGas(t *testing.T) {
+	tests := []struct {
+		code     string
+		gas      uint64
+		refund   uint64
+		original byte
+	}{
+		{"60006000556000600055", 212, 0, 0},
+		{"60006000556001600055", 20112, 0, 0},
+		{"60016000556000600055", 20112, 19900, 0},
+		{"60016000556002600055", 20112, 0, 0},
+		{"60016000556001600055", 20112, 0, 0},
+		{"60006000556000600055", 3012, 4800, 1},
+		{"60006000556001600055", 3012, 2800, 1},
+		{"60006000556002600055", 3012, 0, 1},
+		{"60026000556000600055", 3012, 4800, 1},
+		{"60026000556003600055", 3012, 0, 1},
+		{"60026000556001600055", 3012, 2800, 1},
+		{"60026000556002600055", 3012, 0, 1},
+		{"60016000556000600055", 3012, 4800, 1},
+		{"60016000556002600055", 3012, 0, 1},
+		{"60016000556001600055", 212, 0, 1},
+		{"600160005560006000556001600055", 40118, 19900, 0},
+		{"600060005560016000556000600055", 5918, 7600, 1},
+	}
+	for _, test := range tests {
+		state := newTestState(t, GenesisAlloc{
+			testRecipient: {Storage: map[Hash]Hash{{}: {31: test.original}}},
+		})
+		code, err := hex.DecodeString(test.code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tx := &Transaction{
+			To:         &testRecipient,
+			From:       testSender,
+			AccessList: AccessList{{Address: testRecipient, StorageKeys: []Hash{{}}}},
+		}
+		res, err := evm(code, tx, state, &Block{}, Config{})
+		if err != nil {
+			t.Fatalf("%v: %v", test.code, err)
+		}
+		if !res.Success {
+			t.Fatalf("%v: failed with %v", test.code, res.Err)
+		}
+		if res.GasUsed != test.gas {
+			t.Errorf("%v, original %d: got gas used %d, want %d", test.code, test.original, res.GasUsed, test.gas)
+		}
+		if got := state.GetRefund(); got != test.refund {
+			t.Errorf("%v, original %d: got refund %d, want %d", test.code, test.original, got, test.refund)
+		}
+	}
+}
+
+// TestSstoreSentry checks that SSTORE fails when no more than the call
+// stipend is left, even if it costs less (EIP-2200).
+func TestSstoreSentry(t *testing.T) {
+	tests := []struct {
+		gas string
+		ok  bool
+	}{
+		// 2 PUSH1 leave 2300 gas
+		{"2306", false},
+		// 2 PUSH1 leave 2301 gas, the SSTORE costs 100
+		{"2307", true},
+	}
+	for _, test := range tests {
+		tx := &Transaction{
+			To:         &testRecipient,
+			From:       testSender,
+			Gas:        test.gas,
+			AccessList: AccessList{{Address: testRecipient, StorageKeys: []Hash{{}}}},
+		}
+		res, err := evm(assemble(t, "PUSH1 0 PUSH1 0 SSTORE"), tx, NewStateDB(), &Block{}, Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Success != test.ok {
+			t.Errorf("gas %v: got success %v (%v), want %v", test.gas, res.Success, res.Err, test.ok)
+		}
+	}
+}
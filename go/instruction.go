@@ -5,7 +5,16 @@ import (
 )
 
 type instruction struct {
-	execute    executionFunc
+	execute     executionFunc
+	constantGas uint64
+	dynamicGas  gasFunc
+	// minStack tells how many stack items are required
+	minStack int
+	// maxStack specifies the max length the stack can have for this operation
+	// to not overflow the stack.
+	maxStack int
+
+	// memorySize returns the memory size required for the operation
 	memorySize memorySizeFunc
 }
 
@@ -16,216 +25,973 @@ type (
 	memorySizeFunc func(*stackStruct) (size uint64, overflow bool)
 )
 
+func minSwapStack(n int) int {
+	return minStack(n, n)
+}
+func maxSwapStack(n int) int {
+	return maxStack(n, n)
+}
+
+func minDupStack(n int) int {
+	return minStack(n, n+1)
+}
+func maxDupStack(n int) int {
+	return maxStack(n, n+1)
+}
+
+func maxStack(pop, push int) int {
+	return int(StackLimit) + pop - push
+}
+func minStack(pops, push int) int {
+	return pops
+}
+
 func loadInstructionSet() ISet {
 	instructionSet := ISet{
 		STOP: {
-			execute: stopOp,
+			execute:     stopOp,
+			constantGas: 0,
+			minStack:    minStack(0, 0),
+			maxStack:    maxStack(0, 0),
 		},
 		ADD: {
-			execute: addOp,
+			execute:     addOp,
+			constantGas: GasFastestStep,
+			minStack:    minStack(2, 1),
+			maxStack:    maxStack(2, 1),
 		},
 		MUL: {
-			execute: mulOp,
+			execute:     mulOp,
+			constantGas: GasFastStep,
+			minStack:    minStack(2, 1),
+			maxStack:    maxStack(2, 1),
 		},
 		SUB: {
-			execute: subOp,
+			execute:     subOp,
+			constantGas: GasFastestStep,
+			minStack:    minStack(2, 1),
+			maxStack:    maxStack(2, 1),
 		},
 		DIV: {
-			execute: divOp,
+			execute:     divOp,
+			constantGas: GasFastStep,
+			minStack:    minStack(2, 1),
+			maxStack:    maxStack(2, 1),
 		},
 		SDIV: {
-			execute: sdivOp,
+			execute:     sdivOp,
+			constantGas: GasFastStep,
+			minStack:    minStack(2, 1),
+			maxStack:    maxStack(2, 1),
 		},
 		MOD: {
-			execute: modOp,
+			execute:     modOp,
+			constantGas: GasFastStep,
+			minStack:    minStack(2, 1),
+			maxStack:    maxStack(2, 1),
 		},
 		SMOD: {
-			execute: smodOp,
+			execute:     smodOp,
+			constantGas: GasFastStep,
+			minStack:    minStack(2, 1),
+			maxStack:    maxStack(2, 1),
+		},
+		ADDMOD: {
+			execute:     addmodOp,
+			constantGas: GasMidStep,
+			minStack:    minStack(3, 1),
+			maxStack:    maxStack(3, 1),
+		},
+		MULMOD: {
+			execute:     mulmodOp,
+			constantGas: GasMidStep,
+			minStack:    minStack(3, 1),
+			maxStack:    maxStack(3, 1),
+		},
+		EXP: {
+			execute:     expOp,
+			constantGas: GasSlowStep,
+			dynamicGas:  gasExp,
+			minStack:    minStack(2, 1),
+			maxStack:    maxStack(2, 1),
+		},
+		SIGNEXTEND: {
+			execute:     signExtendOp,
+			constantGas: GasFastStep,
+			minStack:    minStack(2, 1),
+			maxStack:    maxStack(2, 1),
 		},
 		LT: {
-			execute: ltOp,
+			execute:     ltOp,
+			constantGas: GasFastestStep,
+			minStack:    minStack(2, 1),
+			maxStack:    maxStack(2, 1),
 		},
 		GT: {
-			execute: gtOp,
+			execute:     gtOp,
+			constantGas: GasFastestStep,
+			minStack:    minStack(2, 1),
+			maxStack:    maxStack(2, 1),
 		},
 		SLT: {
-			execute: sltOp,
+			execute:     sltOp,
+			constantGas: GasFastestStep,
+			minStack:    minStack(2, 1),
+			maxStack:    maxStack(2, 1),
 		},
 		SGT: {
-			execute: sgtOp,
+			execute:     sgtOp,
+			constantGas: GasFastestStep,
+			minStack:    minStack(2, 1),
+			maxStack:    maxStack(2, 1),
 		},
 		EQ: {
-			execute: eqOp,
+			execute:     eqOp,
+			constantGas: GasFastestStep,
+			minStack:    minStack(2, 1),
+			maxStack:    maxStack(2, 1),
 		},
 		ISZERO: {
-			execute: iszeroOp,
+			execute:     iszeroOp,
+			constantGas: GasFastestStep,
+			minStack:    minStack(1, 1),
+			maxStack:    maxStack(1, 1),
 		},
 		AND: {
-			execute: andOp,
+			execute:     andOp,
+			constantGas: GasFastestStep,
+			minStack:    minStack(2, 1),
+			maxStack:    maxStack(2, 1),
 		},
 		OR: {
-			execute: orOp,
+			execute:     orOp,
+			constantGas: GasFastestStep,
+			minStack:    minStack(2, 1),
+			maxStack:    maxStack(2, 1),
 		},
 		XOR: {
-			execute: xorOp,
+			execute:     xorOp,
+			constantGas: GasFastestStep,
+			minStack:    minStack(2, 1),
+			maxStack:    maxStack(2, 1),
 		},
 		NOT: {
-			execute: notOp,
+			execute:     notOp,
+			constantGas: GasFastestStep,
+			minStack:    minStack(1, 1),
+			maxStack:    maxStack(1, 1),
 		},
 		BYTE: {
-			execute: byteOp,
+			execute:     byteOp,
+			constantGas: GasFastestStep,
+			minStack:    minStack(2, 1),
+			maxStack:    maxStack(2, 1),
+		},
+		SHL: {
+			execute:     shlOp,
+			constantGas: GasFastestStep,
+			minStack:    minStack(2, 1),
+			maxStack:    maxStack(2, 1),
+		},
+		SHR: {
+			execute:     shrOp,
+			constantGas: GasFastestStep,
+			minStack:    minStack(2, 1),
+			maxStack:    maxStack(2, 1),
+		},
+		SAR: {
+			execute:     sarOp,
+			constantGas: GasFastestStep,
+			minStack:    minStack(2, 1),
+			maxStack:    maxStack(2, 1),
 		},
-		POP: {
-			execute: popOp,
+		SHA3: {
+			execute:     sha3Op,
+			constantGas: Keccak256Gas,
+			dynamicGas:  gasKeccak256,
+			minStack:    minStack(2, 1),
+			maxStack:    maxStack(2, 1),
+			memorySize:  memorySha3,
 		},
-		PUSH1: {
-			execute: push1Op,
+		ADDRESS: {
+			execute:     addressOp,
+			constantGas: GasQuickStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
 		},
-		PUSH2: {
-			execute: makePush(2),
+		BALANCE: {
+			execute:     balanceOp,
+			constantGas: WarmStorageReadCostEIP2929,
+			dynamicGas:  gasEip2929AccountCheck,
+			minStack:    minStack(1, 1),
+			maxStack:    maxStack(1, 1),
 		},
-		PUSH3: {
-			execute: makePush(3),
+		ORIGIN: {
+			execute:     originOp,
+			constantGas: GasQuickStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
 		},
-		PUSH4: {
-			execute: makePush(4),
+		CALLER: {
+			execute:     callerOp,
+			constantGas: GasQuickStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
 		},
-		PUSH13: {
-			execute: makePush(13),
+		CALLVALUE: {
+			execute:     callvalueOp,
+			constantGas: GasQuickStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
 		},
-		PUSH20: {
-			execute: makePush(20),
+		CALLDATALOAD: {
+			execute:     calldataloadOp,
+			constantGas: GasFastestStep,
+			minStack:    minStack(1, 1),
+			maxStack:    maxStack(1, 1),
 		},
-		PUSH32: {
-			execute: makePush(32),
+		CALLDATASIZE: {
+			execute:     calldatasizeOp,
+			constantGas: GasQuickStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
 		},
-		DUP1: {
-			execute: dup1Op,
+		CALLDATACOPY: {
+			execute:     calldatacopyOp,
+			constantGas: GasFastestStep,
+			dynamicGas:  gasCallDataCopy,
+			minStack:    minStack(3, 0),
+			maxStack:    maxStack(3, 0),
+			memorySize:  memoryCallDataCopy,
 		},
-		DUP3: {
-			execute: dup3Op,
+		CODESIZE: {
+			execute:     codesizeOp,
+			constantGas: GasQuickStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
 		},
-		SWAP1: {
-			execute: swap1Op,
+		CODECOPY: {
+			execute:     codecopyOp,
+			constantGas: GasFastestStep,
+			dynamicGas:  gasCodeCopy,
+			minStack:    minStack(3, 0),
+			maxStack:    maxStack(3, 0),
+			memorySize:  memoryCodeCopy,
 		},
-		SWAP3: {
-			execute: swap3Op,
+		GASPRICE: {
+			execute:     gaspriceOp,
+			constantGas: GasQuickStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
 		},
-		JUMP: {
-			execute: jumpOp,
+		EXTCODESIZE: {
+			execute:     extcodesizeOp,
+			constantGas: WarmStorageReadCostEIP2929,
+			dynamicGas:  gasEip2929AccountCheck,
+			minStack:    minStack(1, 1),
+			maxStack:    maxStack(1, 1),
 		},
-		JUMPI: {
-			execute: jumpiOp,
+		EXTCODECOPY: {
+			execute:     extcodecopyOp,
+			constantGas: WarmStorageReadCostEIP2929,
+			dynamicGas:  gasExtCodeCopyEIP2929,
+			minStack:    minStack(4, 0),
+			maxStack:    maxStack(4, 0),
+			memorySize:  memoryExtCodeCopy,
+		},
+		RETURNDATASIZE: {
+			execute:     returndatasizeOp,
+			constantGas: GasQuickStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
+		},
+		RETURNDATACOPY: {
+			execute:     returndatacopyOp,
+			constantGas: GasFastestStep,
+			dynamicGas:  gasReturnDataCopy,
+			minStack:    minStack(3, 0),
+			maxStack:    maxStack(3, 0),
+			memorySize:  memoryReturnDataCopy,
+		},
+		EXTCODEHASH: {
+			execute:     extcodehashOp,
+			constantGas: WarmStorageReadCostEIP2929,
+			dynamicGas:  gasEip2929AccountCheck,
+			minStack:    minStack(1, 1),
+			maxStack:    maxStack(1, 1),
+		},
+		BLOCKHASH: {
+			execute:     blockhashOp,
+			constantGas: GasExtStep,
+			minStack:    minStack(1, 1),
+			maxStack:    maxStack(1, 1),
 		},
-		JUMPDEST: {
-			execute: jumpDestOp,
+		COINBASE: {
+			execute:     coinbaseOp,
+			constantGas: GasQuickStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
 		},
-		PC: {
-			execute: pcOp,
+		TIMESTAMP: {
+			execute:     timestampOp,
+			constantGas: GasQuickStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
 		},
-		MSTORE: {
-			execute:    mstoreOp,
-			memorySize: memoryMStore,
+		NUMBER: {
+			execute:     numberOp,
+			constantGas: GasQuickStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
 		},
-		MLOAD: {
-			execute:    mloadOp,
-			memorySize: memoryMLoad,
+		DIFFICULTY: {
+			execute:     difficultyOp,
+			constantGas: GasQuickStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
 		},
-		MSTORE8: {
-			execute:    mstore8Op,
-			memorySize: memoryMStore8,
+		GASLIMIT: {
+			execute:     gaslimitOp,
+			constantGas: GasQuickStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
 		},
-		MSIZE: {
-			execute: msizeOp,
+		CHAINID: {
+			execute:     chainidOp,
+			constantGas: GasQuickStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
 		},
-		SHA3: {
-			execute:    sha3Op,
-			memorySize: memorySha3,
+		SELFBALANCE: {
+			execute:     selfbalanceOp,
+			constantGas: GasFastStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
+		},
+		BASEFEE: {
+			execute:     basefeeOp,
+			constantGas: GasQuickStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
+		},
+		BLOBHASH: {
+			execute:     blobHashOp,
+			constantGas: GasFastestStep,
+			minStack:    minStack(1, 1),
+			maxStack:    maxStack(1, 1),
+		},
+		BLOBBASEFEE: {
+			execute:     blobBaseFeeOp,
+			constantGas: GasQuickStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
 		},
-		ADDRESS: {
-			execute: addressOp,
+		POP: {
+			execute:     popOp,
+			constantGas: GasQuickStep,
+			minStack:    minStack(1, 0),
+			maxStack:    maxStack(1, 0),
 		},
-		CALLER: {
-			execute: callerOp,
+		MLOAD: {
+			execute:     mloadOp,
+			constantGas: GasFastestStep,
+			dynamicGas:  gasMLoad,
+			minStack:    minStack(1, 1),
+			maxStack:    maxStack(1, 1),
+			memorySize:  memoryMLoad,
 		},
-		BALANCE: {
-			execute: balanceOp,
+		MSTORE: {
+			execute:     mstoreOp,
+			constantGas: GasFastestStep,
+			dynamicGas:  gasMStore,
+			minStack:    minStack(2, 0),
+			maxStack:    maxStack(2, 0),
+			memorySize:  memoryMStore,
 		},
-		ORIGIN: {
-			execute: originOp,
+		MSTORE8: {
+			execute:     mstore8Op,
+			constantGas: GasFastestStep,
+			dynamicGas:  gasMStore8,
+			minStack:    minStack(2, 0),
+			maxStack:    maxStack(2, 0),
+			memorySize:  memoryMStore8,
 		},
-		COINBASE: {
-			execute: coinbaseOp,
+		SLOAD: {
+			execute:     sloadOp,
+			constantGas: 0,
+			dynamicGas:  gasSLoadEIP2929,
+			minStack:    minStack(1, 1),
+			maxStack:    maxStack(1, 1),
 		},
-		TIMESTAMP: {
-			execute: timestampOp,
+		SSTORE: {
+			execute:     sstoreOp,
+			constantGas: 0,
+			dynamicGas:  gasSStoreEIP3529,
+			minStack:    minStack(2, 0),
+			maxStack:    maxStack(2, 0),
 		},
-		NUMBER: {
-			execute: numberOp,
+		JUMP: {
+			execute:     jumpOp,
+			constantGas: GasMidStep,
+			minStack:    minStack(1, 0),
+			maxStack:    maxStack(1, 0),
 		},
-		DIFFICULTY: {
-			execute: difficultyOp,
+		JUMPI: {
+			execute:     jumpiOp,
+			constantGas: GasSlowStep,
+			minStack:    minStack(2, 0),
+			maxStack:    maxStack(2, 0),
 		},
-		GASLIMIT: {
-			execute: gaslimitOp,
+		PC: {
+			execute:     pcOp,
+			constantGas: GasQuickStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
 		},
-		GASPRICE: {
-			execute: gaspriceOp,
+		MSIZE: {
+			execute:     msizeOp,
+			constantGas: GasQuickStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
 		},
-		CHAINID: {
-			execute: chainidOp,
+		GAS: {
+			execute:     gasOp,
+			constantGas: GasQuickStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
 		},
-		CALLVALUE: {
-			execute: callvalueOp,
+		JUMPDEST: {
+			execute:     jumpDestOp,
+			constantGas: JumpdestGas,
+			minStack:    minStack(0, 0),
+			maxStack:    maxStack(0, 0),
+		},
+		TLOAD: {
+			execute:     tloadOp,
+			constantGas: WarmStorageReadCostEIP2929,
+			minStack:    minStack(1, 1),
+			maxStack:    maxStack(1, 1),
+		},
+		TSTORE: {
+			execute:     tstoreOp,
+			constantGas: WarmStorageReadCostEIP2929,
+			minStack:    minStack(2, 0),
+			maxStack:    maxStack(2, 0),
+		},
+		MCOPY: {
+			execute:     mcopyOp,
+			constantGas: GasFastestStep,
+			dynamicGas:  gasMcopy,
+			minStack:    minStack(3, 0),
+			maxStack:    maxStack(3, 0),
+			memorySize:  memoryMcopy,
+		},
+		PUSH0: {
+			execute:     push0Op,
+			constantGas: GasQuickStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
 		},
-		CALLDATALOAD: {
-			execute: calldataloadOp,
+		PUSH1: {
+			execute:     push1Op,
+			constantGas: GasFastestStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
 		},
-		CALLDATASIZE: {
-			execute: calldatasizeOp,
+		PUSH2: {
+			execute:     makePush(2),
+			constantGas: GasFastestStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
 		},
-		CALLDATACOPY: {
-			execute:    calldatacopyOp,
-			memorySize: memoryCallDataCopy,
+		PUSH3: {
+			execute:     makePush(3),
+			constantGas: GasFastestStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
 		},
-		CODESIZE: {
-			execute: codesizeOp,
+		PUSH4: {
+			execute:     makePush(4),
+			constantGas: GasFastestStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
+		},
+		PUSH5: {
+			execute:     makePush(5),
+			constantGas: GasFastestStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
+		},
+		PUSH6: {
+			execute:     makePush(6),
+			constantGas: GasFastestStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
+		},
+		PUSH7: {
+			execute:     makePush(7),
+			constantGas: GasFastestStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
+		},
+		PUSH8: {
+			execute:     makePush(8),
+			constantGas: GasFastestStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
+		},
+		PUSH9: {
+			execute:     makePush(9),
+			constantGas: GasFastestStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
+		},
+		PUSH10: {
+			execute:     makePush(10),
+			constantGas: GasFastestStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
+		},
+		PUSH11: {
+			execute:     makePush(11),
+			constantGas: GasFastestStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
+		},
+		PUSH12: {
+			execute:     makePush(12),
+			constantGas: GasFastestStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
 		},
-		CODECOPY: {
-			execute:    codecopyOp,
-			memorySize: memoryCodeCopy,
+		PUSH13: {
+			execute:     makePush(13),
+			constantGas: GasFastestStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
+		},
+		PUSH14: {
+			execute:     makePush(14),
+			constantGas: GasFastestStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
+		},
+		PUSH15: {
+			execute:     makePush(15),
+			constantGas: GasFastestStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
+		},
+		PUSH16: {
+			execute:     makePush(16),
+			constantGas: GasFastestStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
+		},
+		PUSH17: {
+			execute:     makePush(17),
+			constantGas: GasFastestStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
+		},
+		PUSH18: {
+			execute:     makePush(18),
+			constantGas: GasFastestStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
+		},
+		PUSH19: {
+			execute:     makePush(19),
+			constantGas: GasFastestStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
 		},
-		EXTCODESIZE: {
-			execute: extcodesizeOp,
+		PUSH20: {
+			execute:     makePush(20),
+			constantGas: GasFastestStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
+		},
+		PUSH21: {
+			execute:     makePush(21),
+			constantGas: GasFastestStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
+		},
+		PUSH22: {
+			execute:     makePush(22),
+			constantGas: GasFastestStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
+		},
+		PUSH23: {
+			execute:     makePush(23),
+			constantGas: GasFastestStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
+		},
+		PUSH24: {
+			execute:     makePush(24),
+			constantGas: GasFastestStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
+		},
+		PUSH25: {
+			execute:     makePush(25),
+			constantGas: GasFastestStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
+		},
+		PUSH26: {
+			execute:     makePush(26),
+			constantGas: GasFastestStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
+		},
+		PUSH27: {
+			execute:     makePush(27),
+			constantGas: GasFastestStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
+		},
+		PUSH28: {
+			execute:     makePush(28),
+			constantGas: GasFastestStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
+		},
+		PUSH29: {
+			execute:     makePush(29),
+			constantGas: GasFastestStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
+		},
+		PUSH30: {
+			execute:     makePush(30),
+			constantGas: GasFastestStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
+		},
+		PUSH31: {
+			execute:     makePush(31),
+			constantGas: GasFastestStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
 		},
-		EXTCODECOPY: {
-			execute:    extcodecopyOp,
-			memorySize: memoryExtCodeCopy,
+		PUSH32: {
+			execute:     makePush(32),
+			constantGas: GasFastestStep,
+			minStack:    minStack(0, 1),
+			maxStack:    maxStack(0, 1),
 		},
-		SELFBALANCE: {
-			execute: selfbalanceOp,
+		DUP1: {
+			execute:     makeDup(1),
+			constantGas: GasFastestStep,
+			minStack:    minDupStack(1),
+			maxStack:    maxDupStack(1),
 		},
-		SSTORE: {
-			execute: sstoreOp,
+		DUP2: {
+			execute:     makeDup(2),
+			constantGas: GasFastestStep,
+			minStack:    minDupStack(2),
+			maxStack:    maxDupStack(2),
 		},
-		SLOAD: {
-			execute: sloadOp,
+		DUP3: {
+			execute:     makeDup(3),
+			constantGas: GasFastestStep,
+			minStack:    minDupStack(3),
+			maxStack:    maxDupStack(3),
+		},
+		DUP4: {
+			execute:     makeDup(4),
+			constantGas: GasFastestStep,
+			minStack:    minDupStack(4),
+			maxStack:    maxDupStack(4),
+		},
+		DUP5: {
+			execute:     makeDup(5),
+			constantGas: GasFastestStep,
+			minStack:    minDupStack(5),
+			maxStack:    maxDupStack(5),
+		},
+		DUP6: {
+			execute:     makeDup(6),
+			constantGas: GasFastestStep,
+			minStack:    minDupStack(6),
+			maxStack:    maxDupStack(6),
+		},
+		DUP7: {
+			execute:     makeDup(7),
+			constantGas: GasFastestStep,
+			minStack:    minDupStack(7),
+			maxStack:    maxDupStack(7),
+		},
+		DUP8: {
+			execute:     makeDup(8),
+			constantGas: GasFastestStep,
+			minStack:    minDupStack(8),
+			maxStack:    maxDupStack(8),
+		},
+		DUP9: {
+			execute:     makeDup(9),
+			constantGas: GasFastestStep,
+			minStack:    minDupStack(9),
+			maxStack:    maxDupStack(9),
+		},
+		DUP10: {
+			execute:     makeDup(10),
+			constantGas: GasFastestStep,
+			minStack:    minDupStack(10),
+			maxStack:    maxDupStack(10),
+		},
+		DUP11: {
+			execute:     makeDup(11),
+			constantGas: GasFastestStep,
+			minStack:    minDupStack(11),
+			maxStack:    maxDupStack(11),
+		},
+		DUP12: {
+			execute:     makeDup(12),
+			constantGas: GasFastestStep,
+			minStack:    minDupStack(12),
+			maxStack:    maxDupStack(12),
+		},
+		DUP13: {
+			execute:     makeDup(13),
+			constantGas: GasFastestStep,
+			minStack:    minDupStack(13),
+			maxStack:    maxDupStack(13),
+		},
+		DUP14: {
+			execute:     makeDup(14),
+			constantGas: GasFastestStep,
+			minStack:    minDupStack(14),
+			maxStack:    maxDupStack(14),
+		},
+		DUP15: {
+			execute:     makeDup(15),
+			constantGas: GasFastestStep,
+			minStack:    minDupStack(15),
+			maxStack:    maxDupStack(15),
+		},
+		DUP16: {
+			execute:     makeDup(16),
+			constantGas: GasFastestStep,
+			minStack:    minDupStack(16),
+			maxStack:    maxDupStack(16),
 		},
-		RETURN: {
-			execute:    returnOp,
-			memorySize: memoryReturn,
+		SWAP1: {
+			execute:     makeSwap(1),
+			constantGas: GasFastestStep,
+			minStack:    minSwapStack(2),
+			maxStack:    maxSwapStack(2),
 		},
-		REVERT: {
-			execute:    revertOp,
-			memorySize: memoryRevert,
+		SWAP2: {
+			execute:     makeSwap(2),
+			constantGas: GasFastestStep,
+			minStack:    minSwapStack(3),
+			maxStack:    maxSwapStack(3),
 		},
-		CALL: {
-			execute:    callOp,
-			memorySize: memoryCall,
+		SWAP3: {
+			execute:     makeSwap(3),
+			constantGas: GasFastestStep,
+			minStack:    minSwapStack(4),
+			maxStack:    maxSwapStack(4),
+		},
+		SWAP4: {
+			execute:     makeSwap(4),
+			constantGas: GasFastestStep,
+			minStack:    minSwapStack(5),
+			maxStack:    maxSwapStack(5),
+		},
+		SWAP5: {
+			execute:     makeSwap(5),
+			constantGas: GasFastestStep,
+			minStack:    minSwapStack(6),
+			maxStack:    maxSwapStack(6),
+		},
+		SWAP6: {
+			execute:     makeSwap(6),
+			constantGas: GasFastestStep,
+			minStack:    minSwapStack(7),
+			maxStack:    maxSwapStack(7),
+		},
+		SWAP7: {
+			execute:     makeSwap(7),
+			constantGas: GasFastestStep,
+			minStack:    minSwapStack(8),
+			maxStack:    maxSwapStack(8),
+		},
+		SWAP8: {
+			execute:     makeSwap(8),
+			constantGas: GasFastestStep,
+			minStack:    minSwapStack(9),
+			maxStack:    maxSwapStack(9),
+		},
+		SWAP9: {
+			execute:     makeSwap(9),
+			constantGas: GasFastestStep,
+			minStack:    minSwapStack(10),
+			maxStack:    maxSwapStack(10),
+		},
+		SWAP10: {
+			execute:     makeSwap(10),
+			constantGas: GasFastestStep,
+			minStack:    minSwapStack(11),
+			maxStack:    maxSwapStack(11),
+		},
+		SWAP11: {
+			execute:     makeSwap(11),
+			constantGas: GasFastestStep,
+			minStack:    minSwapStack(12),
+			maxStack:    maxSwapStack(12),
+		},
+		SWAP12: {
+			execute:     makeSwap(12),
+			constantGas: GasFastestStep,
+			minStack:    minSwapStack(13),
+			maxStack:    maxSwapStack(13),
+		},
+		SWAP13: {
+			execute:     makeSwap(13),
+			constantGas: GasFastestStep,
+			minStack:    minSwapStack(14),
+			maxStack:    maxSwapStack(14),
+		},
+		SWAP14: {
+			execute:     makeSwap(14),
+			constantGas: GasFastestStep,
+			minStack:    minSwapStack(15),
+			maxStack:    maxSwapStack(15),
+		},
+		SWAP15: {
+			execute:     makeSwap(15),
+			constantGas: GasFastestStep,
+			minStack:    minSwapStack(16),
+			maxStack:    maxSwapStack(16),
+		},
+		SWAP16: {
+			execute:     makeSwap(16),
+			constantGas: GasFastestStep,
+			minStack:    minSwapStack(17),
+			maxStack:    maxSwapStack(17),
+		},
+		LOG0: {
+			execute:     makeLog(0),
+			constantGas: 0,
+			dynamicGas:  makeGasLog(0),
+			minStack:    minStack(2, 0),
+			maxStack:    maxStack(2, 0),
+			memorySize:  memoryLog,
+		},
+		LOG1: {
+			execute:     makeLog(1),
+			constantGas: 0,
+			dynamicGas:  makeGasLog(1),
+			minStack:    minStack(3, 0),
+			maxStack:    maxStack(3, 0),
+			memorySize:  memoryLog,
+		},
+		LOG2: {
+			execute:     makeLog(2),
+			constantGas: 0,
+			dynamicGas:  makeGasLog(2),
+			minStack:    minStack(4, 0),
+			maxStack:    maxStack(4, 0),
+			memorySize:  memoryLog,
+		},
+		LOG3: {
+			execute:     makeLog(3),
+			constantGas: 0,
+			dynamicGas:  makeGasLog(3),
+			minStack:    minStack(5, 0),
+			maxStack:    maxStack(5, 0),
+			memorySize:  memoryLog,
+		},
+		LOG4: {
+			execute:     makeLog(4),
+			constantGas: 0,
+			dynamicGas:  makeGasLog(4),
+			minStack:    minStack(6, 0),
+			maxStack:    maxStack(6, 0),
+			memorySize:  memoryLog,
 		},
 		CREATE: {
-			execute:    createOp,
-			memorySize: memoryCreate,
+			execute:     createOp,
+			constantGas: CreateGas,
+			dynamicGas:  gasCreateEip3860,
+			minStack:    minStack(3, 1),
+			maxStack:    maxStack(3, 1),
+			memorySize:  memoryCreate,
+		},
+		CREATE2: {
+			execute:     create2Op,
+			constantGas: Create2Gas,
+			dynamicGas:  gasCreate2Eip3860,
+			minStack:    minStack(4, 1),
+			maxStack:    maxStack(4, 1),
+			memorySize:  memoryCreate,
+		},
+		CALL: {
+			execute:     callOp,
+			constantGas: WarmStorageReadCostEIP2929,
+			dynamicGas:  gasCallEIP2929,
+			minStack:    minStack(7, 1),
+			maxStack:    maxStack(7, 1),
+			memorySize:  memoryCall,
+		},
+		CALLCODE: {
+			execute:     callCodeOp,
+			constantGas: WarmStorageReadCostEIP2929,
+			dynamicGas:  gasCallCodeEIP2929,
+			minStack:    minStack(7, 1),
+			maxStack:    maxStack(7, 1),
+			memorySize:  memoryCall,
+		},
+		DELEGATECALL: {
+			execute:     delegateCallOp,
+			constantGas: WarmStorageReadCostEIP2929,
+			dynamicGas:  gasDelegateCallEIP2929,
+			minStack:    minStack(6, 1),
+			maxStack:    maxStack(6, 1),
+			memorySize:  memoryDelegateCall,
+		},
+		STATICCALL: {
+			execute:     staticCallOp,
+			constantGas: WarmStorageReadCostEIP2929,
+			dynamicGas:  gasStaticCallEIP2929,
+			minStack:    minStack(6, 1),
+			maxStack:    maxStack(6, 1),
+			memorySize:  memoryStaticCall,
+		},
+		RETURN: {
+			execute:     returnOp,
+			constantGas: 0,
+			dynamicGas:  gasReturn,
+			minStack:    minStack(2, 0),
+			maxStack:    maxStack(2, 0),
+			memorySize:  memoryReturn,
+		},
+		REVERT: {
+			execute:     revertOp,
+			constantGas: 0,
+			dynamicGas:  gasRevert,
+			minStack:    minStack(2, 0),
+			maxStack:    maxStack(2, 0),
+			memorySize:  memoryRevert,
+		},
+		INVALID: {
+			execute:     invalidOp,
+			constantGas: 0,
+			minStack:    minStack(0, 0),
+			maxStack:    maxStack(0, 0),
+		},
+		SELFDESTRUCT: {
+			execute:     selfdestructOp,
+			constantGas: SelfdestructGasEIP150,
+			dynamicGas:  gasSelfdestructEIP3529,
+			minStack:    minStack(1, 0),
+			maxStack:    maxStack(1, 0),
 		},
 	}
 
@@ -236,7 +1002,8 @@ func validateInstructionSet(is ISet) ISet {
 	for i := 0; i < 256; i++ {
 		if is[OpCode(i)] == nil {
 			is[OpCode(i)] = &instruction{
-				execute: invalidOp,
+				execute:  invalidOp,
+				maxStack: maxStack(0, 0),
 			}
 		}
 	}
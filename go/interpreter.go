@@ -1,16 +1,14 @@
 package main
 
 import (
-	"encoding/hex"
-	"fmt"
-	"strconv"
-
 	"github.com/holiman/uint256"
 )
 
 type Interpreter struct {
 	vm             *VM
 	instructionSet ISet
+
+	readOnly bool // Whether to throw on stateful modifications
 }
 
 func NewInterpreter(vm *VM) *Interpreter {
@@ -21,27 +19,40 @@ func NewInterpreter(vm *VM) *Interpreter {
 	}
 }
 
-
 type executionContext struct {
 	pc          uint64
 	halt        bool
 	done        bool
+	err         error
+	depth       int
 	code        []byte
+	jumpdests   []bool
+	contract    *contract
 	block       *Block
-	state       interface{}
+	state       *StateDB
 	stack       *stackStruct
 	memory      *memoryStruct
-	storage     *storageStruct
-	returnData  string
+	returnData  []byte
 	transaction *Transaction
+
+	// callReturnData holds the output of the last call made by this frame,
+	// it is what RETURNDATASIZE and RETURNDATACOPY read from.
+	callReturnData []byte
+	// callGasTemp holds the gas available for the current call, computed
+	// by the gas function of the CALL instruction before it executes.
+	callGasTemp uint64
+	// unmetered frames use no gas, see Config.Unmetered
+	unmetered bool
 }
 
+// contract is the account whose code runs in an execution context, along
+// with the message that invoked it.
 type contract struct {
-	CallerAddress string
-	OriginAddress string
+	CallerAddress Address
+	Address       Address
 	CallValue     *uint256.Int
-	GasLimit      uint64
-	GasPrice      *uint256.Int
+	Input         []byte
+	Gas           uint64
 	Code          []byte
 }
 
@@ -52,64 +63,51 @@ func (ctx *executionContext) readCode(n uint64) ([]byte, uint64) {
 	return value, n
 }
 
-func (ctx *executionContext) GetBalance(address string) *uint256.Int {
-	if ctx.state == nil {
-		return uint256.NewInt(0)
+// useGas attempts the use gas and subtracts it and returns true on success
+func (ctx *executionContext) useGas(gas uint64) bool {
+	if ctx.unmetered {
+		return true
 	}
-	obj, _ := ctx.state.(map[string]interface{})
-
-	balanceI, _ := obj[address].(map[string]interface{})
-
-	balance := balanceI["balance"].(string)
-
-	uBalance, err := strconv.ParseUint(balance, 10, 64)
-	if err != nil {
-		fmt.Println("Error", err)
+	if ctx.contract.Gas < gas {
+		return false
 	}
+	ctx.contract.Gas -= gas
+	return true
+}
 
-	uBalance256 := new(uint256.Int).SetUint64(uBalance)
-
-	fmt.Println(balance)
-	return uBalance256
+// fail aborts the execution of the context with err.
+func (ctx *executionContext) fail(err error) {
+	ctx.err = err
+	ctx.halt = true
 }
 
-func (ctx *executionContext) GetCodeSize(address string) uint64 {
-	if ctx.state == nil {
-		return 0
+// validJumpDest checks whether dest is a JUMPDEST instruction, and not a
+// 0x5b byte inside the immediate of a PUSH.
+func (ctx *executionContext) validJumpDest(dest *uint256.Int) bool {
+	udest, overflow := dest.Uint64WithOverflow()
+	if overflow || udest >= uint64(len(ctx.code)) {
+		return false
 	}
-	obj, _ := ctx.state.(map[string]interface{})
-
-	codeI, _ := obj[address].(map[string]interface{})
-
-	code := codeI["code"].(map[string]interface{})
-
-	bin := code["bin"].(string)
-
-	binBytes, err := hex.DecodeString(bin)
-	if err != nil {
-		fmt.Println("Error", err)
+	if OpCode(ctx.code[udest]) != JUMPDEST {
+		return false
 	}
-
-	return uint64(len(binBytes))
-}
-
-func (ctx *executionContext) GetCode(address string) []byte {
-	if ctx.state == nil {
-		return []byte{}
+	if ctx.jumpdests == nil {
+		ctx.jumpdests = analyzeJumpDests(ctx.code)
 	}
+	return ctx.jumpdests[udest]
+}
 
-	obj, _ := ctx.state.(map[string]interface{})
-
-	codeI, _ := obj[address].(map[string]interface{})
-
-	code := codeI["code"].(map[string]interface{})
-
-	bin := code["bin"].(string)
-
-	binBytes, err := hex.DecodeString(bin)
-	if err != nil {
-		fmt.Println("Error", err)
+// analyzeJumpDests marks the offsets of the code that hold a JUMPDEST
+// instruction.
+func analyzeJumpDests(code []byte) []bool {
+	dests := make([]bool, len(code))
+	for pc := 0; pc < len(code); pc++ {
+		op := OpCode(code[pc])
+		if op == JUMPDEST {
+			dests[pc] = true
+		} else if op >= PUSH1 && op <= PUSH32 {
+			pc += int(op - PUSH1 + 1)
+		}
 	}
-
-	return binBytes
+	return dests
 }
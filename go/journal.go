@@ -0,0 +1,281 @@
+// Copyright 2016 The go-ethereum Authors
+// This file is derived from core/state/journal.go of the go-ethereum
+// library, and modified for this EVM.
+//
+// This file is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This file is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this file. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"github.com/holiman/uint256"
+)
+
+// journalEntry is a modification entry in the state change journal that can be
+// reverted on demand.
+type journalEntry interface {
+	// revert undoes the changes introduced by this journal entry.
+	revert(*StateDB)
+
+	// dirtied returns the address modified by this journal entry.
+	dirtied() *Address
+}
+
+// journal contains the list of state modifications applied since the last state
+// commit. These are tracked to be able to be reverted in the case of an execution
+// exception or request for reversal.
+type journal struct {
+	entries []journalEntry
+}
+
+func newJournal() *journal {
+	return &journal{}
+}
+
+func (j *journal) append(entry journalEntry) {
+	j.entries = append(j.entries, entry)
+}
+
+// revert undoes a batch of journalled modifications.
+func (j *journal) revert(state *StateDB, snapshot int) {
+	for i := len(j.entries) - 1; i >= snapshot; i-- {
+		j.entries[i].revert(state)
+	}
+	j.entries = j.entries[:snapshot]
+}
+
+func (j *journal) length() int {
+	return len(j.entries)
+}
+
+// dirties returns the addresses modified by the journalled changes, the
+// accounts touched by the transaction so far.
+func (j *journal) dirties() map[Address]struct{} {
+	dirties := make(map[Address]struct{})
+	for _, entry := range j.entries {
+		if addr := entry.dirtied(); addr != nil {
+			dirties[*addr] = struct{}{}
+		}
+	}
+	return dirties
+}
+
+// origin returns a copy of the account at addr as it was when the journal
+// started, by undoing the journalled changes to it, or nil if it didn't
+// exist then. The copy shares the storage of the account.
+func (j *journal) origin(state *StateDB, addr Address) *stateObject {
+	var obj *stateObject
+	if current := state.getObject(addr); current != nil {
+		c := *current
+		obj = &c
+	}
+	for i := len(j.entries) - 1; i >= 0; i-- {
+		switch ch := j.entries[i].(type) {
+		case createObjectChange:
+			if ch.account == addr {
+				obj = nil
+			}
+		case resetObjectChange:
+			if ch.prev.address == addr {
+				c := *ch.prev
+				obj = &c
+			}
+		case createContractChange:
+			if ch.account == addr && obj != nil {
+				obj.newContract = false
+			}
+		case suicideChange:
+			if ch.account == addr && obj != nil {
+				obj.suicided, obj.balance = ch.prev, ch.prevbalance
+			}
+		case balanceChange:
+			if ch.account == addr && obj != nil {
+				obj.balance = ch.prev
+			}
+		case nonceChange:
+			if ch.account == addr && obj != nil {
+				obj.nonce = ch.prev
+			}
+		case codeChange:
+			if ch.account == addr && obj != nil {
+				obj.code = ch.prevcode
+			}
+		}
+	}
+	return obj
+}
+
+type (
+	createObjectChange struct {
+		account Address
+	}
+	resetObjectChange struct {
+		prev *stateObject
+	}
+	createContractChange struct {
+		account Address
+	}
+	suicideChange struct {
+		account     Address
+		prev        bool // whether account had already suicided
+		prevbalance *uint256.Int
+	}
+	balanceChange struct {
+		account Address
+		prev    *uint256.Int
+	}
+	nonceChange struct {
+		account Address
+		prev    uint64
+	}
+	codeChange struct {
+		account  Address
+		prevcode []byte
+	}
+	storageChange struct {
+		account  Address
+		key      Hash
+		prevalue Hash
+	}
+	transientStorageChange struct {
+		account  Address
+		key      Hash
+		prevalue Hash
+	}
+	refundChange struct {
+		prev uint64
+	}
+	addLogChange               struct{}
+	accessListAddAccountChange struct {
+		address *Address
+	}
+	accessListAddSlotChange struct {
+		address *Address
+		slot    *Hash
+	}
+)
+
+func (ch createObjectChange) revert(s *StateDB) {
+	delete(s.accounts, ch.account)
+}
+
+func (ch createObjectChange) dirtied() *Address {
+	return &ch.account
+}
+
+func (ch resetObjectChange) revert(s *StateDB) {
+	s.accounts[ch.prev.address] = ch.prev
+}
+
+func (ch resetObjectChange) dirtied() *Address {
+	return nil
+}
+
+func (ch createContractChange) revert(s *StateDB) {
+	s.getObject(ch.account).newContract = false
+}
+
+func (ch createContractChange) dirtied() *Address {
+	return &ch.account
+}
+
+func (ch suicideChange) revert(s *StateDB) {
+	obj := s.getObject(ch.account)
+	obj.suicided = ch.prev
+	obj.balance = ch.prevbalance
+}
+
+func (ch suicideChange) dirtied() *Address {
+	return &ch.account
+}
+
+func (ch balanceChange) revert(s *StateDB) {
+	s.getObject(ch.account).balance = ch.prev
+}
+
+func (ch balanceChange) dirtied() *Address {
+	return &ch.account
+}
+
+func (ch nonceChange) revert(s *StateDB) {
+	s.getObject(ch.account).nonce = ch.prev
+}
+
+func (ch nonceChange) dirtied() *Address {
+	return &ch.account
+}
+
+func (ch codeChange) revert(s *StateDB) {
+	s.getObject(ch.account).code = ch.prevcode
+}
+
+func (ch codeChange) dirtied() *Address {
+	return &ch.account
+}
+
+func (ch storageChange) revert(s *StateDB) {
+	s.getObject(ch.account).storage.set(ch.key, ch.prevalue)
+}
+
+func (ch storageChange) dirtied() *Address {
+	return &ch.account
+}
+
+func (ch transientStorageChange) revert(s *StateDB) {
+	s.setTransientState(ch.account, ch.key, ch.prevalue)
+}
+
+func (ch transientStorageChange) dirtied() *Address {
+	return nil
+}
+
+func (ch refundChange) revert(s *StateDB) {
+	s.refund = ch.prev
+}
+
+func (ch refundChange) dirtied() *Address {
+	return nil
+}
+
+func (ch addLogChange) revert(s *StateDB) {
+	s.logs = s.logs[:len(s.logs)-1]
+}
+
+func (ch addLogChange) dirtied() *Address {
+	return nil
+}
+
+func (ch accessListAddAccountChange) revert(s *StateDB) {
+	/*
+		One important invariant here, is that whenever a (addr, slot) is added, if the
+		addr is not already present, the add causes two journal entries:
+		- one for the address,
+		- one for the (address,slot)
+		Therefore, when unrolling the change, we can always blindly delete the
+		(addr) at this point, since no storage adds can remain when come upon
+		a single (addr) change.
+	*/
+	s.accessList.DeleteAddress(*ch.address)
+}
+
+func (ch accessListAddAccountChange) dirtied() *Address {
+	return nil
+}
+
+func (ch accessListAddSlotChange) revert(s *StateDB) {
+	s.accessList.DeleteSlot(*ch.address, *ch.slot)
+}
+
+func (ch accessListAddSlotChange) dirtied() *Address {
+	return nil
+}
@@ -0,0 +1,125 @@
+// Copyright 2014 The go-ethereum Authors
+// This file is derived from core/vm/contracts.go and crypto/kzg4844 of the
+// go-ethereum library, and modified for this EVM.
+//
+// This file is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This file is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this file. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	gokzg4844 "github.com/crate-crypto/go-kzg-4844"
+)
+
+// trustedSetup is the setup of the Ethereum KZG ceremony, it is embedded so
+// the point evaluation precompile runs without any external file.
+//
+//go:embed trusted_setup.json
+var trustedSetup []byte
+
+var (
+	kzgContext     *gokzg4844.Context
+	kzgContextErr  error
+	kzgContextOnce sync.Once
+)
+
+// loadKZGContext parses the trusted setup, only once and only when the first
+// proof is verified as this takes a while.
+func loadKZGContext() (*gokzg4844.Context, error) {
+	kzgContextOnce.Do(func() {
+		setup := new(gokzg4844.JSONTrustedSetup)
+		if kzgContextErr = json.Unmarshal(trustedSetup, setup); kzgContextErr != nil {
+			return
+		}
+		kzgContext, kzgContextErr = gokzg4844.NewContext4096(setup)
+	})
+	return kzgContext, kzgContextErr
+}
+
+const (
+	blobVerifyInputLength          = 192  // Max input length for the point evaluation precompile.
+	blobCommitmentVersionKZG uint8 = 0x01 // Version byte for the point evaluation precompile.
+)
+
+// blobPrecompileReturnValue is returned by a successful point evaluation,
+// FIELD_ELEMENTS_PER_BLOB followed by BLS_MODULUS.
+var blobPrecompileReturnValue, _ = hex.DecodeString("000000000000000000000000000000000000000000000000000000000000100073eda753299d7d483339d80809a1d80553bda402fffe5bfeffffffff00000001")
+
+var (
+	errBlobVerifyInvalidInputLength = errors.New("invalid input length")
+	errBlobVerifyMismatchedVersion  = errors.New("mismatched versioned hash")
+	errBlobVerifyKZGProof           = errors.New("error verifying kzg proof")
+)
+
+// kzgPointEvaluation implements the EIP-4844 point evaluation precompile.
+type kzgPointEvaluation struct{}
+
+// RequiredGas estimates the gas required for running the point evaluation precompile.
+func (b *kzgPointEvaluation) RequiredGas(input []byte) uint64 {
+	return BlobTxPointEvaluationPrecompileGas
+}
+
+// Run executes the point evaluation precompile.
+func (b *kzgPointEvaluation) Run(input []byte) ([]byte, error) {
+	if len(input) != blobVerifyInputLength {
+		return nil, errBlobVerifyInvalidInputLength
+	}
+	// versioned hash: first 32 bytes
+	var versionedHash Hash
+	copy(versionedHash[:], input[:])
+
+	var (
+		point gokzg4844.Scalar
+		claim gokzg4844.Scalar
+	)
+	// Evaluation point: next 32 bytes
+	copy(point[:], input[32:])
+	// Expected output: next 32 bytes
+	copy(claim[:], input[64:])
+
+	// input kzg point: next 48 bytes
+	var commitment gokzg4844.KZGCommitment
+	copy(commitment[:], input[96:])
+	if kzgToVersionedHash(commitment) != versionedHash {
+		return nil, errBlobVerifyMismatchedVersion
+	}
+
+	// Proof: next 48 bytes
+	var proof gokzg4844.KZGProof
+	copy(proof[:], input[144:])
+
+	ctx, err := loadKZGContext()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errBlobVerifyKZGProof, err)
+	}
+	if err := ctx.VerifyKZGProof(commitment, point, claim, proof); err != nil {
+		return nil, fmt.Errorf("%w: %v", errBlobVerifyKZGProof, err)
+	}
+	return blobPrecompileReturnValue, nil
+}
+
+// kzgToVersionedHash implements kzg_to_versioned_hash from EIP-4844
+func kzgToVersionedHash(kzg gokzg4844.KZGCommitment) Hash {
+	h := sha256.Sum256(kzg[:])
+	h[0] = blobCommitmentVersionKZG
+
+	return h
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LoadTestCases reads the test cases in path, either the scripts/evm.yaml
+// source or the evm.json generated from it, depending on the extension.
+func LoadTestCases(path string) ([]TestCase, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		return ParseYAMLTestCases(content)
+	default:
+		var tests []TestCase
+		if err := json.Unmarshal(content, &tests); err != nil {
+			return nil, err
+		}
+		return tests, nil
+	}
+}
+
+// ParseYAMLTestCases parses test cases in the format of scripts/evm.yaml. It
+// does what scripts/index.js does to generate evm.json: assembly code is
+// assembled, cases marked `todo` are skipped and bigint literals such as 1n
+// and 0xffn become decimal and hex strings.
+func ParseYAMLTestCases(content []byte) ([]TestCase, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("line %d: test cases must be a mapping of names to cases", root.Line)
+	}
+
+	// Convert the cases to their evm.json form and decode that, so that both
+	// formats are decoded the same way. The mapping is walked in order to keep
+	// the cases in file order.
+	var tests []TestCase
+	for i := 0; i < len(root.Content); i += 2 {
+		name := root.Content[i].Value
+		value, err := yamlTestValue(root.Content[i+1], name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		fields, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		fields["name"] = name
+		data, err := json.Marshal(fields)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		var test TestCase
+		if err := json.Unmarshal(data, &test); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		tests = append(tests, test)
+	}
+	return tests, nil
+}
+
+// yamlTestValue converts the yaml node stored under key to its evm.json
+// value. It returns nil for cases marked `todo`.
+func yamlTestValue(node *yaml.Node, key string) (interface{}, error) {
+	switch node.Kind {
+	case yaml.AliasNode:
+		return yamlTestValue(node.Alias, key)
+
+	case yaml.MappingNode:
+		fields := make(map[string]interface{}, len(node.Content)/2)
+		for i := 0; i < len(node.Content); i += 2 {
+			name := node.Content[i].Value
+			// The keys of the state are addresses, those of storage slots
+			if key == "state" || key == "storage" {
+				name = parseYAMLBigInt(name)
+			}
+			value, err := yamlTestValue(node.Content[i+1], name)
+			if err != nil {
+				return nil, err
+			}
+			if value != nil {
+				fields[name] = value
+			}
+		}
+		if todo, ok := fields["todo"].(bool); ok && todo {
+			return nil, nil
+		}
+		return fields, nil
+
+	case yaml.SequenceNode:
+		items := make([]interface{}, 0, len(node.Content))
+		for i, item := range node.Content {
+			value, err := yamlTestValue(item, fmt.Sprint(i))
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, value)
+		}
+		if key == "code" {
+			return yamlCode(items)
+		}
+		return items, nil
+
+	case yaml.ScalarNode:
+		switch node.ShortTag() {
+		case "!!null":
+			return nil, nil
+		case "!!bool":
+			var b bool
+			if err := node.Decode(&b); err != nil {
+				return nil, err
+			}
+			return b, nil
+		}
+		if key == "code" {
+			// Code given as bytecode
+			return map[string]interface{}{"asm": nil, "bin": node.Value}, nil
+		}
+		return parseYAMLBigInt(node.Value), nil
+	}
+	return nil, fmt.Errorf("line %d: unexpected yaml node", node.Line)
+}
+
+// yamlCode assembles code given as a list of instructions, keeping the
+// original source.
+func yamlCode(lines []interface{}) (interface{}, error) {
+	var src []string
+	for _, line := range lines {
+		s, ok := line.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid instruction %v", line)
+		}
+		src = append(src, s)
+	}
+	asm := strings.Join(src, "\n")
+	bin, err := Assemble(asm)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"asm": asm, "bin": hex.EncodeToString(bin)}, nil
+}
+
+// yamlBigInt matches bigint literals.
+var yamlBigInt = regexp.MustCompile(`^(0x)?[0-9a-f_]+n$`)
+
+// parseYAMLBigInt turns bigint literals into strings, preserving hex vs
+// decimal. Other values are returned unchanged.
+func parseYAMLBigInt(value string) string {
+	if !yamlBigInt.MatchString(value) {
+		return value
+	}
+	digits := strings.ReplaceAll(strings.TrimSuffix(value, "n"), "_", "")
+	if hexDigits := strings.TrimPrefix(digits, "0x"); hexDigits != digits {
+		if n, ok := new(big.Int).SetString(hexDigits, 16); ok {
+			return "0x" + n.Text(16)
+		}
+		return value
+	}
+	if n, ok := new(big.Int).SetString(digits, 10); ok {
+		return n.Text(10)
+	}
+	return value
+}
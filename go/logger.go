@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/holiman/uint256"
+)
+
+// jsonStep is an EIP-3155 trace line, the state before an opcode executes.
+type jsonStep struct {
+	Pc      uint64   `json:"pc"`
+	Op      OpCode   `json:"op"`
+	Gas     string   `json:"gas"`
+	GasCost string   `json:"gasCost"`
+	MemSize int      `json:"memSize"`
+	Stack   []string `json:"stack"`
+	Depth   int      `json:"depth"`
+	Refund  uint64   `json:"refund"`
+	OpName  string   `json:"opName"`
+	Error   string   `json:"error,omitempty"`
+	// Source is the source position of the opcode, which is not part of
+	// EIP-3155, when the config has source maps
+	Source string `json:"source,omitempty"`
+}
+
+// jsonSummary is the EIP-3155 line summarizing a transaction.
+type jsonSummary struct {
+	Output  string `json:"output"`
+	GasUsed string `json:"gasUsed"`
+	Time    int64  `json:"time"` // nanoseconds
+	Error   string `json:"error,omitempty"`
+	// RevertReason is the decoded revert data, which is not part of EIP-3155
+	RevertReason string `json:"revertReason,omitempty"`
+}
+
+// JSONLogger is a Tracer writing the execution in the format of EIP-3155:
+// one JSON object per line for every opcode, followed by a summary of the
+// transaction. It's the format other clients trace in, so traces can be
+// compared line by line.
+type JSONLogger struct {
+	enc     *json.Encoder
+	start   time.Time
+	sources *SourceMaps
+	errs    *abi.ABI
+}
+
+// NewJSONLogger returns a JSONLogger writing to w.
+func NewJSONLogger(w io.Writer) *JSONLogger {
+	return &JSONLogger{enc: json.NewEncoder(w)}
+}
+
+func (l *JSONLogger) CaptureTxStart(gasLimit uint64) {}
+
+func (l *JSONLogger) CaptureTxEnd(restGas uint64) {}
+
+func (l *JSONLogger) CaptureStart(vm *VM, from Address, to Address, create bool, input []byte, gas uint64, value *uint256.Int) {
+	l.start, l.sources, l.errs = time.Now(), vm.Config.Sources, vm.Config.ABI
+}
+
+func (l *JSONLogger) CaptureState(pc uint64, op OpCode, gas, cost uint64, scope *executionContext, rData []byte, depth int, err error) {
+	// The stack is listed from its bottom to its top
+	stack := make([]string, len(scope.stack.data))
+	for i := range scope.stack.data {
+		stack[len(stack)-1-i] = scope.stack.data[i].Hex()
+	}
+	step := jsonStep{
+		Pc:      pc,
+		Op:      op,
+		Gas:     fmt.Sprintf("0x%x", gas),
+		GasCost: fmt.Sprintf("0x%x", cost),
+		MemSize: len(scope.memory.data),
+		Stack:   stack,
+		Depth:   depth,
+		Refund:  scope.state.GetRefund(),
+		OpName:  op.String(),
+	}
+	if err != nil {
+		step.Error = err.Error()
+	}
+	if pos, ok := l.sources.Position(scope.code, pc); ok {
+		step.Source = pos.String()
+	}
+	l.enc.Encode(step)
+}
+
+// CaptureFault logs the opcode again with the error it failed with, as
+// go-ethereum does, once the opcode has run.
+func (l *JSONLogger) CaptureFault(pc uint64, op OpCode, gas, cost uint64, scope *executionContext, depth int, err error) {
+	l.CaptureState(pc, op, gas, cost, scope, nil, depth, err)
+}
+
+func (l *JSONLogger) CaptureEnd(output []byte, gasUsed uint64, err error) {
+	summary := jsonSummary{
+		Output:  hex.EncodeToString(output),
+		GasUsed: fmt.Sprintf("0x%x", gasUsed),
+		Time:    time.Since(l.start).Nanoseconds(),
+	}
+	if err != nil {
+		summary.Error = err.Error()
+	}
+	summary.RevertReason = revertReason(err, output, l.errs)
+	l.enc.Encode(summary)
+}
+
+func (l *JSONLogger) CaptureEnter(typ OpCode, from Address, to Address, input []byte, gas uint64, value *uint256.Int) {
+}
+
+func (l *JSONLogger) CaptureExit(output []byte, gasUsed uint64, err error) {}
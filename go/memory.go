@@ -5,7 +5,8 @@ import (
 )
 
 type memoryStruct struct {
-	data []byte
+	data        []byte
+	lastGasCost uint64
 }
 
 func newMemory() *memoryStruct {
@@ -21,7 +22,21 @@ func (m *memoryStruct) get(offset, size uint64) []byte {
 	return nil
 }
 
+// getCopy returns a copy of the memory region, which is safe to keep around
+// after the memory is modified.
+func (m *memoryStruct) getCopy(offset, size uint64) []byte {
+	if size == 0 {
+		return nil
+	}
+	cpy := make([]byte, size)
+	copy(cpy, m.get(offset, size))
+	return cpy
+}
+
 func (m *memoryStruct) set(offset, size uint64, value []byte) {
+	if size == 0 {
+		return
+	}
 	if offset+size > uint64(len(m.data)) {
 		panic("invalid memory: store empty")
 	}
@@ -29,7 +44,16 @@ func (m *memoryStruct) set(offset, size uint64, value []byte) {
 	if len(value) > len(m.data) {
 		m.resize(uint64(len(value)))
 	}
-	copy(m.data[offset:], value[:])
+	copy(m.data[offset:offset+size], value[:])
+}
+
+// copy copies size bytes from the src offset to the dst offset, the regions
+// may overlap. The memory should be resized PRIOR to copying.
+func (m *memoryStruct) copy(dst, src, size uint64) {
+	if size == 0 {
+		return
+	}
+	copy(m.data[dst:], m.data[src:src+size])
 }
 
 func (m *memoryStruct) set32(offset uint64, val *uint256.Int) {
@@ -44,7 +68,7 @@ func (m *memoryStruct) set32(offset uint64, val *uint256.Int) {
 }
 
 func (m *memoryStruct) resize(size uint64) {
-	if uint64(len(m.data)) <= size {
-		m.data = append(m.data, make([]byte, size)...)
+	if uint64(len(m.data)) < size {
+		m.data = append(m.data, make([]byte, size-uint64(len(m.data)))...)
 	}
 }
@@ -28,10 +28,24 @@ func memoryCodeCopy(stack *stackStruct) (uint64, bool) {
 	return calcMemSize64WithUint(stack.Back(0), stack.Back(2).Uint64())
 }
 
+func memoryReturnDataCopy(stack *stackStruct) (uint64, bool) {
+	return calcMemSize64WithUint(stack.Back(0), stack.Back(2).Uint64())
+}
+
 func memoryExtCodeCopy(stack *stackStruct) (uint64, bool) {
 	return calcMemSize64WithUint(stack.Back(1), stack.Back(3).Uint64())
 }
 
+// memoryMcopy returns the memory size needed by MCOPY, to read from the
+// source and write to the destination.
+func memoryMcopy(stack *stackStruct) (uint64, bool) {
+	mStart := stack.Back(0)
+	if stack.Back(1).Gt(mStart) {
+		mStart = stack.Back(1)
+	}
+	return calcMemSize64WithUint(mStart, stack.Back(2).Uint64())
+}
+
 func memoryReturn(stack *stackStruct) (uint64, bool) {
 	return calcMemSize64WithUint(stack.Back(0), stack.Back(1).Uint64())
 }
@@ -40,8 +54,53 @@ func memoryRevert(stack *stackStruct) (uint64, bool) {
 	return calcMemSize64WithUint(stack.Back(0), stack.Back(1).Uint64())
 }
 
+func memoryLog(stack *stackStruct) (uint64, bool) {
+	return calcMemSize64WithUint(stack.Back(0), stack.Back(1).Uint64())
+}
+
 func memoryCall(stack *stackStruct) (uint64, bool) {
-	return calcMemSize64WithUint(stack.Back(3), stack.Back(4).Uint64())
+	x, overflow := calcMemSize64WithUint(stack.Back(5), stack.Back(6).Uint64())
+	if overflow {
+		return 0, true
+	}
+	y, overflow := calcMemSize64WithUint(stack.Back(3), stack.Back(4).Uint64())
+	if overflow {
+		return 0, true
+	}
+	if x > y {
+		return x, false
+	}
+	return y, false
+}
+
+func memoryDelegateCall(stack *stackStruct) (uint64, bool) {
+	x, overflow := calcMemSize64WithUint(stack.Back(4), stack.Back(5).Uint64())
+	if overflow {
+		return 0, true
+	}
+	y, overflow := calcMemSize64WithUint(stack.Back(2), stack.Back(3).Uint64())
+	if overflow {
+		return 0, true
+	}
+	if x > y {
+		return x, false
+	}
+	return y, false
+}
+
+func memoryStaticCall(stack *stackStruct) (uint64, bool) {
+	x, overflow := calcMemSize64WithUint(stack.Back(4), stack.Back(5).Uint64())
+	if overflow {
+		return 0, true
+	}
+	y, overflow := calcMemSize64WithUint(stack.Back(2), stack.Back(3).Uint64())
+	if overflow {
+		return 0, true
+	}
+	if x > y {
+		return x, false
+	}
+	return y, false
 }
 
 func memoryCreate(stack *stackStruct) (uint64, bool) {
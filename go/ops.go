@@ -1,17 +1,15 @@
 package main
 
 import (
-	"encoding/hex"
-	"fmt"
-	"strconv"
+	"math"
 
-	"github.com/blocktree/openwallet/crypto"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/holiman/uint256"
 )
 
 func invalidOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
-	fmt.Println("invalid opcode", ctx.code)
+	ctx.fail(&ErrInvalidOpCode{opcode: OpCode(ctx.code[pc])})
 	return ctx.stack.data
 }
 
@@ -38,15 +36,21 @@ func push1Op(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint2
 	if ctx.pc < codeLen {
 		data := ctx.code[ctx.pc : ctx.pc+1]
 		value.SetBytes(data)
-		ctx.stack.push(value)
 	}
+	ctx.stack.push(value)
+	return ctx.stack.data
+}
+
+func push0Op(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
+	ctx.stack.push(uint256.Int{})
 	return ctx.stack.data
 }
 
 func makePush(n uint64) func(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
-	var value uint256.Int
 	return func(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
-		data := ctx.code[ctx.pc+1 : ctx.pc+n+1]
+		var value uint256.Int
+		// the immediate is right-padded with zeros when the code ends early
+		data := getData(ctx.code, ctx.pc+1, n)
 		value.SetBytes(data)
 		ctx.stack.push(value)
 		ctx.pc += n
@@ -250,61 +254,135 @@ func byteOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint25
 	b = ctx.stack.pop()
 	result = *b.Byte(&a)
 	ctx.stack.push(result)
-	ctx.pc++
 	return ctx.stack.data
 }
 
-func dup1Op(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
-	result := ctx.stack.peek()
-	ctx.stack.push(*result)
+func addmodOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
+	var result, a, b, n uint256.Int
+
+	a = ctx.stack.pop()
+	b = ctx.stack.pop()
+	n = ctx.stack.pop()
+	result.AddMod(&a, &b, &n)
+	ctx.stack.push(result)
 	return ctx.stack.data
 }
 
-func dup3Op(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
-	result := ctx.stack.peekN(2)
+func mulmodOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
+	var result, a, b, n uint256.Int
+
+	a = ctx.stack.pop()
+	b = ctx.stack.pop()
+	n = ctx.stack.pop()
+	result.MulMod(&a, &b, &n)
 	ctx.stack.push(result)
 	return ctx.stack.data
 }
 
-func swap1Op(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
-	ctx.stack.swap(1)
+func expOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
+	var result, base, exponent uint256.Int
+
+	base = ctx.stack.pop()
+	exponent = ctx.stack.pop()
+	result.Exp(&base, &exponent)
+	ctx.stack.push(result)
 	return ctx.stack.data
 }
 
-func swap3Op(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
-	ctx.stack.swap(3)
+func signExtendOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
+	var result, back, num uint256.Int
+
+	back = ctx.stack.pop()
+	num = ctx.stack.pop()
+	result.ExtendSign(&num, &back)
+	ctx.stack.push(result)
 	return ctx.stack.data
 }
 
+func shlOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
+	var result, shift, value uint256.Int
+
+	shift = ctx.stack.pop()
+	value = ctx.stack.pop()
+	if shift.LtUint64(256) {
+		result.Lsh(&value, uint(shift.Uint64()))
+	}
+	ctx.stack.push(result)
+	return ctx.stack.data
+}
+
+func shrOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
+	var result, shift, value uint256.Int
+
+	shift = ctx.stack.pop()
+	value = ctx.stack.pop()
+	if shift.LtUint64(256) {
+		result.Rsh(&value, uint(shift.Uint64()))
+	}
+	ctx.stack.push(result)
+	return ctx.stack.data
+}
+
+func sarOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
+	var result, shift, value uint256.Int
+
+	shift = ctx.stack.pop()
+	value = ctx.stack.pop()
+	if shift.GtUint64(255) {
+		// shifting by 256 or more leaves only the sign
+		if value.Sign() < 0 {
+			result.SetAllOne()
+		}
+	} else {
+		result.SRsh(&value, uint(shift.Uint64()))
+	}
+	ctx.stack.push(result)
+	return ctx.stack.data
+}
+
+func makeDup(n int64) executionFunc {
+	return func(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
+		result := ctx.stack.peekN(n - 1)
+		ctx.stack.push(result)
+		return ctx.stack.data
+	}
+}
+
+func makeSwap(n int64) executionFunc {
+	return func(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
+		ctx.stack.swap(n)
+		return ctx.stack.data
+	}
+}
+
 func jumpOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
-	ctx.stack.pop()
-	ctx.pc += 2
+	pos := ctx.stack.pop()
+	if !ctx.validJumpDest(&pos) {
+		ctx.fail(ErrInvalidJump)
+		return ctx.stack.data
+	}
+	ctx.pc = pos.Uint64() - 1 // pc will be increased by the interpreter loop
 	return ctx.stack.data
 }
 
 func jumpiOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
 	pos, cond := ctx.stack.pop(), ctx.stack.pop()
 	if !cond.IsZero() {
-		if !validJumpDest(ctx.code, &pos) {
+		if !ctx.validJumpDest(&pos) {
+			ctx.fail(ErrInvalidJump)
 			return ctx.stack.data
 		}
-		ctx.pc = pos.Uint64()
+		ctx.pc = pos.Uint64() - 1 // pc will be increased by the interpreter loop
 	}
 	return ctx.stack.data
 }
 
-func validJumpDest(code []byte, dest *uint256.Int) bool {
-	udest, overflow := dest.Uint64WithOverflow()
-	if overflow || udest >= uint64(len(code)) {
-		return false
-	}
-	if OpCode(code[udest]) != JUMPDEST {
-		return false
-	}
-	return true
+func jumpDestOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
+	return ctx.stack.data
 }
 
-func jumpDestOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
+func gasOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
+	ctx.stack.push(*new(uint256.Int).SetUint64(ctx.contract.Gas))
 	return ctx.stack.data
 }
 
@@ -352,114 +430,90 @@ func sha3Op(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint25
 }
 
 func addressOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
-	val, err := uint256.FromHex(ctx.transaction.To)
-	if err != nil {
-		fmt.Println("Error", err)
-	}
-
-	ctx.stack.push(*val)
-
+	ctx.stack.push(*ctx.contract.Address.Uint256())
 	return ctx.stack.data
 }
 
 func callerOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
-	val, err := uint256.FromHex(ctx.transaction.From)
-	if err != nil {
-		fmt.Println("Error", err)
-	}
-	ctx.stack.push(*val)
+	ctx.stack.push(*ctx.contract.CallerAddress.Uint256())
 	return ctx.stack.data
 }
 
 func balanceOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
 	address := ctx.stack.pop()
-	balance := ctx.GetBalance(address.String())
+	balance := ctx.state.GetBalance(Address(address.Bytes20()))
 	ctx.stack.push(*balance)
-
-	fmt.Println("stack", ctx.stack.data)
 	return ctx.stack.data
 }
 
 func originOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
-	val, err := uint256.FromHex(ctx.transaction.Origin)
-	if err != nil {
-		fmt.Println("Error", err)
+	ctx.stack.push(*ctx.transaction.Origin.Uint256())
+	return ctx.stack.data
+}
+
+// blockhashOp replaces the block number on the stack with the hash of that
+// block, if it is one of the 256 most recent complete blocks, or zero.
+func blockhashOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
+	num := ctx.stack.peek()
+	num64, overflow := num.Uint64WithOverflow()
+	if overflow || ctx.block.GetHash == nil {
+		num.Clear()
+		return ctx.stack.data
+	}
+	upper, overflow := interpreter.vm.blockValues.Number.Uint64WithOverflow()
+	if overflow {
+		upper = math.MaxUint64
+	}
+	var lower uint64
+	if upper > 256 {
+		lower = upper - 256
+	}
+	if num64 >= lower && num64 < upper {
+		hash := ctx.block.GetHash(num64)
+		num.SetBytes32(hash[:])
+	} else {
+		num.Clear()
 	}
-	ctx.stack.push(*val)
 	return ctx.stack.data
 }
 
 func coinbaseOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
-	val, err := uint256.FromHex(ctx.block.Coinbase)
-	if err != nil {
-		fmt.Println("Error", err)
-	}
-	ctx.stack.push(*val)
+	ctx.stack.push(*ctx.block.Coinbase.Uint256())
 	return ctx.stack.data
 }
 
 func timestampOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
-	timeStampUint, err := strconv.ParseUint(ctx.block.Timestamp, 10, 64)
-	if err != nil {
-		fmt.Println("Error", err)
-	}
-	ctx.stack.push(*new(uint256.Int).SetUint64(timeStampUint))
+	ctx.stack.push(*interpreter.vm.blockValues.Timestamp)
 	return ctx.stack.data
 }
 
 func numberOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
-	numberUint, err := strconv.ParseUint(ctx.block.Number, 10, 64)
-	if err != nil {
-		fmt.Println("Error", err)
-	}
-	ctx.stack.push(*new(uint256.Int).SetUint64(numberUint))
+	ctx.stack.push(*interpreter.vm.blockValues.Number)
 	return ctx.stack.data
 }
 
 func difficultyOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
-	difficultyUint, err := uint256.FromHex(ctx.block.Difficulty)
-	if err != nil {
-		fmt.Println("Error", err)
-	}
-
-	ctx.stack.push(*difficultyUint)
+	ctx.stack.push(*interpreter.vm.blockValues.Difficulty)
 	return ctx.stack.data
 }
 
 func gaslimitOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
-	gasLimitUint, err := uint256.FromHex(ctx.block.GasLimit)
-	if err != nil {
-		fmt.Println("Error", err)
-	}
-
-	ctx.stack.push(*gasLimitUint)
+	ctx.stack.push(*interpreter.vm.blockValues.GasLimit)
 	return ctx.stack.data
 }
 
 func gaspriceOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
-	gasPriceUint, err := strconv.ParseUint(ctx.transaction.GasPrice, 10, 64)
-	if err != nil {
-		fmt.Println("Error", err)
-	}
-	ctx.stack.push(*new(uint256.Int).SetUint64(gasPriceUint))
+	ctx.stack.push(*interpreter.vm.gasPrice)
 	return ctx.stack.data
 }
 
 func chainidOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
-	chainIdUint, err := strconv.ParseUint(ctx.block.ChainId, 10, 64)
-	if err != nil {
-		fmt.Println("Error", err)
-	}
-	ctx.stack.push(*new(uint256.Int).SetUint64(chainIdUint))
+	ctx.stack.push(*interpreter.vm.blockValues.ChainId)
 	return ctx.stack.data
 }
 
 func callvalueOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
-	callValueUint, err := strconv.ParseUint(ctx.transaction.Value, 10, 64)
-	if err != nil {
-		fmt.Println("Error", err)
-	}
-	ctx.stack.push(*new(uint256.Int).SetUint64(callValueUint))
+	ctx.stack.push(*ctx.contract.CallValue)
 	return ctx.stack.data
 }
 
@@ -467,11 +521,7 @@ func calldataloadOp(pc uint64, ctx *executionContext, interpreter *Interpreter)
 	x := ctx.stack.peek()
 
 	if o, overflow := x.Uint64WithOverflow(); !overflow {
-		b, err := hex.DecodeString(ctx.transaction.Data)
-		if err != nil {
-			fmt.Println("Error", err)
-		}
-		dt := getData(b, o, 32)
+		dt := getData(ctx.contract.Input, o, 32)
 		x.SetBytes(dt)
 	} else {
 		x.Clear()
@@ -505,11 +555,7 @@ func rightPadData(data []byte, size int) []byte {
 }
 
 func calldatasizeOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
-	data, err := hex.DecodeString(ctx.transaction.Data)
-	if err != nil {
-		fmt.Println("Error", err)
-	}
-	dataLen := uint64(len(data))
+	dataLen := uint64(len(ctx.contract.Input))
 	ctx.stack.push(*new(uint256.Int).SetUint64(dataLen))
 	return ctx.stack.data
 }
@@ -520,13 +566,9 @@ func calldatacopyOp(pc uint64, ctx *executionContext, interpreter *Interpreter)
 	mSize := ctx.stack.pop()
 
 	if o, overflow := offset.Uint64WithOverflow(); !overflow {
-		b, err := hex.DecodeString(ctx.transaction.Data)
-		if err != nil {
-			fmt.Println("Error", err)
-		}
-		ctx.memory.set(mOffset.Uint64(), mSize.Uint64(), getData(b, o, mSize.Uint64()))
+		ctx.memory.set(mOffset.Uint64(), mSize.Uint64(), getData(ctx.contract.Input, o, mSize.Uint64()))
 	} else {
-		mOffset.Clear()
+		ctx.memory.set(mOffset.Uint64(), mSize.Uint64(), make([]byte, mSize.Uint64()))
 	}
 
 	return ctx.stack.data
@@ -546,7 +588,7 @@ func codecopyOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []ui
 	if o, overflow := offset.Uint64WithOverflow(); !overflow {
 		ctx.memory.set(mOffset.Uint64(), mSize.Uint64(), getData(ctx.code, o, mSize.Uint64()))
 	} else {
-		mOffset.Clear()
+		ctx.memory.set(mOffset.Uint64(), mSize.Uint64(), make([]byte, mSize.Uint64()))
 	}
 
 	return ctx.stack.data
@@ -554,9 +596,8 @@ func codecopyOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []ui
 
 func extcodesizeOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
 	address := ctx.stack.pop()
-	addressHex := address.String()
 
-	codeSize := ctx.GetCodeSize(addressHex)
+	codeSize := ctx.state.GetCodeSize(Address(address.Bytes20()))
 	ctx.stack.push(*new(uint256.Int).SetUint64(codeSize))
 	return ctx.stack.data
 }
@@ -567,213 +608,382 @@ func extcodecopyOp(pc uint64, ctx *executionContext, interpreter *Interpreter) [
 	offset := ctx.stack.pop()
 	mSize := ctx.stack.pop()
 
-	addressHex := address.String()
-	code := ctx.GetCode(addressHex)
+	code := ctx.state.GetCode(Address(address.Bytes20()))
 
 	if o, overflow := offset.Uint64WithOverflow(); !overflow {
 		ctx.memory.set(mOffset.Uint64(), mSize.Uint64(), getData(code, o, mSize.Uint64()))
 	} else {
-		mOffset.Clear()
+		ctx.memory.set(mOffset.Uint64(), mSize.Uint64(), make([]byte, mSize.Uint64()))
 	}
 
 	return ctx.stack.data
 }
 
+func extcodehashOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
+	address := ctx.stack.pop()
+	addr := Address(address.Bytes20())
+
+	var result uint256.Int
+	if !ctx.state.Empty(addr) {
+		result.SetBytes(ctx.state.GetCodeHash(addr).Bytes())
+	}
+	ctx.stack.push(result)
+	return ctx.stack.data
+}
+
+func returndatasizeOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
+	ctx.stack.push(*new(uint256.Int).SetUint64(uint64(len(ctx.callReturnData))))
+	return ctx.stack.data
+}
+
+func returndatacopyOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
+	mOffset := ctx.stack.pop()
+	offset := ctx.stack.pop()
+	size := ctx.stack.pop()
+
+	offset64, overflow := offset.Uint64WithOverflow()
+	if overflow {
+		ctx.fail(ErrReturnDataOutOfBounds)
+		return ctx.stack.data
+	}
+	// we can reuse dataOffset now (aliasing it for clarity)
+	end, overflow := SafeAdd(offset64, size.Uint64())
+	if overflow || !size.IsUint64() || uint64(len(ctx.callReturnData)) < end {
+		ctx.fail(ErrReturnDataOutOfBounds)
+		return ctx.stack.data
+	}
+	ctx.memory.set(mOffset.Uint64(), size.Uint64(), ctx.callReturnData[offset64:end])
+	return ctx.stack.data
+}
+
 func selfbalanceOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
-	balance := ctx.GetBalance(ctx.transaction.To)
+	balance := ctx.state.GetBalance(ctx.contract.Address)
 	ctx.stack.push(*balance)
 	return ctx.stack.data
 }
 
+func basefeeOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
+	ctx.stack.push(*interpreter.vm.blockValues.BaseFee)
+	return ctx.stack.data
+}
+
+// blobHashOp replaces the index on the stack with the versioned hash of the
+// blob at that index in the transaction, or zero if there is no such blob.
+func blobHashOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
+	index := ctx.stack.peek()
+	if index.LtUint64(uint64(len(ctx.transaction.BlobVersionedHashes))) {
+		blobHash := ctx.transaction.BlobVersionedHashes[index.Uint64()]
+		index.SetBytes32(blobHash[:])
+	} else {
+		index.Clear()
+	}
+	return ctx.stack.data
+}
+
+func blobBaseFeeOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
+	ctx.stack.push(*interpreter.vm.blockValues.BlobBaseFee)
+	return ctx.stack.data
+}
+
 func sstoreOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
+	if interpreter.readOnly {
+		ctx.fail(ErrWriteProtection)
+		return ctx.stack.data
+	}
 	key := ctx.stack.pop()
 	value := ctx.stack.pop()
 
-	keyBytes := key.Bytes32()
-	valueBytes := value.Bytes()
-
-	ctx.storage.set(keyBytes, valueBytes)
-	// ctx.SetStorage(key.String(), value.String())
+	ctx.state.SetState(ctx.contract.Address, key.Bytes32(), value.Bytes32())
 	return ctx.stack.data
 }
 
 func sloadOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
-	var result uint256.Int
 	key := ctx.stack.pop()
 
-	keyBytes := key.Bytes32()
-	valueBytes := ctx.storage.get(keyBytes)
-
-	result.SetBytes(valueBytes)
-	ctx.stack.push(result)
+	value := ctx.state.GetState(ctx.contract.Address, key.Bytes32())
+	ctx.stack.push(*value.Uint256())
 	return ctx.stack.data
 }
 
-func returnOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
-	var returnData []byte
-	offset := ctx.stack.pop()
-	size := ctx.stack.pop()
+// tloadOp reads a slot of the contract's transient storage (EIP-1153).
+func tloadOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
+	key := ctx.stack.peek()
+	value := ctx.state.GetTransientState(ctx.contract.Address, key.Bytes32())
+	key.SetBytes32(value[:])
+	return ctx.stack.data
+}
 
-	if o, overflow := offset.Uint64WithOverflow(); !overflow {
-		if s, overflow := size.Uint64WithOverflow(); !overflow {
-			returnData = ctx.memory.get(o, s)
-		}
+// tstoreOp writes a slot of the contract's transient storage, which is
+// discarded at the end of the transaction (EIP-1153).
+func tstoreOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
+	if interpreter.readOnly {
+		ctx.fail(ErrWriteProtection)
+		return ctx.stack.data
 	}
+	key := ctx.stack.pop()
+	value := ctx.stack.pop()
 
-	trf := hex.EncodeToString(returnData)
+	ctx.state.SetTransientState(ctx.contract.Address, key.Bytes32(), value.Bytes32())
+	return ctx.stack.data
+}
 
-	ctx.returnData = trf
+// mcopyOp copies a region of memory to another, which may overlap it
+// (EIP-5656).
+func mcopyOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
+	dst := ctx.stack.pop()
+	src := ctx.stack.pop()
+	size := ctx.stack.pop()
 
-	ctx.done = true
+	// The offsets and size were checked for overflow by memoryMcopy
+	ctx.memory.copy(dst.Uint64(), src.Uint64(), size.Uint64())
 	return ctx.stack.data
 }
 
-func revertOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
-	var returnData []byte
+func returnOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
 	offset := ctx.stack.pop()
 	size := ctx.stack.pop()
 
-	if o, overflow := offset.Uint64WithOverflow(); !overflow {
-		if s, overflow := size.Uint64WithOverflow(); !overflow {
-			returnData = ctx.memory.get(o, s)
-		}
-	}
+	ctx.returnData = ctx.memory.getCopy(offset.Uint64(), size.Uint64())
+	ctx.halt = true
+	return ctx.stack.data
+}
 
-	ctx.returnData = hex.EncodeToString(returnData)
+func revertOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
+	offset := ctx.stack.pop()
+	size := ctx.stack.pop()
+
+	ctx.returnData = ctx.memory.getCopy(offset.Uint64(), size.Uint64())
+	ctx.fail(ErrExecutionReverted)
 	return ctx.stack.data
 }
 
 func callOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
+	// Pop gas. The actual gas in interpreter.callGasTemp.
 	_ = ctx.stack.pop()
+	gas := ctx.callGasTemp
 	to := ctx.stack.pop()
 	value := ctx.stack.pop()
 	inOffset := ctx.stack.pop()
 	inSize := ctx.stack.pop()
 	outOffset := ctx.stack.pop()
 	outSize := ctx.stack.pop()
-	fmt.Println("outsize", outSize)
-	toHex := to.String()
-	_ = value.String()
-	_ = inOffset.String()
-	_ = inSize.String()
-	_ = outOffset.String()
-	_ = outSize.String()
-
-	stateObj, _ := ctx.state.(map[string]interface{})
-	addrObj, _ := stateObj[toHex].(map[string]interface{})
-	codeObj, _ := addrObj["code"].(map[string]interface{})
-	codeStr, _ := codeObj["bin"].(string)
-
-	fromAddress := ctx.transaction.To
-
-	bin, err := hex.DecodeString(codeStr)
-	if err != nil {
-		panic(err)
+
+	toAddress := Address(to.Bytes20())
+	// Get the arguments from the memory.
+	args := ctx.memory.getCopy(inOffset.Uint64(), inSize.Uint64())
+
+	if interpreter.readOnly && !value.IsZero() {
+		ctx.fail(ErrWriteProtection)
+		return ctx.stack.data
+	}
+	if !value.IsZero() {
+		gas += CallStipend
 	}
-	vm := interpreter.vm
 
 	// pause the current context and pass execution to a new subcontext
-	_ = ctx
-	pc = 0
-
-	newCtx := &executionContext{
-		pc:      pc,
-		memory:  newMemory(),
-		stack:   newStack(),
-		storage: newStorage(),
-		code:    bin,
-		state:   ctx.state,
-		transaction: &Transaction{
-			From: fromAddress,
-		},
-	}
-
-	vm.Context = newCtx
-
-	_, _, success := vm.execute(bin)
-	switch success {
-	case true:
-		ctx.stack.push(*uint256.NewInt(1))
-	case false:
-		ctx.stack.push(*uint256.NewInt(0))
-	}
+	ret, returnGas, err := interpreter.vm.Call(ctx.contract.Address, toAddress, args, gas, &value)
 
 	// resume the parent context
-	memSize := len(vm.Context.memory.data)
-	ctx.memory.resize(uint64(memSize))
-	ctx.memory = vm.Context.memory
-	vm.Context = ctx
+	return callReturn(ctx, ret, returnGas, err, outOffset, outSize)
+}
+
+func callCodeOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
+	// Pop gas. The actual gas is in interpreter.callGasTemp.
+	_ = ctx.stack.pop()
+	gas := ctx.callGasTemp
+	to := ctx.stack.pop()
+	value := ctx.stack.pop()
+	inOffset := ctx.stack.pop()
+	inSize := ctx.stack.pop()
+	outOffset := ctx.stack.pop()
+	outSize := ctx.stack.pop()
+
+	toAddress := Address(to.Bytes20())
+	// Get the arguments from the memory.
+	args := ctx.memory.getCopy(inOffset.Uint64(), inSize.Uint64())
+
+	if !value.IsZero() {
+		gas += CallStipend
+	}
+
+	ret, returnGas, err := interpreter.vm.CallCode(ctx.contract.Address, toAddress, args, gas, &value)
+	return callReturn(ctx, ret, returnGas, err, outOffset, outSize)
+}
+
+func delegateCallOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
+	// Pop gas. The actual gas is in interpreter.callGasTemp.
+	_ = ctx.stack.pop()
+	gas := ctx.callGasTemp
+	to := ctx.stack.pop()
+	inOffset := ctx.stack.pop()
+	inSize := ctx.stack.pop()
+	outOffset := ctx.stack.pop()
+	outSize := ctx.stack.pop()
+
+	toAddress := Address(to.Bytes20())
+	// Get the arguments from the memory.
+	args := ctx.memory.getCopy(inOffset.Uint64(), inSize.Uint64())
+
+	ret, returnGas, err := interpreter.vm.DelegateCall(ctx.contract, toAddress, args, gas)
+	return callReturn(ctx, ret, returnGas, err, outOffset, outSize)
+}
+
+func staticCallOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
+	// Pop gas. The actual gas is in interpreter.callGasTemp.
+	_ = ctx.stack.pop()
+	gas := ctx.callGasTemp
+	to := ctx.stack.pop()
+	inOffset := ctx.stack.pop()
+	inSize := ctx.stack.pop()
+	outOffset := ctx.stack.pop()
+	outSize := ctx.stack.pop()
+
+	toAddress := Address(to.Bytes20())
+	// Get the arguments from the memory.
+	args := ctx.memory.getCopy(inOffset.Uint64(), inSize.Uint64())
+
+	ret, returnGas, err := interpreter.vm.StaticCall(ctx.contract.Address, toAddress, args, gas)
+	return callReturn(ctx, ret, returnGas, err, outOffset, outSize)
+}
+
+// callReturn resumes the calling context once a call returned: it pushes
+// whether the call succeeded, copies its output to the out region, gives
+// back the gas the callee didn't use and keeps the output for RETURNDATA*.
+func callReturn(ctx *executionContext, ret []byte, returnGas uint64, err error, outOffset, outSize uint256.Int) []uint256.Int {
+	if err != nil {
+		ctx.stack.push(*uint256.NewInt(0))
+	} else {
+		ctx.stack.push(*uint256.NewInt(1))
+	}
+	if err == nil || err == ErrExecutionReverted {
+		ctx.memory.set(outOffset.Uint64(), outSize.Uint64(), ret)
+	}
+	ctx.contract.Gas += returnGas
+	ctx.callReturnData = ret
 	return ctx.stack.data
 }
 
 func createOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
+	if interpreter.readOnly {
+		ctx.fail(ErrWriteProtection)
+		return ctx.stack.data
+	}
 	value := ctx.stack.pop()
 	offset := ctx.stack.pop()
 	size := ctx.stack.pop()
 
-	senderAddress := ctx.transaction.To
+	input := ctx.memory.getCopy(offset.Uint64(), size.Uint64())
+	gas := ctx.contract.Gas
+	// all but one 64th of the remaining gas goes to the new contract
+	gas -= gas / 64
+	ctx.useGas(gas)
 
-	rlpA, err := rlp.EncodeToBytes([]string{senderAddress, "0x0"})
+	res, address, returnGas, err := interpreter.vm.Create(ctx.contract.Address, input, gas, &value)
 	if err != nil {
-		panic(err)
+		ctx.stack.push(*new(uint256.Int))
+	} else {
+		ctx.stack.push(*address.Uint256())
 	}
+	ctx.contract.Gas += returnGas
 
-	bin := ctx.memory.get(offset.Uint64(), size.Uint64())
-
-	addr, err := uint256.FromHex(senderAddress)
-	if err != nil {
-		panic(err)
+	if err == ErrExecutionReverted {
+		ctx.callReturnData = res
+	} else {
+		ctx.callReturnData = nil
 	}
+	return ctx.stack.data
+}
 
-	if len(bin) == 0 {
-		ctx.state = map[string]interface{}{
-			senderAddress: map[string]interface{}{
-				"balance": strconv.FormatUint(value.Uint64(), 10),
-			},
-		}
-		ctx.stack.push(*addr)
+func create2Op(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
+	if interpreter.readOnly {
+		ctx.fail(ErrWriteProtection)
 		return ctx.stack.data
 	}
+	endowment := ctx.stack.pop()
+	offset := ctx.stack.pop()
+	size := ctx.stack.pop()
+	salt := ctx.stack.pop()
 
-	address := crypto.Keccak256(rlpA)[12:]
-	newAddressStr := hex.EncodeToString(address)
+	input := ctx.memory.getCopy(offset.Uint64(), size.Uint64())
+	gas := ctx.contract.Gas
+	// all but one 64th of the remaining gas goes to the new contract
+	gas -= gas / 64
+	ctx.useGas(gas)
 
-	newTx := &Transaction{
-		From:  senderAddress,
-		To:    newAddressStr,
-		Value: value.String(),
+	res, address, returnGas, err := interpreter.vm.Create2(ctx.contract.Address, input, gas, &endowment, &salt)
+	if err != nil {
+		ctx.stack.push(*new(uint256.Int))
+	} else {
+		ctx.stack.push(*address.Uint256())
 	}
+	ctx.contract.Gas += returnGas
 
-	vm := interpreter.vm
-
-	newCtx := &executionContext{
-		pc:          0,
-		memory:      newMemory(),
-		stack:       newStack(),
-		storage:     newStorage(),
-		code:        bin,
-		state:       ctx.state,
-		transaction: newTx,
+	if err == ErrExecutionReverted {
+		ctx.callReturnData = res
+	} else {
+		ctx.callReturnData = nil
 	}
+	return ctx.stack.data
+}
 
-	vm.Context = newCtx
-
-	_, returnValue, _ := vm.execute(bin)
-	returnUint256, err := uint256.FromHex(fmt.Sprintf("%v%v", "0x", returnValue))
+// createAddress creates an ethereum address given the bytes and the nonce
+func createAddress(b Address, nonce uint64) Address {
+	data, err := rlp.EncodeToBytes([]interface{}{b, nonce})
 	if err != nil {
 		panic(err)
 	}
+	return BytesToAddress(crypto.Keccak256(data)[12:])
+}
 
-	ctx.stack.push(*addr)
-	ctx.memory.set(offset.Uint64(), size.Uint64(), returnUint256.Bytes())
-	ctx.state = map[string]interface{}{
-		senderAddress: map[string]interface{}{
-			"code": map[string]interface{}{
-				"bin": returnValue,
-			},
-		},
+// create2Address creates an ethereum address given the address bytes, the
+// salt and the hash of the init code (EIP-1014).
+func create2Address(b Address, salt [32]byte, inithash []byte) Address {
+	return BytesToAddress(crypto.Keccak256([]byte{0xff}, b[:], salt[:], inithash)[12:])
+}
+
+// selfdestructOp sends the balance of the contract to the beneficiary. Since
+// EIP-6780 the contract is only deleted, at the end of the transaction, when
+// the transaction created it; only then is the balance it sends to itself
+// burnt.
+func selfdestructOp(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
+	if interpreter.readOnly {
+		ctx.fail(ErrWriteProtection)
+		return ctx.stack.data
+	}
+	beneficiary := ctx.stack.pop()
+	addr := Address(beneficiary.Bytes20())
+	balance := ctx.state.GetBalance(ctx.contract.Address)
+	ctx.state.SubBalance(ctx.contract.Address, balance)
+	ctx.state.AddBalance(addr, balance)
+	ctx.state.Suicide6780(ctx.contract.Address)
+	if tracer := interpreter.vm.Config.Tracer; tracer != nil {
+		tracer.CaptureEnter(SELFDESTRUCT, ctx.contract.Address, addr, []byte{}, 0, balance)
+		tracer.CaptureExit([]byte{}, 0, nil)
 	}
-	vm.Context = ctx
-	fmt.Println(ctx.stack.data)
+	ctx.halt = true
 	return ctx.stack.data
 }
+
+func makeLog(size int) executionFunc {
+	return func(pc uint64, ctx *executionContext, interpreter *Interpreter) []uint256.Int {
+		if interpreter.readOnly {
+			ctx.fail(ErrWriteProtection)
+			return ctx.stack.data
+		}
+		topics := make([]Hash, size)
+		mStart, mSize := ctx.stack.pop(), ctx.stack.pop()
+		for i := 0; i < size; i++ {
+			topic := ctx.stack.pop()
+			topics[i] = topic.Bytes32()
+		}
+
+		data := ctx.memory.get(mStart.Uint64(), mSize.Uint64())
+		ctx.state.AddLog(&Log{
+			Address: ctx.contract.Address,
+			Topics:  topics,
+			Data:    append([]byte(nil), data...),
+		})
+
+		return ctx.stack.data
+	}
+}
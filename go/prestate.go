@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+
+	"github.com/holiman/uint256"
+)
+
+// PrestateAccount is an account recorded by the PrestateTracer, in the JSON
+// shape of geth's prestateTracer. Only the storage slots that were accessed
+// are included.
+type PrestateAccount struct {
+	Balance string        `json:"balance,omitempty"`
+	Nonce   uint64        `json:"nonce,omitempty"`
+	Code    string        `json:"code,omitempty"`
+	Storage map[Hash]Hash `json:"storage,omitempty"`
+}
+
+// StateDiff is what a transaction changed: the changed accounts before the
+// transaction, and the fields that changed after it. Accounts that were
+// created are missing from Pre, accounts that were deleted from Post.
+type StateDiff struct {
+	Pre  map[string]*PrestateAccount `json:"pre"`
+	Post map[string]*PrestateAccount `json:"post"`
+}
+
+// prestate is the state of an account at the start of the transaction.
+type prestate struct {
+	exists  bool
+	balance *uint256.Int
+	nonce   uint64
+	code    []byte
+	storage map[Hash]Hash
+}
+
+func (p *prestate) account() *PrestateAccount {
+	acc := &PrestateAccount{Balance: p.balance.Hex(), Nonce: p.nonce}
+	if len(p.code) > 0 {
+		acc.Code = "0x" + hex.EncodeToString(p.code)
+	}
+	if len(p.storage) > 0 {
+		acc.Storage = p.storage
+	}
+	return acc
+}
+
+// PrestateTracer is a Tracer recording the accounts and storage slots a
+// transaction accesses, with their values before the transaction. In diff
+// mode it records what the transaction changed instead, as a StateDiff.
+// The result is written to w as JSON once the transaction is done.
+type PrestateTracer struct {
+	w        io.Writer
+	diffMode bool
+
+	state  *StateDB
+	pre    map[Address]*prestate
+	result interface{}
+}
+
+// NewPrestateTracer returns a PrestateTracer writing the result of every
+// transaction to w, or not writing it when w is nil.
+func NewPrestateTracer(w io.Writer, diffMode bool) *PrestateTracer {
+	return &PrestateTracer{w: w, diffMode: diffMode}
+}
+
+// Result returns the result of the last transaction: the accessed accounts
+// keyed by their address, or a *StateDiff in diff mode.
+func (t *PrestateTracer) Result() interface{} {
+	return t.result
+}
+
+// lookupAccount records the account at addr, if it isn't yet. The values are
+// read from the start of the transaction, so the account can be looked up
+// after it was modified.
+func (t *PrestateTracer) lookupAccount(addr Address) *prestate {
+	if p, ok := t.pre[addr]; ok {
+		return p
+	}
+	p := &prestate{balance: new(uint256.Int), storage: make(map[Hash]Hash)}
+	if obj := t.state.originAccount(addr); obj != nil {
+		p.exists, p.balance, p.nonce, p.code = true, obj.balance, obj.nonce, obj.code
+	}
+	t.pre[addr] = p
+	return p
+}
+
+// lookupStorage records the slot of the account at addr, if it isn't yet.
+func (t *PrestateTracer) lookupStorage(addr Address, key Hash) {
+	p := t.lookupAccount(addr)
+	if _, ok := p.storage[key]; !ok {
+		p.storage[key] = t.state.originState(addr, key)
+	}
+}
+
+func (t *PrestateTracer) CaptureTxStart(gasLimit uint64) {
+	t.state, t.pre, t.result = nil, make(map[Address]*prestate), nil
+}
+
+func (t *PrestateTracer) CaptureTxEnd(restGas uint64) {
+	if t.diffMode {
+		t.result = t.diff()
+	} else {
+		accounts := make(map[string]*PrestateAccount, len(t.pre))
+		for addr, p := range t.pre {
+			accounts[hexAddress(addr)] = p.account()
+		}
+		t.result = accounts
+	}
+	if t.w == nil {
+		return
+	}
+	enc := json.NewEncoder(t.w)
+	enc.SetIndent("", "  ")
+	enc.Encode(t.result)
+}
+
+// diff compares the recorded accounts to the state after the transaction.
+// Accounts and slots that didn't change are left out, as are slots whose
+// value is zero.
+func (t *PrestateTracer) diff() *StateDiff {
+	diff := &StateDiff{Pre: make(map[string]*PrestateAccount), Post: make(map[string]*PrestateAccount)}
+	for addr, p := range t.pre {
+		if !t.state.Exist(addr) {
+			if p.exists {
+				diff.Pre[hexAddress(addr)] = p.account()
+			}
+			continue
+		}
+		var (
+			post     = &PrestateAccount{Storage: make(map[Hash]Hash)}
+			modified bool
+		)
+		if balance := t.state.GetBalance(addr); !balance.Eq(p.balance) {
+			post.Balance, modified = balance.Hex(), true
+		}
+		if nonce := t.state.GetNonce(addr); nonce != p.nonce {
+			post.Nonce, modified = nonce, true
+		}
+		if code := t.state.GetCode(addr); !bytes.Equal(code, p.code) {
+			post.Code, modified = "0x"+hex.EncodeToString(code), true
+		}
+		storage := make(map[Hash]Hash)
+		for key, value := range p.storage {
+			current := t.state.GetState(addr, key)
+			if current == value {
+				continue
+			}
+			modified = true
+			if value != (Hash{}) {
+				storage[key] = value
+			}
+			if current != (Hash{}) {
+				post.Storage[key] = current
+			}
+		}
+		if !modified {
+			continue
+		}
+		if len(post.Storage) == 0 {
+			post.Storage = nil
+		}
+		diff.Post[hexAddress(addr)] = post
+		if p.exists {
+			pre := p.account()
+			pre.Storage = nil
+			if len(storage) > 0 {
+				pre.Storage = storage
+			}
+			diff.Pre[hexAddress(addr)] = pre
+		}
+	}
+	return diff
+}
+
+func (t *PrestateTracer) CaptureStart(vm *VM, from Address, to Address, create bool, input []byte, gas uint64, value *uint256.Int) {
+	t.state = vm.StateDB
+	t.lookupAccount(from)
+	t.lookupAccount(to)
+	t.lookupAccount(vm.Block.Coinbase)
+}
+
+func (t *PrestateTracer) CaptureState(pc uint64, op OpCode, gas, cost uint64, scope *executionContext, rData []byte, depth int, err error) {
+	if scope.stack.len() == 0 {
+		return
+	}
+	// The accounts called and created are looked up as their frame is
+	// entered. The beneficiary of SELFDESTRUCT is looked up here too, as the
+	// opcode can fail once executed, in a static call.
+	switch op {
+	case SLOAD, SSTORE:
+		t.lookupStorage(scope.contract.Address, scope.stack.peek().Bytes32())
+	case BALANCE, EXTCODESIZE, EXTCODECOPY, EXTCODEHASH, SELFDESTRUCT:
+		t.lookupAccount(scope.stack.peek().Bytes20())
+	}
+}
+
+func (t *PrestateTracer) CaptureFault(pc uint64, op OpCode, gas, cost uint64, scope *executionContext, depth int, err error) {
+}
+
+func (t *PrestateTracer) CaptureEnd(output []byte, gasUsed uint64, err error) {}
+
+func (t *PrestateTracer) CaptureEnter(typ OpCode, from Address, to Address, input []byte, gas uint64, value *uint256.Int) {
+	t.lookupAccount(to)
+}
+
+func (t *PrestateTracer) CaptureExit(output []byte, gasUsed uint64, err error) {}
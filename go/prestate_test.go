@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+const (
+	// prestateStore writes slot 1 and reads the empty slot 0
+	prestateStore = "PUSH1 7 PUSH1 1 SSTORE PUSH1 0 SLOAD POP"
+	// prestateCreate creates a contract sending it 5 wei, which it sends on
+	// to 0xbb as it self-destructs while being created
+	prestateCreate = "PUSH3 0x60bbff PUSH1 0 MSTORE PUSH1 3 PUSH1 29 PUSH1 5 CREATE"
+)
+
+// tracePrestate runs a transaction sending 100 wei to a contract with the
+// code asm, and returns the output of the PrestateTracer.
+func tracePrestate(t *testing.T, asm string, diffMode bool) string {
+	t.Helper()
+	state := newTestState(t, GenesisAlloc{
+		testSender:    {Balance: "1000000000", Nonce: "2"},
+		testRecipient: {Balance: "10", Code: code{Bin: hex.EncodeToString(assemble(t, asm))}, Storage: map[Hash]Hash{{31: 1}: {31: 3}}},
+	})
+	var out bytes.Buffer
+	tx := &Transaction{To: &testRecipient, From: testSender, Gas: "100000", GasPrice: "1", Value: "100", Nonce: "2"}
+	if _, err := ApplyTransaction(state, &Block{Coinbase: testCoinbase}, tx, Config{Tracer: NewPrestateTracer(&out, diffMode)}); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+func TestPrestateTracer(t *testing.T) {
+	tests := []struct {
+		name string
+		asm  string
+		want string
+	}{
+		// The slots read and written are recorded with their values before
+		// the transaction, and the accounts with their balance before it.
+		{"storage", prestateStore, `{
+  "0x1000000000000000000000000000000000000001": {
+    "balance": "0x3b9aca00",
+    "nonce": 2
+  },
+  "0x2000000000000000000000000000000000000002": {
+    "balance": "0xa",
+    "code": "0x600760015560005450",
+    "storage": {
+      "0x0000000000000000000000000000000000000000000000000000000000000000": "0x0000000000000000000000000000000000000000000000000000000000000000",
+      "0x0000000000000000000000000000000000000000000000000000000000000001": "0x0000000000000000000000000000000000000000000000000000000000000003"
+    }
+  },
+  "0xc014ba5e00000000000000000000000000000000": {
+    "balance": "0x0"
+  }
+}
+`},
+		// Accounts that don't exist yet are recorded as empty
+		{"create", prestateCreate, `{
+  "0x00000000000000000000000000000000000000bb": {
+    "balance": "0x0"
+  },
+  "0x092cac6db1de08ae9590c7528a5e050fea367552": {
+    "balance": "0x0"
+  },
+  "0x1000000000000000000000000000000000000001": {
+    "balance": "0x3b9aca00",
+    "nonce": 2
+  },
+  "0x2000000000000000000000000000000000000002": {
+    "balance": "0xa",
+    "code": "0x6260bbff6000526003601d6005f0"
+  },
+  "0xc014ba5e00000000000000000000000000000000": {
+    "balance": "0x0"
+  }
+}
+`},
+	}
+	for _, test := range tests {
+		if got := tracePrestate(t, test.asm, false); got != test.want {
+			t.Errorf("%v: got\n%v\nwant\n%v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestPrestateTracerDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		asm  string
+		want string
+	}{
+		// The sender pays the value and the gas, and its nonce goes up. The
+		// coinbase didn't exist before it got the fees.
+		{"storage", prestateStore, `{
+  "pre": {
+    "0x1000000000000000000000000000000000000001": {
+      "balance": "0x3b9aca00",
+      "nonce": 2
+    },
+    "0x2000000000000000000000000000000000000002": {
+      "balance": "0xa",
+      "code": "0x600760015560005450",
+      "storage": {
+        "0x0000000000000000000000000000000000000000000000000000000000000001": "0x0000000000000000000000000000000000000000000000000000000000000003"
+      }
+    }
+  },
+  "post": {
+    "0x1000000000000000000000000000000000000001": {
+      "balance": "0x3b9a5bcd",
+      "nonce": 3
+    },
+    "0x2000000000000000000000000000000000000002": {
+      "balance": "0x6e",
+      "storage": {
+        "0x0000000000000000000000000000000000000000000000000000000000000001": "0x0000000000000000000000000000000000000000000000000000000000000007"
+      }
+    },
+    "0xc014ba5e00000000000000000000000000000000": {
+      "balance": "0x6dcf"
+    }
+  }
+}
+`},
+		// The contract created and self-destructed in the transaction is
+		// neither before nor after it, its beneficiary only after it.
+		{"create and self-destruct", prestateCreate, `{
+  "pre": {
+    "0x1000000000000000000000000000000000000001": {
+      "balance": "0x3b9aca00",
+      "nonce": 2
+    },
+    "0x2000000000000000000000000000000000000002": {
+      "balance": "0xa",
+      "code": "0x6260bbff6000526003601d6005f0"
+    }
+  },
+  "post": {
+    "0x00000000000000000000000000000000000000bb": {
+      "balance": "0x5"
+    },
+    "0x1000000000000000000000000000000000000001": {
+      "balance": "0x3b997b22",
+      "nonce": 3
+    },
+    "0x2000000000000000000000000000000000000002": {
+      "balance": "0x69",
+      "nonce": 1
+    },
+    "0xc014ba5e00000000000000000000000000000000": {
+      "balance": "0x14e7a"
+    }
+  }
+}
+`},
+	}
+	for _, test := range tests {
+		if got := tracePrestate(t, test.asm, true); got != test.want {
+			t.Errorf("%v: got\n%v\nwant\n%v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/holiman/uint256"
+)
+
+// gasStat is how many times an opcode, or the opcode at a pc, executed and
+// the gas it used.
+type gasStat struct {
+	Count uint64
+	Gas   uint64
+}
+
+// codeLocation is a pc in the code of an account.
+type codeLocation struct {
+	Address Address
+	PC      uint64
+}
+
+func (l codeLocation) String() string {
+	return fmt.Sprintf("%v:%04x", hexAddress(l.Address), l.PC)
+}
+
+// profileFrame is a call frame being executed, as seen by the GasProfiler.
+type profileFrame struct {
+	address Address
+	// stack is the folded stack of the calls leading to the frame
+	stack string
+	// gas is the gas the frame started with
+	gas uint64
+	// executed is set once an opcode of the frame executed
+	executed bool
+
+	// The gas used by the last opcode executed is known once the next one
+	// starts, or once the frame ends. The gas used by the frames it called is
+	// not its own.
+	pending      bool
+	pc           uint64
+	op           OpCode
+	gasBefore    uint64
+	childGasUsed uint64
+}
+
+// GasProfiler is a Tracer adding up the gas used by opcode, by pc, and by
+// stack of call frames, over all the transactions it observes. The gas used
+// by a call or a creation doesn't include the gas used by the frame it runs,
+// which goes to the opcodes of that frame. Frames that run no opcodes, those
+// of precompiles and of accounts without code, leave their gas to the call.
+type GasProfiler struct {
+	ops    map[OpCode]*gasStat
+	pcs    map[codeLocation]*gasStat
+	stacks map[string]uint64
+
+	frames []*profileFrame
+}
+
+// NewGasProfiler returns an empty GasProfiler.
+func NewGasProfiler() *GasProfiler {
+	return &GasProfiler{
+		ops:    make(map[OpCode]*gasStat),
+		pcs:    make(map[codeLocation]*gasStat),
+		stacks: make(map[string]uint64),
+	}
+}
+
+// settle adds the gas used by the last opcode of frame, given the gas the
+// frame has left after it.
+func (p *GasProfiler) settle(frame *profileFrame, gasLeft uint64) {
+	if !frame.pending {
+		return
+	}
+	frame.pending = false
+	gas := frame.gasBefore - gasLeft - frame.childGasUsed
+	location := codeLocation{frame.address, frame.pc}
+
+	op, ok := p.ops[frame.op]
+	if !ok {
+		op = &gasStat{}
+		p.ops[frame.op] = op
+	}
+	op.Count++
+	op.Gas += gas
+	pc, ok := p.pcs[location]
+	if !ok {
+		pc = &gasStat{}
+		p.pcs[location] = pc
+	}
+	pc.Count++
+	pc.Gas += gas
+	p.stacks[frame.stack+location.String()+":"+frame.op.String()] += gas
+}
+
+func (p *GasProfiler) CaptureTxStart(gasLimit uint64) {}
+
+func (p *GasProfiler) CaptureTxEnd(restGas uint64) {}
+
+func (p *GasProfiler) CaptureStart(vm *VM, from Address, to Address, create bool, input []byte, gas uint64, value *uint256.Int) {
+	p.frames = []*profileFrame{{address: to, gas: gas}}
+}
+
+func (p *GasProfiler) CaptureState(pc uint64, op OpCode, gas, cost uint64, scope *executionContext, rData []byte, depth int, err error) {
+	// An opcode failing before it executes uses the gas left by the frame,
+	// which is settled as the frame ends
+	frame := p.frames[len(p.frames)-1]
+	p.settle(frame, gas)
+	frame.pending, frame.pc, frame.op, frame.gasBefore, frame.childGasUsed = true, pc, op, gas, 0
+	frame.executed = true
+}
+
+func (p *GasProfiler) CaptureFault(pc uint64, op OpCode, gas, cost uint64, scope *executionContext, depth int, err error) {
+}
+
+func (p *GasProfiler) CaptureEnd(output []byte, gasUsed uint64, err error) {
+	frame := p.frames[0]
+	p.settle(frame, frame.gas-gasUsed)
+	p.frames = nil
+}
+
+func (p *GasProfiler) CaptureEnter(typ OpCode, from Address, to Address, input []byte, gas uint64, value *uint256.Int) {
+	parent := p.frames[len(p.frames)-1]
+	stack := fmt.Sprintf("%v%v:%v;", parent.stack, codeLocation{parent.address, parent.pc}, parent.op)
+	p.frames = append(p.frames, &profileFrame{address: to, stack: stack, gas: gas})
+}
+
+func (p *GasProfiler) CaptureExit(output []byte, gasUsed uint64, err error) {
+	size := len(p.frames)
+	frame := p.frames[size-1]
+	p.settle(frame, frame.gas-gasUsed)
+	p.frames = p.frames[:size-1]
+	if frame.executed {
+		p.frames[size-2].childGasUsed += gasUsed
+	}
+}
+
+// profileHotSpots is the number of pcs listed by WriteSummary.
+const profileHotSpots = 20
+
+// WriteSummary writes the gas used by every opcode, and by the pcs that used
+// the most, in decreasing order of gas.
+func (p *GasProfiler) WriteSummary(w io.Writer) {
+	var total uint64
+	ops := make([]OpCode, 0, len(p.ops))
+	for op, stat := range p.ops {
+		ops = append(ops, op)
+		total += stat.Gas
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		a, b := p.ops[ops[i]], p.ops[ops[j]]
+		if a.Gas != b.Gas {
+			return a.Gas > b.Gas
+		}
+		return ops[i] < ops[j]
+	})
+	fmt.Fprintf(w, "Gas used by opcode, %d in total:\n", total)
+	fmt.Fprintf(w, "  %-16s %10s %14s %7s\n", "OPCODE", "COUNT", "GAS", "%")
+	for _, op := range ops {
+		stat := p.ops[op]
+		fmt.Fprintf(w, "  %-16v %10d %14d %7.2f\n", op, stat.Count, stat.Gas, percent(stat.Gas, total))
+	}
+
+	locations := make([]codeLocation, 0, len(p.pcs))
+	for location := range p.pcs {
+		locations = append(locations, location)
+	}
+	sort.Slice(locations, func(i, j int) bool {
+		a, b := p.pcs[locations[i]], p.pcs[locations[j]]
+		if a.Gas != b.Gas {
+			return a.Gas > b.Gas
+		}
+		return locations[i].String() < locations[j].String()
+	})
+	if len(locations) > profileHotSpots {
+		locations = locations[:profileHotSpots]
+	}
+	fmt.Fprintf(w, "\nPcs using the most gas:\n")
+	fmt.Fprintf(w, "  %-47s %10s %14s %7s\n", "ADDRESS:PC", "COUNT", "GAS", "%")
+	for _, location := range locations {
+		stat := p.pcs[location]
+		fmt.Fprintf(w, "  %-47v %10d %14d %7.2f\n", location, stat.Count, stat.Gas, percent(stat.Gas, total))
+	}
+}
+
+func percent(gas, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(gas) * 100 / float64(total)
+}
+
+// WriteFolded writes the gas used by stack of call frames in the folded
+// format of flamegraph tools: one stack per line, its frames separated by
+// semicolons, followed by the gas. Every frame is the address and pc of the
+// opcode, with its name. Stacks that used no gas are left out.
+func (p *GasProfiler) WriteFolded(w io.Writer) error {
+	stacks := make([]string, 0, len(p.stacks))
+	for stack, gas := range p.stacks {
+		if gas > 0 {
+			stacks = append(stacks, stack)
+		}
+	}
+	sort.Strings(stacks)
+	for _, stack := range stacks {
+		if _, err := fmt.Fprintf(w, "%v %d\n", stack, p.stacks[stack]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
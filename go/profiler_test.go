@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestGasProfilerTotal checks that the gas the profile adds up to is the gas
+// used by the transaction beyond its intrinsic gas, with frames that do and
+// don't run code.
+func TestGasProfilerTotal(t *testing.T) {
+	callee := HexToAddress("0x3000000000000000000000000000000000000003")
+	tests := []struct {
+		name string
+		asm  string
+		// callee is the code of the account at 0x3000…03
+		callee string
+	}{
+		{name: "identity precompile", asm: "PUSH1 0 PUSH1 0 PUSH1 32 PUSH1 0 PUSH1 0 PUSH1 4 GAS CALL"},
+		{name: "failing precompile", asm: "PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 1 PUSH1 100 CALL"},
+		{name: "account without code", asm: "PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 1 PUSH1 0xaa GAS CALL"},
+		{name: "code", asm: "PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 0 PUSH20 " + callee.Hex() + " GAS CALL", callee: "PUSH1 1 PUSH1 0 MSTORE"},
+		{name: "nested precompile", asm: "PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 0 PUSH20 " + callee.Hex() + " GAS CALL", callee: "PUSH1 0 PUSH1 0 PUSH1 32 PUSH1 0 PUSH1 2 GAS STATICCALL"},
+		{name: "create", asm: "PUSH5 0x6001600af3 PUSH1 0 MSTORE PUSH1 5 PUSH1 27 PUSH1 0 CREATE"},
+		{name: "empty create", asm: "PUSH1 0 PUSH1 0 PUSH1 0 CREATE"},
+		{name: "self-destruct", asm: "PUSH1 0xbb SELFDESTRUCT"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			state := newTestState(t, GenesisAlloc{
+				testSender:    {Balance: "1000000000"},
+				testRecipient: {Balance: "10", Code: code{Bin: hex.EncodeToString(assemble(t, test.asm))}},
+				callee:        {Code: code{Bin: hex.EncodeToString(assemble(t, test.callee))}},
+			})
+			profiler := NewGasProfiler()
+			tx := &Transaction{To: &testRecipient, From: testSender, Gas: "100000", GasPrice: "1"}
+			receipt, err := ApplyTransaction(state, &Block{}, tx, Config{Tracer: profiler})
+			if err != nil {
+				t.Fatal(err)
+			}
+			intrinsic, err := IntrinsicGas(nil, nil, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := receipt.GasUsed - intrinsic
+
+			var total uint64
+			for _, stat := range profiler.ops {
+				total += stat.Gas
+			}
+			if total != want {
+				t.Errorf("got %d gas by opcode, want %d", total, want)
+			}
+			var folded bytes.Buffer
+			if err := profiler.WriteFolded(&folded); err != nil {
+				t.Fatal(err)
+			}
+			total = 0
+			for _, line := range strings.Split(strings.TrimSpace(folded.String()), "\n") {
+				gas, err := strconv.ParseUint(line[strings.LastIndexByte(line, ' ')+1:], 10, 64)
+				if err != nil {
+					t.Fatalf("invalid line %q: %v", line, err)
+				}
+				total += gas
+			}
+			if total != want {
+				t.Errorf("got %d gas in the folded stacks, want %d:\n%v", total, want, folded.String())
+			}
+		})
+	}
+}
+
+func TestGasProfilerCodelessFrame(t *testing.T) {
+	// The gas of the identity precompile stays with the CALL
+	state := newTestState(t, GenesisAlloc{
+		testRecipient: {Code: code{Bin: hex.EncodeToString(assemble(t, "PUSH1 0 PUSH1 0 PUSH1 32 PUSH1 0 PUSH1 0 PUSH1 4 GAS CALL"))}},
+	})
+	profiler := NewGasProfiler()
+	res, err := evm(state.GetCode(testRecipient), &Transaction{To: &testRecipient, From: testSender}, state, &Block{}, Config{Tracer: profiler})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Six pushes and GAS, then the warm CALL expanding the memory to a word
+	// and the precompile's 15 + 3 per word
+	if want := uint64(6*3 + 2 + 100 + 3 + 18); res.GasUsed != want {
+		t.Fatalf("used %d gas, want %d", res.GasUsed, want)
+	}
+	if stat := profiler.ops[CALL]; stat == nil || stat.Gas != 121 {
+		t.Errorf("got CALL stats %+v, want 121 gas", stat)
+	}
+}
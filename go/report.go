@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/holiman/uint256"
+	"gopkg.in/yaml.v3"
+)
+
+// reportFunc writes a report of the results of running the test suite with
+// the given name.
+type reportFunc func(w io.Writer, suite string, results []*TestResult) error
+
+// reportFormats are the formats a test run can be reported in.
+var reportFormats = map[string]reportFunc{
+	"json":  writeJSONReport,
+	"junit": writeJUnitReport,
+	"tap":   writeTAPReport,
+}
+
+// failureMessage returns the description of why a test failed, the first line
+// of each of its failures.
+func failureMessage(result *TestResult) string {
+	var lines []string
+	if result.Err != nil {
+		lines = append(lines, strings.SplitN(result.Err.Error(), "\n", 2)[0])
+	}
+	for _, failure := range result.Failures {
+		lines = append(lines, strings.SplitN(failure, "\n", 2)[0])
+	}
+	return strings.Join(lines, ", ")
+}
+
+type jsonOutcome struct {
+	Stack   []string `json:"stack"`
+	Return  string   `json:"return"`
+	Success *bool    `json:"success,omitempty"`
+	GasUsed string   `json:"gasUsed,omitempty"`
+	Error   *string  `json:"error,omitempty"`
+	// RevertReason is only set in the actual outcome
+	RevertReason string `json:"revertReason,omitempty"`
+}
+
+type jsonTestResult struct {
+	Name     string       `json:"name"`
+	Passed   bool         `json:"passed"`
+	Duration float64      `json:"duration"` // seconds
+	Expected jsonOutcome  `json:"expected"`
+	Actual   *jsonOutcome `json:"actual,omitempty"`
+	Failures []string     `json:"failures,omitempty"`
+	Error    string       `json:"error,omitempty"`
+}
+
+type jsonReport struct {
+	Suite    string           `json:"suite"`
+	Tests    int              `json:"tests"`
+	Passed   int              `json:"passed"`
+	Failed   int              `json:"failed"`
+	Duration float64          `json:"duration"` // seconds
+	Results  []jsonTestResult `json:"results"`
+}
+
+// writeJSONReport writes the results as a JSON document, with the expected
+// outcome of every case and the actual outcome of those that ran.
+func writeJSONReport(w io.Writer, suite string, results []*TestResult) error {
+	report := jsonReport{Suite: suite, Tests: len(results), Results: []jsonTestResult{}}
+	var total time.Duration
+	for _, result := range results {
+		r := jsonTestResult{
+			Name:     result.Test.Name,
+			Passed:   result.Passed(),
+			Duration: result.Duration.Seconds(),
+			Expected: jsonOutcome{
+				Stack:   jsonStack(result.ExpectedStack),
+				Return:  result.Test.Expect.Return,
+				Success: result.Test.Expect.Success,
+				GasUsed: result.Test.Expect.GasUsed,
+				Error:   result.Test.Expect.Error,
+			},
+			Failures: result.Failures,
+		}
+		// An evm that didn't run has no outcome
+		if result.Err == nil {
+			success := result.Success
+			r.Actual = &jsonOutcome{
+				Stack:        jsonStack(result.Stack),
+				Return:       result.Return,
+				Success:      &success,
+				GasUsed:      fmt.Sprint(result.GasUsed),
+				RevertReason: result.RevertReason,
+			}
+			if result.ExecErr != nil {
+				execErr := result.ExecErr.Error()
+				r.Actual.Error = &execErr
+			}
+		} else {
+			r.Error = result.Err.Error()
+		}
+		if r.Passed {
+			report.Passed++
+		} else {
+			report.Failed++
+		}
+		total += result.Duration
+		report.Results = append(report.Results, r)
+	}
+	report.Duration = total.Seconds()
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(report)
+}
+
+// jsonStack returns the items of stack as strings, an empty stack being an
+// empty list rather than null.
+func jsonStack(stack []uint256.Int) []string {
+	return append([]string{}, toStrings(stack)...)
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Error     *junitFailure `xml:"error,omitempty"`
+}
+
+type junitTestSuite struct {
+	XMLName   xml.Name        `xml:"testsuite"`
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+// writeJUnitReport writes the results as JUnit XML. Mismatches with the
+// expectation are failures, cases that could not run are errors.
+func writeJUnitReport(w io.Writer, suite string, results []*TestResult) error {
+	ts := junitTestSuite{
+		Name:      suite,
+		Tests:     len(results),
+		Timestamp: time.Now().UTC().Format("2006-01-02T15:04:05"),
+	}
+	var total time.Duration
+	for _, result := range results {
+		tc := junitTestCase{
+			Name:      result.Test.Name,
+			Classname: suite,
+			Time:      junitTime(result.Duration),
+		}
+		if result.Err != nil {
+			ts.Errors++
+			tc.Error = &junitFailure{Message: failureMessage(result), Text: result.Err.Error()}
+		} else if len(result.Failures) > 0 {
+			ts.Failures++
+			tc.Failure = &junitFailure{Message: failureMessage(result), Type: "mismatch", Text: strings.Join(result.Failures, "\n\n")}
+		}
+		total += result.Duration
+		ts.TestCases = append(ts.TestCases, tc)
+	}
+	ts.Time = junitTime(total)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(junitTestSuites{Suites: []junitTestSuite{ts}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// junitTime formats d in seconds, the way JUnit reports durations.
+func junitTime(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// writeTAPReport writes the results in the Test Anything Protocol, version
+// 13. The failures of a case are reported in a YAML diagnostic block.
+func writeTAPReport(w io.Writer, suite string, results []*TestResult) error {
+	if _, err := fmt.Fprintf(w, "TAP version 13\n# %s\n1..%d\n", suite, len(results)); err != nil {
+		return err
+	}
+	for i, result := range results {
+		// A # in the description would start a directive
+		name := strings.ReplaceAll(result.Test.Name, "#", `\#`)
+		if result.Passed() {
+			if _, err := fmt.Fprintf(w, "ok %d - %s\n", i+1, name); err != nil {
+				return err
+			}
+			continue
+		}
+		diagnostic := map[string]interface{}{
+			"message":  failureMessage(result),
+			"duration": result.Duration.Seconds(),
+		}
+		if result.Err != nil {
+			diagnostic["error"] = result.Err.Error()
+		}
+		if len(result.Failures) > 0 {
+			diagnostic["failures"] = result.Failures
+		}
+		var block strings.Builder
+		enc := yaml.NewEncoder(&block)
+		enc.SetIndent(2)
+		if err := enc.Encode(diagnostic); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(w, "not ok %d - %s\n  ---\n", i+1, name); err != nil {
+			return err
+		}
+		for _, line := range strings.Split(strings.TrimSuffix(block.String(), "\n"), "\n") {
+			if _, err := fmt.Fprintf(w, "  %s\n", line); err != nil {
+				return err
+			}
+		}
+		if _, err := io.WriteString(w, "  ...\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
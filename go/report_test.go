@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/holiman/uint256"
+)
+
+// reportResults returns the results the reports are tested with: a case
+// that passed, one with mismatches and a name that needs escaping, and one
+// that could not run.
+func reportResults() []*TestResult {
+	success, reverted := true, "execution reverted"
+	return []*TestResult{
+		{
+			Test:          &TestCase{Name: "ADD", Expect: expect{Stack: []string{"0x3"}}},
+			Duration:      1500 * time.Microsecond,
+			ExpectedStack: []uint256.Int{*uint256.NewInt(3)},
+			Stack:         []uint256.Int{*uint256.NewInt(3)},
+			Success:       true,
+			GasUsed:       9,
+		},
+		{
+			Test: &TestCase{
+				Name:   `REVERT <"a"> & #1`,
+				Expect: expect{Stack: []string{"0x4"}, Return: "01", Success: &success, GasUsed: "18"},
+			},
+			Duration:      2 * time.Millisecond,
+			ExpectedStack: []uint256.Int{*uint256.NewInt(4)},
+			Stack:         []uint256.Int{*uint256.NewInt(3)},
+			Return:        "02",
+			GasUsed:       18,
+			ExecErr:       errors.New(reverted),
+			RevertReason:  `Error("<nope> & \"no\"")`,
+			Failures: []string{
+				"Stack mismatch\nExpected: [0x4]\nGot: [0x3]",
+				"Return data mismatch\nExpected: 01\nGot: 02",
+				"Success mismatch\nExpected: true\nGot: false",
+			},
+		},
+		{
+			Test:     &TestCase{Name: "odd # code", Expect: expect{Error: &reverted}},
+			Duration: 250 * time.Microsecond,
+			Err:      errors.New("invalid code: encoding/hex: odd length hex string"),
+		},
+	}
+}
+
+// junitTimestamp matches the timestamp of a JUnit report, which is the time
+// the report was written.
+var junitTimestamp = regexp.MustCompile(`timestamp="[^"]*"`)
+
+// TestReports compares the reports of reportResults in every format to the
+// files in testdata/reports.
+func TestReports(t *testing.T) {
+	tests := []struct {
+		format string
+		file   string
+	}{
+		{"json", "results.json"},
+		{"junit", "results.xml"},
+		{"tap", "results.tap"},
+	}
+	for _, test := range tests {
+		var buf bytes.Buffer
+		if err := reportFormats[test.format](&buf, "cases.yaml", reportResults()); err != nil {
+			t.Fatalf("%v: %v", test.format, err)
+		}
+		got := junitTimestamp.ReplaceAll(buf.Bytes(), []byte(`timestamp=""`))
+		want, err := os.ReadFile(filepath.Join("testdata", "reports", test.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%v: got\n%s\nwant\n%s", test.format, got, want)
+		}
+	}
+}
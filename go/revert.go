@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/holiman/uint256"
+)
+
+// panicSelector is the selector of Panic(uint256), the error solidity reverts
+// with when an assertion of the compiled code fails.
+var panicSelector = crypto.Keccak256([]byte("Panic(uint256)"))[:4]
+
+// panicReasons are the assertions that failed, by panic code.
+var panicReasons = map[uint64]string{
+	0x00: "generic panic",
+	0x01: "assert(false)",
+	0x11: "arithmetic underflow or overflow",
+	0x12: "division or modulo by zero",
+	0x21: "enum overflow",
+	0x22: "invalid encoded storage byte array accessed",
+	0x31: "pop() on an empty array",
+	0x32: "out-of-bounds access of an array or bytesN",
+	0x41: "out of memory",
+	0x51: "uninitialized function",
+}
+
+var errInvalidRevert = errors.New("invalid revert data")
+
+// UnpackPanic returns the code of revert data that is a Panic(uint256).
+func UnpackPanic(data []byte) (*uint256.Int, error) {
+	if len(data) != 4+32 || !bytes.Equal(data[:4], panicSelector) {
+		return nil, errInvalidRevert
+	}
+	return new(uint256.Int).SetBytes(data[4:]), nil
+}
+
+// LoadErrorsABI reads the JSON ABI at path, declaring the custom errors
+// revert data is decoded with.
+func LoadErrorsABI(path string) (*abi.ABI, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	parsed, err := abi.JSON(file)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ABI: %v", err)
+	}
+	return &parsed, nil
+}
+
+// DecodeRevert describes revert data: the reason of an Error(string), the
+// code and assertion of a Panic(uint256), or a custom error of errs, which
+// can be nil, with its arguments. It is false for data that is none of them.
+func DecodeRevert(data []byte, errs *abi.ABI) (string, bool) {
+	if reason, err := abi.UnpackRevert(data); err == nil {
+		return reason, true
+	}
+	if code, err := UnpackPanic(data); err == nil {
+		reason, ok := panicReasons[code.Uint64()]
+		if !code.IsUint64() || !ok {
+			reason = "unknown panic code"
+		}
+		return fmt.Sprintf("Panic(%v): %v", code.Hex(), reason), true
+	}
+	if errs == nil || len(data) < 4 {
+		return "", false
+	}
+	// Sort the errors to decode with the same one when selectors collide
+	names := make([]string, 0, len(errs.Errors))
+	for name := range errs.Errors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		abiErr := errs.Errors[name]
+		if !bytes.Equal(data[:4], abiErr.ID[:4]) {
+			continue
+		}
+		values, err := abiErr.Inputs.Unpack(data[4:])
+		if err != nil {
+			continue
+		}
+		args := make([]string, len(values))
+		for i, value := range values {
+			args[i] = fmt.Sprintf("%v: %v", abiErr.Inputs[i].Name, formatABIValue(value))
+		}
+		return fmt.Sprintf("%v(%v)", abiErr.Name, strings.Join(args, ", ")), true
+	}
+	return "", false
+}
+
+// revertReason describes the output of an execution that ended with err, if
+// it reverted with revert data DecodeRevert knows.
+func revertReason(err error, output []byte, errs *abi.ABI) string {
+	if err != ErrExecutionReverted {
+		return ""
+	}
+	reason, _ := DecodeRevert(output, errs)
+	return reason
+}
+
+// formatABIValue formats a decoded ABI value, bytes as hex and strings
+// quoted.
+func formatABIValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return fmt.Sprintf("%q", v)
+	case []byte:
+		return "0x" + hex.EncodeToString(v)
+	}
+	// Fixed size bytes decode to byte arrays
+	rv := reflect.ValueOf(value)
+	if rv.Kind() == reflect.Array && rv.Type().Elem().Kind() == reflect.Uint8 {
+		b := make([]byte, rv.Len())
+		reflect.Copy(reflect.ValueOf(b), rv)
+		return "0x" + hex.EncodeToString(b)
+	}
+	return fmt.Sprint(value)
+}
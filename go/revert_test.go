@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/hex"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// revertABI declares the errors revert data is packed with in the tests,
+// Error and Panic as solidity reverts with and two custom errors.
+const revertABI = `[
+	{"type": "error", "name": "Error", "inputs": [{"name": "reason", "type": "string"}]},
+	{"type": "error", "name": "Panic", "inputs": [{"name": "code", "type": "uint256"}]},
+	{"type": "error", "name": "InsufficientBalance", "inputs": [{"name": "available", "type": "uint256"}, {"name": "required", "type": "uint256"}]},
+	{"type": "error", "name": "Unauthorized", "inputs": [{"name": "caller", "type": "address"}, {"name": "role", "type": "bytes32"}]}
+]`
+
+func TestDecodeRevert(t *testing.T) {
+	errs, err := abi.JSON(strings.NewReader(revertABI))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// pack returns the revert data of the error name of revertABI
+	pack := func(name string, args ...interface{}) []byte {
+		abiErr := errs.Errors[name]
+		packed, err := abiErr.Inputs.Pack(args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return append(append([]byte{}, abiErr.ID[:4]...), packed...)
+	}
+	var role [32]byte
+	role[31] = 0x01
+	insufficient := pack("InsufficientBalance", big.NewInt(1), big.NewInt(2))
+
+	tests := []struct {
+		name string
+		data []byte
+		// errs are the custom errors decoded, nil for none
+		errs   *abi.ABI
+		reason string
+		ok     bool
+	}{
+		{name: "error", data: pack("Error", "not enough"), reason: "not enough", ok: true},
+		{name: "empty error", data: pack("Error", ""), reason: "", ok: true},
+		{name: "panic", data: pack("Panic", big.NewInt(0x11)), reason: "Panic(0x11): arithmetic underflow or overflow", ok: true},
+		{name: "panic pop", data: pack("Panic", big.NewInt(0x31)), reason: "Panic(0x31): pop() on an empty array", ok: true},
+		{name: "panic out of bounds", data: pack("Panic", big.NewInt(0x32)), reason: "Panic(0x32): out-of-bounds access of an array or bytesN", ok: true},
+		{name: "unknown panic code", data: pack("Panic", big.NewInt(0x99)), reason: "Panic(0x99): unknown panic code", ok: true},
+		{name: "panic code beyond uint64", data: pack("Panic", new(big.Int).Lsh(big.NewInt(1), 64)), reason: "Panic(0x10000000000000000): unknown panic code", ok: true},
+		{name: "custom error", data: insufficient, errs: &errs, reason: "InsufficientBalance(available: 1, required: 2)", ok: true},
+		{name: "custom error with bytes", data: pack("Unauthorized", common.HexToAddress("0xaa"), role), errs: &errs,
+			reason: "Unauthorized(caller: 0x00000000000000000000000000000000000000aa, role: 0x0000000000000000000000000000000000000000000000000000000000000001)", ok: true},
+		// Custom errors are only decoded with their ABI
+		{name: "custom error without ABI", data: insufficient},
+		{name: "empty", data: nil, errs: &errs},
+		{name: "selector only", data: pack("Error", "no")[:4], errs: &errs},
+		{name: "truncated error", data: pack("Error", "not enough")[:4+64+5], errs: &errs},
+		{name: "truncated panic", data: pack("Panic", big.NewInt(1))[:4+31], errs: &errs},
+		{name: "panic with trailing data", data: append(pack("Panic", big.NewInt(1)), 0)},
+		{name: "truncated custom error", data: insufficient[:4+32], errs: &errs},
+		{name: "garbage", data: []byte{0xde, 0xad, 0xbe, 0xef, 0x01}, errs: &errs},
+	}
+	for _, test := range tests {
+		reason, ok := DecodeRevert(test.data, test.errs)
+		if reason != test.reason || ok != test.ok {
+			t.Errorf("%v: decoded %v to %q, %v, want %q, %v", test.name, hex.EncodeToString(test.data), reason, ok, test.reason, test.ok)
+		}
+	}
+}
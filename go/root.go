@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// rlpAccount is the consensus encoding of an account in the state trie.
+type rlpAccount struct {
+	Nonce    uint64
+	Balance  *big.Int
+	Root     Hash // the root of the storage trie
+	CodeHash []byte
+}
+
+// trieEntry is a key of a secure trie, the hash of the actual key, and its
+// encoded value.
+type trieEntry struct {
+	key   Hash
+	value []byte
+}
+
+// trieRoot returns the root of the Merkle Patricia trie holding entries.
+func trieRoot(entries []trieEntry) Hash {
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].key[:], entries[j].key[:]) < 0
+	})
+	paths := make([][]byte, len(entries))
+	for i, entry := range entries {
+		paths[i] = keyNibbles(entry.key)
+	}
+	// The root is always referenced by its hash, even when it's short
+	return Keccak256Hash(trieNode(paths, entries, 0))
+}
+
+// keyNibbles splits key into the nibbles of its path in the trie.
+func keyNibbles(key Hash) []byte {
+	nibbles := make([]byte, 2*len(key))
+	for i, b := range key {
+		nibbles[2*i], nibbles[2*i+1] = b>>4, b&0x0f
+	}
+	return nibbles
+}
+
+// trieNode returns the encoding of the node holding the sorted entries,
+// whose paths share their first depth nibbles. As all keys have the same
+// length, values only ever live in leaves.
+func trieNode(paths [][]byte, entries []trieEntry, depth int) []byte {
+	switch len(entries) {
+	case 0:
+		enc, _ := rlp.EncodeToBytes([]byte{})
+		return enc
+	case 1:
+		enc, _ := rlp.EncodeToBytes([][]byte{compactPath(paths[0][depth:], true), entries[0].value})
+		return enc
+	}
+	// Entries sharing more nibbles are held by an extension to a branch
+	first, last := paths[0], paths[len(paths)-1]
+	prefix := depth
+	for first[prefix] == last[prefix] {
+		prefix++
+	}
+	branch := trieBranch(paths, entries, prefix)
+	if prefix == depth {
+		return branch
+	}
+	enc, _ := rlp.EncodeToBytes([]interface{}{compactPath(first[depth:prefix], false), trieRef(branch)})
+	return enc
+}
+
+// trieBranch returns the encoding of the branch splitting the entries on
+// their nibble at depth.
+func trieBranch(paths [][]byte, entries []trieEntry, depth int) []byte {
+	var children [17]interface{}
+	for i := range children {
+		children[i] = []byte{}
+	}
+	for start := 0; start < len(entries); {
+		nibble, end := paths[start][depth], start+1
+		for end < len(entries) && paths[end][depth] == nibble {
+			end++
+		}
+		children[nibble] = trieRef(trieNode(paths[start:end], entries[start:end], depth+1))
+		start = end
+	}
+	enc, _ := rlp.EncodeToBytes(children[:])
+	return enc
+}
+
+// trieRef returns how a parent refers to the encoded node: nodes shorter
+// than a hash are embedded, larger ones are referenced by their hash.
+func trieRef(node []byte) interface{} {
+	if len(node) < len(Hash{}) {
+		return rlp.RawValue(node)
+	}
+	return Keccak256Hash(node)
+}
+
+// compactPath applies the hex prefix encoding to a path of nibbles, flagging
+// whether it leads to a leaf.
+func compactPath(nibbles []byte, leaf bool) []byte {
+	var flag byte
+	if leaf {
+		flag = 2
+	}
+	if len(nibbles)%2 == 1 {
+		flag |= 1
+		nibbles = append([]byte{flag}, nibbles...)
+	} else {
+		nibbles = append([]byte{flag, 0}, nibbles...)
+	}
+	compact := make([]byte, len(nibbles)/2)
+	for i := range compact {
+		compact[i] = nibbles[2*i]<<4 | nibbles[2*i+1]
+	}
+	return compact
+}
+
+// storageRoot returns the root of the account's storage trie.
+func (obj *stateObject) storageRoot() (Hash, error) {
+	entries := make([]trieEntry, 0, len(obj.storage.store))
+	for key, value := range obj.storage.store {
+		// Values are stored without their leading zeros
+		enc, err := rlp.EncodeToBytes(bytes.TrimLeft(value[:], "\x00"))
+		if err != nil {
+			return Hash{}, err
+		}
+		entries = append(entries, trieEntry{key: Keccak256Hash(key[:]), value: enc})
+	}
+	return trieRoot(entries), nil
+}
+
+// IntermediateRoot computes the state root: the root of the trie of all
+// accounts, keyed by the hash of their address.
+func (s *StateDB) IntermediateRoot() (Hash, error) {
+	entries := make([]trieEntry, 0, len(s.accounts))
+	for addr, obj := range s.accounts {
+		root, err := obj.storageRoot()
+		if err != nil {
+			return Hash{}, err
+		}
+		codeHash := Keccak256Hash(obj.code)
+		enc, err := rlp.EncodeToBytes(&rlpAccount{
+			Nonce:    obj.nonce,
+			Balance:  obj.balance.ToBig(),
+			Root:     root,
+			CodeHash: codeHash[:],
+		})
+		if err != nil {
+			return Hash{}, err
+		}
+		entries = append(entries, trieEntry{key: Keccak256Hash(addr[:]), value: enc})
+	}
+	return trieRoot(entries), nil
+}
+
+// LogsHash returns the hash of the RLP encoding of logs, the way test
+// fixtures commit to the logs of a transaction.
+func LogsHash(logs []*Log) (Hash, error) {
+	enc, err := rlp.EncodeToBytes(logs)
+	if err != nil {
+		return Hash{}, err
+	}
+	return Keccak256Hash(enc), nil
+}
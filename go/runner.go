@@ -0,0 +1,517 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/holiman/uint256"
+)
+
+// TestResult is the outcome of running a test case.
+type TestResult struct {
+	Test     *TestCase
+	Duration time.Duration
+
+	// ExpectedStack is the parsed stack of the expectation
+	ExpectedStack []uint256.Int
+
+	// Stack, Return, Success, GasUsed, Logs and ExecErr are what the evm
+	// produced
+	Stack   []uint256.Int
+	Return  string
+	Success bool
+	GasUsed uint64
+	Logs    []*Log
+	ExecErr error
+	// RevertReason is the decoded revert data, when the execution reverted
+	RevertReason string
+	// FailedAt holds where the call frames that failed stopped, the
+	// innermost first, when the config has source maps
+	FailedAt []string
+
+	// Failures describe how the outcome differs from the expectation
+	Failures []string
+	// Err is set when the test could not run to completion, because the case
+	// is malformed or the evm panicked
+	Err error
+}
+
+// Passed reports whether the test case met its expectation.
+func (r *TestResult) Passed() bool {
+	return r.Err == nil && len(r.Failures) == 0
+}
+
+// runProtected runs f, timing it into result. A panic of the evm fails the
+// test instead of the run.
+func runProtected(result *TestResult, f func()) {
+	start := time.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			result.Err = fmt.Errorf("panic: %v\n%s", r, debug.Stack())
+		}
+		result.Duration = time.Since(start)
+	}()
+	f()
+}
+
+// runTest runs a single test case and compares the outcome to its
+// expectation.
+func runTest(test *TestCase, config Config) *TestResult {
+	result := &TestResult{Test: test}
+	config.Unmetered = !test.metered()
+	runProtected(result, func() { result.check(test, config) })
+	return result
+}
+
+// check runs test and records how the outcome differs from its expectation.
+func (r *TestResult) check(test *TestCase, config Config) {
+	bin, err := hex.DecodeString(test.Code.Bin)
+	if err != nil {
+		r.Err = fmt.Errorf("invalid code: %v", err)
+		return
+	}
+	for _, s := range test.Expect.Stack {
+		item, err := parseUint256(s)
+		if err != nil {
+			r.Err = fmt.Errorf("invalid expected stack: %v", err)
+			return
+		}
+		r.ExpectedStack = append(r.ExpectedStack, *item)
+	}
+	state, err := NewStateDBFromAlloc(test.State)
+	if err != nil {
+		r.Err = fmt.Errorf("invalid state: %v", err)
+		return
+	}
+
+	config, locator := locateFailures(config)
+	res, err := evm(bin, &test.Tx, state, &test.Block, config)
+	if err != nil {
+		r.Err = err
+		return
+	}
+	r.Stack, r.Return, r.Success = res.Stack, res.Return, res.Success
+	r.GasUsed, r.Logs, r.ExecErr = res.GasUsed, state.Logs(), res.Err
+	if ret, err := hex.DecodeString(res.Return); err == nil {
+		r.RevertReason = revertReason(res.Err, ret, config.ABI)
+	}
+	if locator != nil {
+		r.FailedAt = locator.failures
+	}
+
+	match := len(r.Stack) == len(r.ExpectedStack)
+	if match {
+		for i, s := range r.Stack {
+			match = match && (s.Cmp(&r.ExpectedStack[i]) == 0)
+		}
+	}
+	if !match {
+		r.Failures = append(r.Failures, fmt.Sprintf("Stack mismatch\nExpected: %v\nGot: %v", toStrings(r.ExpectedStack), toStrings(r.Stack)))
+	}
+	if r.Return != test.Expect.Return {
+		r.Failures = append(r.Failures, fmt.Sprintf("Return data mismatch\nExpected: %v\nGot: %v", test.Expect.Return, r.Return))
+	}
+	if test.Expect.Success != nil && r.Success != *test.Expect.Success {
+		r.Failures = append(r.Failures, fmt.Sprintf("Success mismatch\nExpected: %v\nGot: %v", *test.Expect.Success, r.Success))
+	}
+	if err := r.checkPostState(test, state); err != nil {
+		r.Err = err
+	}
+}
+
+// checkPostState compares the optional expectations on the gas, error, logs
+// and state after the execution.
+func (r *TestResult) checkPostState(test *TestCase, state *StateDB) error {
+	expect := &test.Expect
+	if expect.GasUsed != "" {
+		gasUsed, err := parseUint256(expect.GasUsed)
+		if err != nil {
+			return fmt.Errorf("invalid expected gas used: %v", err)
+		}
+		if !gasUsed.IsUint64() || gasUsed.Uint64() != r.GasUsed {
+			r.Failures = append(r.Failures, fmt.Sprintf("Gas used mismatch\nExpected: %v\nGot: %v", gasUsed, r.GasUsed))
+		}
+	}
+	if expect.Error != nil {
+		switch {
+		case *expect.Error == "" && r.ExecErr != nil:
+			r.Failures = append(r.Failures, fmt.Sprintf("Error mismatch\nExpected: no error\nGot: %v", r.ExecErr))
+		case *expect.Error != "" && (r.ExecErr == nil || !strings.Contains(r.ExecErr.Error(), *expect.Error)):
+			r.Failures = append(r.Failures, fmt.Sprintf("Error mismatch\nExpected: %v\nGot: %v", *expect.Error, r.ExecErr))
+		}
+	}
+	if expect.Logs != nil {
+		var want []*Log
+		for _, log := range expect.Logs {
+			data, err := hex.DecodeString(strings.TrimPrefix(log.Data, "0x"))
+			if err != nil {
+				return fmt.Errorf("invalid expected log data: %v", err)
+			}
+			want = append(want, &Log{Address: log.Address, Topics: log.Topics, Data: data})
+		}
+		if !equalLogs(want, r.Logs) {
+			r.Failures = append(r.Failures, fmt.Sprintf("Logs mismatch\nExpected: %v\nGot: %v", formatLogs(want), formatLogs(r.Logs)))
+		}
+	}
+	if expect.Storage != nil {
+		var to Address
+		if test.Tx.To != nil {
+			to = *test.Tx.To
+		}
+		if got := state.storage(to); !equalStorage(expect.Storage, got) {
+			r.Failures = append(r.Failures, fmt.Sprintf("Storage mismatch\nExpected: %v\nGot: %v", expect.Storage, got))
+		}
+	}
+	// Sort the accounts to report their mismatches in a stable order
+	addrs := make([]Address, 0, len(expect.State))
+	for addr := range expect.State {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool { return bytes.Compare(addrs[i][:], addrs[j][:]) < 0 })
+	for _, addr := range addrs {
+		account := expect.State[addr]
+		if account.Balance != "" {
+			balance, err := parseUint256(account.Balance)
+			if err != nil {
+				return fmt.Errorf("account %v: invalid expected balance: %v", addr, err)
+			}
+			if got := state.GetBalance(addr); !got.Eq(balance) {
+				r.Failures = append(r.Failures, fmt.Sprintf("Balance mismatch of %v\nExpected: %v\nGot: %v", addr, balance.ToBig(), got.ToBig()))
+			}
+		}
+		if account.Nonce != "" {
+			nonce, err := parseUint256(account.Nonce)
+			if err != nil {
+				return fmt.Errorf("account %v: invalid expected nonce: %v", addr, err)
+			}
+			if got := state.GetNonce(addr); !nonce.IsUint64() || nonce.Uint64() != got {
+				r.Failures = append(r.Failures, fmt.Sprintf("Nonce mismatch of %v\nExpected: %v\nGot: %v", addr, nonce.ToBig(), got))
+			}
+		}
+		if account.Code != nil {
+			code, err := hex.DecodeString(account.Code.Bin)
+			if err != nil {
+				return fmt.Errorf("account %v: invalid expected code: %v", addr, err)
+			}
+			if got := state.GetCode(addr); !bytes.Equal(code, got) {
+				r.Failures = append(r.Failures, fmt.Sprintf("Code mismatch of %v\nExpected: %x\nGot: %x", addr, code, got))
+			}
+		}
+		if account.Storage != nil {
+			if got := state.storage(addr); !equalStorage(account.Storage, got) {
+				r.Failures = append(r.Failures, fmt.Sprintf("Storage mismatch of %v\nExpected: %v\nGot: %v", addr, account.Storage, got))
+			}
+		}
+	}
+	return nil
+}
+
+// equalStorage reports whether the storage got holds the slots of want, and
+// no other. Zero slots in want stand for missing slots.
+func equalStorage(want, got map[Hash]Hash) bool {
+	n := 0
+	for key, value := range want {
+		if value == (Hash{}) {
+			continue
+		}
+		if got[key] != value {
+			return false
+		}
+		n++
+	}
+	return n == len(got)
+}
+
+func equalLogs(want, got []*Log) bool {
+	if len(want) != len(got) {
+		return false
+	}
+	for i := range want {
+		if want[i].Address != got[i].Address || !bytes.Equal(want[i].Data, got[i].Data) || len(want[i].Topics) != len(got[i].Topics) {
+			return false
+		}
+		for j := range want[i].Topics {
+			if want[i].Topics[j] != got[i].Topics[j] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func formatLogs(logs []*Log) []string {
+	var formatted []string
+	for _, log := range logs {
+		formatted = append(formatted, fmt.Sprintf("{address: %v, topics: %v, data: %x}", log.Address, log.Topics, log.Data))
+	}
+	return formatted
+}
+
+// runTests runs the test cases in path whose name matches filter, a nil
+// filter matching all. Every case is run, the failures are written to w as
+// they happen and summarized at the end. It returns the results of the run.
+func runTests(w io.Writer, path string, filter *regexp.Regexp, config Config) ([]*TestResult, error) {
+	payload, err := LoadTestCases(path)
+	if err != nil {
+		return nil, err
+	}
+	var tests []*TestCase
+	for i := range payload {
+		if filter == nil || filter.MatchString(payload[i].Name) {
+			tests = append(tests, &payload[i])
+		}
+	}
+
+	names := make([]string, len(tests))
+	for i, test := range tests {
+		names[i] = test.Name
+	}
+	return runCases(w, names, func(i int) *TestResult { return runTest(tests[i], config) }), nil
+}
+
+// runCases runs the named cases with run. Every case is run, the failures
+// are written to w as they happen and summarized at the end.
+func runCases(w io.Writer, names []string, run func(i int) *TestResult) []*TestResult {
+	var (
+		results []*TestResult
+		failed  []*TestResult
+	)
+	for index, name := range names {
+		fmt.Fprintf(w, "Test #%v of %v: %v\n", index+1, len(names), name)
+
+		result := run(index)
+		results = append(results, result)
+		if result.Passed() {
+			continue
+		}
+		failed = append(failed, result)
+		if result.Test.Code.Asm != "" {
+			fmt.Fprintf(w, "Instructions: \n%v\n", result.Test.Code.Asm)
+		}
+		if result.Err != nil {
+			fmt.Fprintf(w, "Error: %v\n", result.Err)
+		}
+		for _, failure := range result.Failures {
+			fmt.Fprintf(w, "%v\n", failure)
+		}
+		if result.RevertReason != "" {
+			fmt.Fprintf(w, "Revert reason: %v\n", result.RevertReason)
+		}
+		if len(result.FailedAt) > 0 {
+			fmt.Fprintf(w, "Failed at:\n")
+			for _, location := range result.FailedAt {
+				fmt.Fprintf(w, "  %v\n", location)
+			}
+		}
+		fmt.Fprintln(w)
+	}
+
+	fmt.Fprintf(w, "\nPassed %v of %v tests\n", len(names)-len(failed), len(names))
+	if len(failed) > 0 {
+		fmt.Fprintf(w, "Failed %v:\n", len(failed))
+		for _, result := range failed {
+			fmt.Fprintf(w, "  %v\n", result.Test.Name)
+		}
+	}
+	return results
+}
+
+func toStrings(stack []uint256.Int) []string {
+	var strings []string
+	for _, s := range stack {
+		strings = append(strings, s.String())
+	}
+	return strings
+}
+
+// runFlags are the flags of the commands running test cases.
+type runFlags struct {
+	run     *string
+	report  *string
+	out     *string
+	trace   *string
+	profile *string
+	cover   *string
+	srcmap  *string
+	abi     *string
+}
+
+// tracers are the tracers selected by the -trace flag, writing to w.
+var tracers = map[string]func(w io.Writer) Tracer{
+	"struct":   func(w io.Writer) Tracer { return NewJSONLogger(w) },
+	"call":     func(w io.Writer) Tracer { return NewCallTracer(w) },
+	"prestate": func(w io.Writer) Tracer { return NewPrestateTracer(w, false) },
+	"diff":     func(w io.Writer) Tracer { return NewPrestateTracer(w, true) },
+}
+
+func newRunFlags(fs *flag.FlagSet) *runFlags {
+	return &runFlags{
+		run:     fs.String("run", "", "only run the test cases whose name matches `regexp`"),
+		report:  fs.String("report", "", "write a report in `format`: json, junit or tap"),
+		out:     fs.String("o", "", "write the report to `file` instead of stdout"),
+		trace:   fs.String("trace", "", "write a trace of the execution to stderr with `tracer`: struct (EIP-3155), call, prestate or diff"),
+		profile: fs.String("profile", "", "profile the gas used by the test cases, writing a summary and the folded stacks of flamegraph tools to `file`"),
+		cover:   fs.String("cover", "", "record the bytecode the test cases execute, writing a summary and a coverage report to `file`"),
+		srcmap:  fs.String("srcmap", "", "report source positions with the output of solc --combined-json bin-runtime,srcmap-runtime in `file`"),
+		abi:     fs.String("abi", "", "decode the custom errors of revert data with the JSON ABI in `file`"),
+	}
+}
+
+// runAndReport runs the test cases matching the -run filter with run,
+// printing their progress and failures, and optionally writes a report of
+// the results in one of reportFormats. The progress goes to stderr when the
+// report is written to stdout. It returns the exit code of the command.
+func (f *runFlags) runAndReport(suite string, run func(w io.Writer, filter *regexp.Regexp, config Config) ([]*TestResult, error)) int {
+	var filter *regexp.Regexp
+	if *f.run != "" {
+		var err error
+		if filter, err = regexp.Compile(*f.run); err != nil {
+			fmt.Fprintln(os.Stderr, "Invalid -run pattern:", err)
+			return 2
+		}
+	}
+	var (
+		writeReport reportFunc
+		progress    io.Writer = os.Stdout
+		reportTo    io.Writer = os.Stdout
+	)
+	if *f.report != "" {
+		var ok bool
+		if writeReport, ok = reportFormats[*f.report]; !ok {
+			fmt.Fprintf(os.Stderr, "Unknown report format %q\n", *f.report)
+			return 2
+		}
+		if *f.out == "" {
+			progress = os.Stderr
+		}
+	}
+	if *f.out != "" {
+		if writeReport == nil {
+			fmt.Fprintln(os.Stderr, "-o requires -report")
+			return 2
+		}
+		file, err := os.Create(*f.out)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error creating report:", err)
+			return 1
+		}
+		defer file.Close()
+		reportTo = file
+	}
+
+	var (
+		config    Config
+		observers multiTracer
+		profiler  *GasProfiler
+		coverage  *Coverage
+	)
+	if *f.srcmap != "" {
+		sources, err := LoadSourceMaps(*f.srcmap)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error loading source maps:", err)
+			return 1
+		}
+		config.Sources = sources
+	}
+	if *f.abi != "" {
+		errs, err := LoadErrorsABI(*f.abi)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error loading ABI:", err)
+			return 1
+		}
+		config.ABI = errs
+	}
+	if *f.trace != "" {
+		newTracer, ok := tracers[*f.trace]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Unknown tracer %q\n", *f.trace)
+			return 2
+		}
+		observers = append(observers, newTracer(os.Stderr))
+	}
+	if *f.profile != "" {
+		profiler = NewGasProfiler()
+		observers = append(observers, profiler)
+	}
+	if *f.cover != "" {
+		coverage = NewCoverage()
+		observers = append(observers, coverage)
+	}
+	switch len(observers) {
+	case 0:
+	case 1:
+		config.Tracer = observers[0]
+	default:
+		config.Tracer = observers
+	}
+
+	results, err := run(progress, filter, config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error loading test cases:", err)
+		return 1
+	}
+	if profiler != nil {
+		fmt.Fprintln(progress)
+		profiler.WriteSummary(progress)
+		if err := writeFile(*f.profile, profiler.WriteFolded); err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing profile:", err)
+			return 1
+		}
+	}
+	if coverage != nil {
+		fmt.Fprintln(progress)
+		coverage.WriteSummary(progress)
+		if err := writeFile(*f.cover, coverage.WriteReport); err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing coverage report:", err)
+			return 1
+		}
+	}
+	if writeReport != nil {
+		if err := writeReport(reportTo, suite, results); err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing report:", err)
+			return 1
+		}
+	}
+	for _, result := range results {
+		if !result.Passed() {
+			return 1
+		}
+	}
+	return 0
+}
+
+// writeFile creates the file at path and writes it with write.
+func writeFile(path string, write func(w io.Writer) error) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// testCommand runs the test cases of scripts/evm.yaml or evm.json.
+func testCommand(args []string) int {
+	fs := flag.NewFlagSet("evm", flag.ContinueOnError)
+	tests := fs.String("tests", "../scripts/evm.yaml", "run the test cases in `file`, scripts/evm.yaml or evm.json")
+	flags := newRunFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	return flags.runAndReport(filepath.Base(*tests), func(w io.Writer, filter *regexp.Regexp, config Config) ([]*TestResult, error) {
+		return runTests(w, *tests, filter, config)
+	})
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+const runnerCases = `
+ADD:
+  code:
+    - PUSH1 1
+    - PUSH1 2
+    - ADD
+  expect:
+    stack: [3n]
+
+ADD (wrong):
+  code:
+    - PUSH1 1
+    - PUSH1 2
+    - ADD
+  expect:
+    stack: [4n]
+
+SELFBALANCE (panics):
+  code:
+    - SELFBALANCE
+  expect:
+    stack: [0n]
+
+MUL:
+  code:
+    - PUSH1 2
+    - PUSH1 3
+    - MUL
+  expect:
+    stack: [6n]
+`
+
+// writeRunnerCases writes runnerCases to a file and returns its path.
+func writeRunnerCases(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "cases.yaml")
+	if err := os.WriteFile(path, []byte(runnerCases), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// panicTracer panics on SELFBALANCE, standing in for a bug of the evm.
+type panicTracer struct{ *JSONLogger }
+
+func (panicTracer) CaptureState(pc uint64, op OpCode, gas, cost uint64, scope *executionContext, rData []byte, depth int, err error) {
+	if op == SELFBALANCE {
+		panic("SELFBALANCE")
+	}
+}
+
+func TestRunTests(t *testing.T) {
+	path := writeRunnerCases(t)
+	var out bytes.Buffer
+	results, err := runTests(&out, path, nil, Config{Tracer: panicTracer{NewJSONLogger(io.Discard)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The cases after the failure and the panic run too
+	want := []bool{true, false, false, true}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(results), len(want))
+	}
+	for i, result := range results {
+		if result.Passed() != want[i] {
+			t.Errorf("%v: passed %v, want %v", result.Test.Name, result.Passed(), want[i])
+		}
+	}
+	if len(results[1].Failures) != 1 || !strings.HasPrefix(results[1].Failures[0], "Stack mismatch") {
+		t.Errorf("got failures %q, want a stack mismatch", results[1].Failures)
+	}
+	if results[2].Err == nil || !strings.HasPrefix(results[2].Err.Error(), "panic: SELFBALANCE") {
+		t.Errorf("got error %v, want the panic", results[2].Err)
+	}
+
+	for _, s := range []string{
+		"Test #1 of 4: ADD\n",
+		"Test #2 of 4: ADD (wrong)\nInstructions: \nPUSH1 1\nPUSH1 2\nADD\nStack mismatch\nExpected: [0x4]\nGot: [0x3]\n\n",
+		"Test #3 of 4: SELFBALANCE (panics)\nInstructions: \nSELFBALANCE\nError: panic: SELFBALANCE\n",
+		"Test #4 of 4: MUL\n",
+	} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("output lacks %q:\n%v", s, out.String())
+		}
+	}
+	summary := "\nPassed 2 of 4 tests\nFailed 2:\n  ADD (wrong)\n  SELFBALANCE (panics)\n"
+	if !strings.HasSuffix(out.String(), summary) {
+		t.Errorf("got output\n%v\nwant it to end with\n%v", out.String(), summary)
+	}
+}
+
+func TestRunTestsFilter(t *testing.T) {
+	path := writeRunnerCases(t)
+	tests := []struct {
+		filter string
+		want   []string
+	}{
+		{`^ADD`, []string{"ADD", "ADD (wrong)"}},
+		{`\(`, []string{"ADD (wrong)", "SELFBALANCE (panics)"}},
+		{`^MUL$`, []string{"MUL"}},
+		{`DIV`, nil},
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		results, err := runTests(&out, path, regexp.MustCompile(test.filter), Config{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, result := range results {
+			got = append(got, result.Test.Name)
+		}
+		if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+			t.Errorf("%v: ran %q, want %q", test.filter, got, test.want)
+		}
+	}
+}
+
+// runTestCommand runs the test command with args and returns its exit code
+// and what it wrote to stdout.
+func runTestCommand(t *testing.T, args ...string) (int, string) {
+	stdout, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdout.Close()
+	saved := os.Stdout
+	os.Stdout = stdout
+	code := testCommand(args)
+	os.Stdout = saved
+
+	out, err := os.ReadFile(stdout.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code, string(out)
+}
+
+func TestTestCommandExitCode(t *testing.T) {
+	path := writeRunnerCases(t)
+	tests := []struct {
+		run     string
+		code    int
+		summary string
+	}{
+		{"", 1, "Passed 3 of 4 tests\nFailed 1:\n  ADD (wrong)\n"},
+		{"^(ADD|MUL)$", 0, "Passed 2 of 2 tests\n"},
+		{"wrong", 1, "Passed 0 of 1 tests\nFailed 1:\n  ADD (wrong)\n"},
+		{"(", 2, ""},
+	}
+	for _, test := range tests {
+		code, out := runTestCommand(t, "-tests", path, "-run", test.run)
+		if code != test.code {
+			t.Errorf("-run %q: got exit code %d, want %d", test.run, code, test.code)
+		}
+		if !strings.HasSuffix(out, test.summary) {
+			t.Errorf("-run %q: got output\n%v\nwant it to end with\n%v", test.run, out, test.summary)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/holiman/uint256"
+)
+
+// SourcePos is a position in a Solidity source file.
+type SourcePos struct {
+	// Contract is the name of the contract the code was compiled from, as
+	// file:name
+	Contract string
+	File     string
+	// Line and Column start at 1
+	Line, Column int
+}
+
+func (p SourcePos) String() string {
+	return fmt.Sprintf("%v:%d", p.File, p.Line)
+}
+
+// sourceMap is the code of a compiled contract, with the source position of
+// its instructions by pc.
+type sourceMap struct {
+	code      []byte
+	positions map[uint64]SourcePos
+}
+
+// SourceMaps maps the code of compiled contracts back to their sources.
+// Runtime code is recognized when it's identical to the compiled code, so
+// contracts with immutables are not, and creation code when it starts with
+// the compiled code, followed by the constructor arguments. When several
+// creation codes match, the longest one is.
+type SourceMaps struct {
+	runtime  map[Hash]*sourceMap
+	creation []*sourceMap
+	// lookups caches the source map of the codes looked up, nil for codes
+	// that have none
+	lookups map[string]*sourceMap
+}
+
+// combinedJSON is the output of solc --combined-json, with the fields the
+// source maps are built from.
+type combinedJSON struct {
+	Contracts map[string]struct {
+		Bin           string `json:"bin"`
+		SrcMap        string `json:"srcmap"`
+		BinRuntime    string `json:"bin-runtime"`
+		SrcMapRuntime string `json:"srcmap-runtime"`
+	} `json:"contracts"`
+	SourceList []string `json:"sourceList"`
+}
+
+// LoadSourceMaps reads the output of solc --combined-json
+// bin-runtime,srcmap-runtime at path, optionally with bin,srcmap for the
+// creation code. The sources it lists are read relative to the directory of
+// path, or to the working directory.
+func LoadSourceMaps(path string) (*SourceMaps, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var combined combinedJSON
+	if err := json.Unmarshal(data, &combined); err != nil {
+		return nil, fmt.Errorf("invalid combined json: %v", err)
+	}
+
+	// Line starts of every source, to turn offsets into lines
+	lines := make([][]int, len(combined.SourceList))
+	for i, name := range combined.SourceList {
+		source, err := os.ReadFile(filepath.Join(filepath.Dir(path), name))
+		if os.IsNotExist(err) {
+			source, err = os.ReadFile(name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading source: %v", err)
+		}
+		lines[i] = []int{0}
+		for offset, c := range source {
+			if c == '\n' {
+				lines[i] = append(lines[i], offset+1)
+			}
+		}
+	}
+
+	maps := &SourceMaps{runtime: make(map[Hash]*sourceMap), lookups: make(map[string]*sourceMap)}
+	names := make([]string, 0, len(combined.Contracts))
+	for name := range combined.Contracts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		contract := combined.Contracts[name]
+		if contract.BinRuntime == "" && contract.Bin == "" {
+			return nil, fmt.Errorf("contract %v: no bytecode, compile with --combined-json bin-runtime,srcmap-runtime", name)
+		}
+		// Contracts linking to libraries have placeholders in their code and
+		// can't be deployed as they are
+		if strings.Contains(contract.BinRuntime, "__") || strings.Contains(contract.Bin, "__") {
+			continue
+		}
+		if contract.BinRuntime != "" {
+			m, err := newSourceMap(name, contract.BinRuntime, contract.SrcMapRuntime, combined.SourceList, lines)
+			if err != nil {
+				return nil, fmt.Errorf("contract %v: %v", name, err)
+			}
+			// Abstract contracts and interfaces have no code
+			if len(m.code) > 0 {
+				maps.runtime[BytesToHash(crypto.Keccak256(m.code))] = m
+			}
+		}
+		if contract.Bin != "" {
+			m, err := newSourceMap(name, contract.Bin, contract.SrcMap, combined.SourceList, lines)
+			if err != nil {
+				return nil, fmt.Errorf("contract %v: %v", name, err)
+			}
+			if len(m.code) > 0 {
+				maps.creation = append(maps.creation, m)
+			}
+		}
+	}
+	return maps, nil
+}
+
+// newSourceMap decodes the source map of the code of contract. Its entries
+// are those of the instructions of the code in order, as Disassemble decodes
+// them, each one s:l:f:j:m with empty fields repeating the previous entry.
+func newSourceMap(contract, bin, srcmap string, sourceList []string, lines [][]int) (*sourceMap, error) {
+	code, err := hex.DecodeString(strings.TrimPrefix(bin, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid bytecode: %v", err)
+	}
+	m := &sourceMap{code: code, positions: make(map[uint64]SourcePos)}
+	if srcmap == "" {
+		return m, nil
+	}
+	var (
+		instructions = Disassemble(code)
+		entry        [3]int // offset, length and index of the file
+	)
+	for i, s := range strings.Split(srcmap, ";") {
+		if i >= len(instructions) {
+			return nil, fmt.Errorf("source map has more entries than the code has instructions")
+		}
+		fields := strings.Split(s, ":")
+		for j := 0; j < len(fields) && j < len(entry); j++ {
+			if fields[j] == "" {
+				continue
+			}
+			if entry[j], err = strconv.Atoi(fields[j]); err != nil {
+				return nil, fmt.Errorf("invalid source map entry %q", s)
+			}
+		}
+		// Code generated by the compiler maps to no file
+		file := entry[2]
+		if file < 0 || file >= len(sourceList) {
+			continue
+		}
+		line := sort.SearchInts(lines[file], entry[0]+1) - 1
+		m.positions[instructions[i].PC] = SourcePos{
+			Contract: contract,
+			File:     sourceList[file],
+			Line:     line + 1,
+			Column:   entry[0] - lines[file][line] + 1,
+		}
+	}
+	return m, nil
+}
+
+// lookup returns the source map of code, or nil when it was compiled from
+// none of the sources.
+func (m *SourceMaps) lookup(code []byte) *sourceMap {
+	if found, ok := m.lookups[string(code)]; ok {
+		return found
+	}
+	found := m.runtime[BytesToHash(crypto.Keccak256(code))]
+	if found == nil {
+		// The creation code of a contract can start with that of another
+		// one, so the longest match is the most specific
+		for _, creation := range m.creation {
+			if bytes.HasPrefix(code, creation.code) && (found == nil || len(creation.code) > len(found.code)) {
+				found = creation
+			}
+		}
+	}
+	m.lookups[string(code)] = found
+	return found
+}
+
+// Position returns the source position of the instruction at pc in code. It
+// is false for code compiled from none of the sources, for instructions
+// generated by the compiler, and for nil source maps.
+func (m *SourceMaps) Position(code []byte, pc uint64) (SourcePos, bool) {
+	if m == nil {
+		return SourcePos{}, false
+	}
+	found := m.lookup(code)
+	if found == nil {
+		return SourcePos{}, false
+	}
+	pos, ok := found.positions[pc]
+	return pos, ok
+}
+
+// locatedFrame is the last opcode run by a call frame.
+type locatedFrame struct {
+	address Address
+	code    []byte
+	pc      uint64
+	op      OpCode
+}
+
+// failureLocator is a Tracer recording where the call frames that failed
+// stopped, as source positions where they are known.
+type failureLocator struct {
+	sources *SourceMaps
+	frames  []locatedFrame
+	// failures holds the locations in the order the frames failed, the
+	// innermost frames first
+	failures []string
+}
+
+// failed records the location of the frame ending with err, if it failed.
+func (l *failureLocator) failed(err error) {
+	frame := l.frames[len(l.frames)-1]
+	l.frames = l.frames[:len(l.frames)-1]
+	if err == nil || frame.code == nil {
+		return
+	}
+	location := fmt.Sprintf("%v at pc %05x of %v", frame.op, frame.pc, frame.address)
+	if pos, ok := l.sources.Position(frame.code, frame.pc); ok {
+		location = fmt.Sprintf("%v at %v in %v", frame.op, pos, pos.Contract)
+	}
+	l.failures = append(l.failures, fmt.Sprintf("%v: %v", location, err))
+}
+
+func (l *failureLocator) CaptureTxStart(gasLimit uint64) {}
+
+func (l *failureLocator) CaptureTxEnd(restGas uint64) {}
+
+func (l *failureLocator) CaptureStart(vm *VM, from Address, to Address, create bool, input []byte, gas uint64, value *uint256.Int) {
+	l.frames = []locatedFrame{{address: to}}
+}
+
+func (l *failureLocator) CaptureState(pc uint64, op OpCode, gas, cost uint64, scope *executionContext, rData []byte, depth int, err error) {
+	frame := &l.frames[len(l.frames)-1]
+	frame.code, frame.pc, frame.op = scope.code, pc, op
+}
+
+func (l *failureLocator) CaptureFault(pc uint64, op OpCode, gas, cost uint64, scope *executionContext, depth int, err error) {
+}
+
+func (l *failureLocator) CaptureEnd(output []byte, gasUsed uint64, err error) {
+	l.failed(err)
+}
+
+func (l *failureLocator) CaptureEnter(typ OpCode, from Address, to Address, input []byte, gas uint64, value *uint256.Int) {
+	l.frames = append(l.frames, locatedFrame{address: to})
+}
+
+func (l *failureLocator) CaptureExit(output []byte, gasUsed uint64, err error) {
+	l.failed(err)
+}
+
+// locateFailures returns config with a failureLocator observing the
+// execution along with its tracer, when it has source maps.
+func locateFailures(config Config) (Config, *failureLocator) {
+	if config.Sources == nil {
+		return config, nil
+	}
+	locator := &failureLocator{sources: config.Sources}
+	if config.Tracer == nil {
+		config.Tracer = locator
+	} else {
+		config.Tracer = multiTracer{config.Tracer, locator}
+	}
+	return config, locator
+}
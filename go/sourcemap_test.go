@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestSourceMapsLookupLongestCreationPrefix(t *testing.T) {
+	base := &sourceMap{code: []byte{0x60, 0x80, 0x60, 0x40}}
+	derived := &sourceMap{code: []byte{0x60, 0x80, 0x60, 0x40, 0x52, 0x34}}
+	// The base contract sorts first, and its code is a prefix of the
+	// derived one's
+	maps := &SourceMaps{
+		runtime:  make(map[Hash]*sourceMap),
+		creation: []*sourceMap{base, derived},
+		lookups:  make(map[string]*sourceMap),
+	}
+	tests := []struct {
+		name string
+		code []byte
+		want *sourceMap
+	}{
+		{"base", []byte{0x60, 0x80, 0x60, 0x40, 0x00, 0x2a}, base},
+		{"derived", []byte{0x60, 0x80, 0x60, 0x40, 0x52, 0x34, 0x2a}, derived},
+		{"none", []byte{0x60, 0x00}, nil},
+	}
+	for _, test := range tests {
+		if got := maps.lookup(test.code); got != test.want {
+			t.Errorf("%v: lookup returned the wrong source map", test.name)
+		}
+	}
+}
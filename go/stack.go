@@ -46,3 +46,7 @@ func (s *stackStruct) swap(n int64) {
 func (s *stackStruct) Back(n int64) *uint256.Int {
 	return &s.data[n]
 }
+
+func (s *stackStruct) len() int {
+	return int(s.n)
+}
@@ -1,4 +1,464 @@
 package main
 
+import (
+	"encoding/hex"
+	"fmt"
 
+	"github.com/holiman/uint256"
+)
 
+// GenesisAccount is an account in the state of a test case.
+type GenesisAccount struct {
+	Balance string
+	Nonce   string
+	Code    code
+	Storage map[Hash]Hash
+}
+
+// GenesisAlloc specifies the initial state of a test case.
+type GenesisAlloc map[Address]GenesisAccount
+
+type stateObject struct {
+	address Address
+	balance *uint256.Int
+	nonce   uint64
+	code    []byte
+	storage *storageStruct
+
+	// originStorage holds the values the slots written by the current
+	// transaction had when it started
+	originStorage map[Hash]Hash
+
+	// suicided is set once the account self-destructed, it's deleted at the
+	// end of the transaction
+	suicided bool
+
+	// newContract is set while the contract is being created by the current
+	// transaction, only then can it self-destruct (EIP-6780)
+	newContract bool
+}
+
+func newObject(address Address) *stateObject {
+	return &stateObject{
+		address:       address,
+		balance:       new(uint256.Int),
+		storage:       newStorage(),
+		originStorage: make(map[Hash]Hash),
+	}
+}
+
+// StateDB holds the accounts the interpreter reads and modifies, keyed by
+// their address.
+type StateDB struct {
+	accounts map[Address]*stateObject
+	logs     []*Log
+	refund   uint64
+	journal  *journal
+
+	// accessList holds the addresses and slots warmed up by the current
+	// transaction
+	accessList *accessList
+
+	// transientStorage holds the slots written by TSTORE, which only last
+	// for the current transaction (EIP-1153)
+	transientStorage map[Address]*storageStruct
+}
+
+func NewStateDB() *StateDB {
+	return &StateDB{
+		accounts:         make(map[Address]*stateObject),
+		journal:          newJournal(),
+		accessList:       newAccessList(),
+		transientStorage: make(map[Address]*storageStruct),
+	}
+}
+
+// NewStateDBFromAlloc builds the state described by a test case.
+func NewStateDBFromAlloc(alloc GenesisAlloc) (*StateDB, error) {
+	s := NewStateDB()
+	for addr, account := range alloc {
+		obj := newObject(addr)
+		s.accounts[addr] = obj
+
+		balance, err := parseUint256(account.Balance)
+		if err != nil {
+			return nil, fmt.Errorf("account %v: %v", addr, err)
+		}
+		obj.balance = balance
+
+		nonce, err := parseUint256(account.Nonce)
+		if err != nil {
+			return nil, fmt.Errorf("account %v: %v", addr, err)
+		}
+		obj.nonce = nonce.Uint64()
+
+		code, err := hex.DecodeString(account.Code.Bin)
+		if err != nil {
+			return nil, fmt.Errorf("account %v: %v", addr, err)
+		}
+		obj.code = code
+
+		for key, value := range account.Storage {
+			obj.storage.set(key, value)
+		}
+	}
+	return s, nil
+}
+
+func (s *StateDB) getObject(addr Address) *stateObject {
+	return s.accounts[addr]
+}
+
+func (s *StateDB) getOrNewObject(addr Address) *stateObject {
+	obj := s.accounts[addr]
+	if obj == nil {
+		obj = newObject(addr)
+		s.accounts[addr] = obj
+		s.journal.append(createObjectChange{account: addr})
+	}
+	return obj
+}
+
+// CreateAccount creates a new empty account at addr, carrying over the
+// balance of any account that was already there.
+func (s *StateDB) CreateAccount(addr Address) {
+	obj := newObject(addr)
+	if prev := s.accounts[addr]; prev != nil {
+		obj.balance = prev.balance
+		s.journal.append(resetObjectChange{prev: prev})
+	} else {
+		s.journal.append(createObjectChange{account: addr})
+	}
+	s.accounts[addr] = obj
+}
+
+// CreateContract marks the account at addr as a contract created by the
+// current transaction.
+func (s *StateDB) CreateContract(addr Address) {
+	obj := s.getOrNewObject(addr)
+	if !obj.newContract {
+		s.journal.append(createContractChange{account: addr})
+		obj.newContract = true
+	}
+}
+
+func (s *StateDB) Exist(addr Address) bool {
+	return s.accounts[addr] != nil
+}
+
+// Empty returns whether the account is non-existent or has zero nonce,
+// balance and code (EIP-161).
+func (s *StateDB) Empty(addr Address) bool {
+	obj := s.getObject(addr)
+	return obj == nil || (obj.nonce == 0 && obj.balance.IsZero() && len(obj.code) == 0)
+}
+
+func (s *StateDB) GetBalance(addr Address) *uint256.Int {
+	if obj := s.getObject(addr); obj != nil {
+		return new(uint256.Int).Set(obj.balance)
+	}
+	return new(uint256.Int)
+}
+
+func (s *StateDB) AddBalance(addr Address, amount *uint256.Int) {
+	obj := s.getOrNewObject(addr)
+	s.setBalance(obj, new(uint256.Int).Add(obj.balance, amount))
+}
+
+func (s *StateDB) SubBalance(addr Address, amount *uint256.Int) {
+	obj := s.getOrNewObject(addr)
+	s.setBalance(obj, new(uint256.Int).Sub(obj.balance, amount))
+}
+
+func (s *StateDB) setBalance(obj *stateObject, amount *uint256.Int) {
+	s.journal.append(balanceChange{account: obj.address, prev: obj.balance})
+	obj.balance = amount
+}
+
+func (s *StateDB) GetNonce(addr Address) uint64 {
+	if obj := s.getObject(addr); obj != nil {
+		return obj.nonce
+	}
+	return 0
+}
+
+func (s *StateDB) SetNonce(addr Address, nonce uint64) {
+	obj := s.getOrNewObject(addr)
+	s.journal.append(nonceChange{account: addr, prev: obj.nonce})
+	obj.nonce = nonce
+}
+
+func (s *StateDB) GetCode(addr Address) []byte {
+	if obj := s.getObject(addr); obj != nil {
+		return obj.code
+	}
+	return nil
+}
+
+func (s *StateDB) GetCodeSize(addr Address) uint64 {
+	return uint64(len(s.GetCode(addr)))
+}
+
+// GetCodeHash returns the hash of the account's code, or the zero hash if the
+// account does not exist.
+func (s *StateDB) GetCodeHash(addr Address) Hash {
+	if obj := s.getObject(addr); obj != nil {
+		return Keccak256Hash(obj.code)
+	}
+	return Hash{}
+}
+
+func (s *StateDB) SetCode(addr Address, code []byte) {
+	obj := s.getOrNewObject(addr)
+	s.journal.append(codeChange{account: addr, prevcode: obj.code})
+	obj.code = code
+}
+
+// Suicide marks the account as self-destructed and clears its balance. The
+// account is deleted at the end of the transaction, until then it can still
+// be accessed. It returns whether the account existed.
+func (s *StateDB) Suicide(addr Address) bool {
+	obj := s.getObject(addr)
+	if obj == nil {
+		return false
+	}
+	s.journal.append(suicideChange{
+		account:     addr,
+		prev:        obj.suicided,
+		prevbalance: obj.balance,
+	})
+	obj.suicided = true
+	obj.balance = new(uint256.Int)
+	return true
+}
+
+// Suicide6780 self-destructs the account like Suicide, but only if it was
+// created by the current transaction (EIP-6780). Other accounts are left
+// untouched.
+func (s *StateDB) Suicide6780(addr Address) {
+	if obj := s.getObject(addr); obj != nil && obj.newContract {
+		s.Suicide(addr)
+	}
+}
+
+// HasSuicided returns whether the account self-destructed during the current
+// transaction.
+func (s *StateDB) HasSuicided(addr Address) bool {
+	if obj := s.getObject(addr); obj != nil {
+		return obj.suicided
+	}
+	return false
+}
+
+func (s *StateDB) GetState(addr Address, key Hash) Hash {
+	if obj := s.getObject(addr); obj != nil {
+		return obj.storage.get(key)
+	}
+	return Hash{}
+}
+
+// GetCommittedState returns the value of the slot at the start of the
+// current transaction.
+func (s *StateDB) GetCommittedState(addr Address, key Hash) Hash {
+	obj := s.getObject(addr)
+	if obj == nil {
+		return Hash{}
+	}
+	if value, dirty := obj.originStorage[key]; dirty {
+		return value
+	}
+	return obj.storage.get(key)
+}
+
+// originAccount returns a copy of the account as it was at the start of the
+// current transaction, or nil if it didn't exist then. It shares the storage
+// of the account, originState reads the slots as they were.
+func (s *StateDB) originAccount(addr Address) *stateObject {
+	return s.journal.origin(s, addr)
+}
+
+// originState returns the value of the slot at the start of the current
+// transaction. Unlike GetCommittedState, it sees through accounts created
+// over an existing one.
+func (s *StateDB) originState(addr Address, key Hash) Hash {
+	obj := s.originAccount(addr)
+	if obj == nil {
+		return Hash{}
+	}
+	if value, dirty := obj.originStorage[key]; dirty {
+		return value
+	}
+	return obj.storage.get(key)
+}
+
+// storage returns a copy of the non-zero slots of the account's storage.
+func (s *StateDB) storage(addr Address) map[Hash]Hash {
+	storage := make(map[Hash]Hash)
+	if obj := s.getObject(addr); obj != nil {
+		for key, value := range obj.storage.store {
+			storage[key] = value
+		}
+	}
+	return storage
+}
+
+func (s *StateDB) SetState(addr Address, key, value Hash) {
+	obj := s.getOrNewObject(addr)
+	prev := obj.storage.get(key)
+	if _, dirty := obj.originStorage[key]; !dirty {
+		obj.originStorage[key] = prev
+	}
+	s.journal.append(storageChange{account: addr, key: key, prevalue: prev})
+	obj.storage.set(key, value)
+}
+
+// GetTransientState returns the value of the slot in the transient storage
+// of the account.
+func (s *StateDB) GetTransientState(addr Address, key Hash) Hash {
+	if storage := s.transientStorage[addr]; storage != nil {
+		return storage.get(key)
+	}
+	return Hash{}
+}
+
+// SetTransientState writes the slot in the transient storage of the account.
+func (s *StateDB) SetTransientState(addr Address, key, value Hash) {
+	prev := s.GetTransientState(addr, key)
+	if prev == value {
+		return
+	}
+	s.journal.append(transientStorageChange{account: addr, key: key, prevalue: prev})
+	s.setTransientState(addr, key, value)
+}
+
+func (s *StateDB) setTransientState(addr Address, key, value Hash) {
+	storage := s.transientStorage[addr]
+	if storage == nil {
+		storage = newStorage()
+		s.transientStorage[addr] = storage
+	}
+	storage.set(key, value)
+}
+
+func (s *StateDB) AddLog(log *Log) {
+	s.journal.append(addLogChange{})
+	s.logs = append(s.logs, log)
+}
+
+// Logs returns the logs emitted by the current transaction.
+func (s *StateDB) Logs() []*Log {
+	return s.logs
+}
+
+// AddRefund adds gas to the refund counter
+func (s *StateDB) AddRefund(gas uint64) {
+	s.journal.append(refundChange{prev: s.refund})
+	s.refund += gas
+}
+
+// SubRefund removes gas from the refund counter.
+// This method will panic if the refund counter goes below zero
+func (s *StateDB) SubRefund(gas uint64) {
+	s.journal.append(refundChange{prev: s.refund})
+	if gas > s.refund {
+		panic(fmt.Sprintf("Refund counter below zero (gas: %d > refund: %d)", gas, s.refund))
+	}
+	s.refund -= gas
+}
+
+// GetRefund returns the current value of the refund counter.
+func (s *StateDB) GetRefund() uint64 {
+	return s.refund
+}
+
+// PrepareAccessList resets the access list for a new transaction and warms
+// up the addresses and slots every transaction starts with (EIP-2929):
+// - the sender and the destination, if any
+// - the precompiled contracts
+// - the coinbase (EIP-3651)
+// - the entries of the transaction's access list (EIP-2930)
+func (s *StateDB) PrepareAccessList(sender, coinbase Address, dst *Address, precompiles []Address, list AccessList) {
+	s.accessList = newAccessList()
+
+	s.accessList.AddAddress(sender)
+	if dst != nil {
+		s.accessList.AddAddress(*dst)
+	}
+	for _, addr := range precompiles {
+		s.accessList.AddAddress(addr)
+	}
+	s.accessList.AddAddress(coinbase)
+	for _, el := range list {
+		s.accessList.AddAddress(el.Address)
+		for _, key := range el.StorageKeys {
+			s.accessList.AddSlot(el.Address, key)
+		}
+	}
+}
+
+// AddAddressToAccessList adds the given address to the access list
+func (s *StateDB) AddAddressToAccessList(addr Address) {
+	if s.accessList.AddAddress(addr) {
+		s.journal.append(accessListAddAccountChange{&addr})
+	}
+}
+
+// AddSlotToAccessList adds the given (address, slot)-tuple to the access list
+func (s *StateDB) AddSlotToAccessList(addr Address, slot Hash) {
+	addrMod, slotMod := s.accessList.AddSlot(addr, slot)
+	if addrMod {
+		// In practice, this should not happen, since there is no way to enter the
+		// scope of 'address' without having the 'address' become already added
+		// to the access list (via call-variant, create, etc).
+		// Better safe than sorry, though
+		s.journal.append(accessListAddAccountChange{&addr})
+	}
+	if slotMod {
+		s.journal.append(accessListAddSlotChange{
+			address: &addr,
+			slot:    &slot,
+		})
+	}
+}
+
+// AddressInAccessList returns true if the given address is in the access list.
+func (s *StateDB) AddressInAccessList(addr Address) bool {
+	return s.accessList.ContainsAddress(addr)
+}
+
+// SlotInAccessList returns true if the given (address, slot)-tuple is in the access list.
+func (s *StateDB) SlotInAccessList(addr Address, slot Hash) (addressPresent bool, slotPresent bool) {
+	return s.accessList.Contains(addr, slot)
+}
+
+// Snapshot returns an identifier for the current revision of the state.
+func (s *StateDB) Snapshot() int {
+	return s.journal.length()
+}
+
+// RevertToSnapshot reverts all state changes made since the given revision.
+func (s *StateDB) RevertToSnapshot(revid int) {
+	s.journal.revert(s, revid)
+}
+
+// Finalise ends the current transaction: the accounts that self-destructed
+// and the empty accounts it touched are removed (EIP-161), the storage
+// written by it is committed, the contracts it created stop being new, and
+// the journal, refund counter, logs, access list and transient storage are
+// reset so the next transaction starts from a clean slate.
+func (s *StateDB) Finalise() {
+	for addr := range s.journal.dirties() {
+		if obj := s.getObject(addr); obj != nil && (obj.suicided || s.Empty(addr)) {
+			delete(s.accounts, addr)
+		}
+	}
+	for _, obj := range s.accounts {
+		obj.originStorage = make(map[Hash]Hash)
+		obj.newContract = false
+	}
+	s.journal = newJournal()
+	s.refund = 0
+	s.logs = nil
+	s.accessList = newAccessList()
+	s.transientStorage = make(map[Address]*storageStruct)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/holiman/uint256"
+)
+
+func TestRevertToSnapshot(t *testing.T) {
+	var (
+		addr    = HexToAddress("0x2000000000000000000000000000000000000002")
+		created = HexToAddress("0x3000000000000000000000000000000000000003")
+		key     = Hash{31: 1}
+	)
+	tests := []struct {
+		name   string
+		change func(s *StateDB)
+		check  func(t *testing.T, s *StateDB)
+	}{
+		{"balance", func(s *StateDB) { s.AddBalance(addr, uint256.NewInt(5)) }, func(t *testing.T, s *StateDB) {
+			if got := s.GetBalance(addr); !got.Eq(uint256.NewInt(10)) {
+				t.Errorf("got balance %v, want 10", got)
+			}
+		}},
+		{"nonce", func(s *StateDB) { s.SetNonce(addr, 8) }, func(t *testing.T, s *StateDB) {
+			if got := s.GetNonce(addr); got != 7 {
+				t.Errorf("got nonce %d, want 7", got)
+			}
+		}},
+		{"code", func(s *StateDB) { s.SetCode(addr, []byte{byte(STOP)}) }, func(t *testing.T, s *StateDB) {
+			if got := s.GetCode(addr); len(got) != 1 || got[0] != byte(ADD) {
+				t.Errorf("got code %x, want 01", got)
+			}
+		}},
+		{"storage", func(s *StateDB) { s.SetState(addr, key, Hash{31: 2}) }, func(t *testing.T, s *StateDB) {
+			if got := s.GetState(addr, key); got != (Hash{31: 1}) {
+				t.Errorf("got slot %v, want 1", got)
+			}
+			if got := s.GetCommittedState(addr, key); got != (Hash{31: 1}) {
+				t.Errorf("got committed slot %v, want 1", got)
+			}
+		}},
+		{"created account", func(s *StateDB) { s.AddBalance(created, uint256.NewInt(1)) }, func(t *testing.T, s *StateDB) {
+			if s.Exist(created) {
+				t.Error("created account still exists")
+			}
+		}},
+		{"recreated account", func(s *StateDB) { s.CreateAccount(addr) }, func(t *testing.T, s *StateDB) {
+			if got := s.GetNonce(addr); got != 7 {
+				t.Errorf("got nonce %d, want 7", got)
+			}
+			if got := s.GetState(addr, key); got != (Hash{31: 1}) {
+				t.Errorf("got slot %v, want 1", got)
+			}
+		}},
+		{"self-destruct", func(s *StateDB) { s.Suicide(addr) }, func(t *testing.T, s *StateDB) {
+			if s.HasSuicided(addr) {
+				t.Error("account still self-destructed")
+			}
+			if got := s.GetBalance(addr); !got.Eq(uint256.NewInt(10)) {
+				t.Errorf("got balance %v, want 10", got)
+			}
+		}},
+		{"log", func(s *StateDB) { s.AddLog(&Log{Address: addr}) }, func(t *testing.T, s *StateDB) {
+			if got := len(s.Logs()); got != 0 {
+				t.Errorf("got %d logs, want 0", got)
+			}
+		}},
+		{"refund", func(s *StateDB) { s.AddRefund(100) }, func(t *testing.T, s *StateDB) {
+			if got := s.GetRefund(); got != 0 {
+				t.Errorf("got refund %d, want 0", got)
+			}
+		}},
+		{"access list", func(s *StateDB) { s.AddSlotToAccessList(created, key) }, func(t *testing.T, s *StateDB) {
+			if addrOk, slotOk := s.SlotInAccessList(created, key); addrOk || slotOk {
+				t.Errorf("got address %v and slot %v in the access list", addrOk, slotOk)
+			}
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			state := newTestState(t, GenesisAlloc{
+				addr: {Balance: "10", Nonce: "7", Code: code{Bin: "01"}, Storage: map[Hash]Hash{key: {31: 1}}},
+			})
+			snapshot := state.Snapshot()
+			test.change(state)
+			state.RevertToSnapshot(snapshot)
+			test.check(t, state)
+		})
+	}
+}
+
+func TestNestedSnapshots(t *testing.T) {
+	addr := HexToAddress("0x2000000000000000000000000000000000000002")
+	state := NewStateDB()
+	state.AddBalance(addr, uint256.NewInt(1))
+	outer := state.Snapshot()
+	state.AddBalance(addr, uint256.NewInt(2))
+	inner := state.Snapshot()
+	state.AddBalance(addr, uint256.NewInt(4))
+
+	state.RevertToSnapshot(inner)
+	if got := state.GetBalance(addr); !got.Eq(uint256.NewInt(3)) {
+		t.Errorf("got balance %v after reverting the inner snapshot, want 3", got)
+	}
+	state.RevertToSnapshot(outer)
+	if got := state.GetBalance(addr); !got.Eq(uint256.NewInt(1)) {
+		t.Errorf("got balance %v after reverting the outer snapshot, want 1", got)
+	}
+}
+
+func TestFinalise(t *testing.T) {
+	var (
+		addr  = HexToAddress("0x2000000000000000000000000000000000000002")
+		empty = HexToAddress("0x3000000000000000000000000000000000000003")
+		key   = Hash{31: 1}
+	)
+	state := newTestState(t, GenesisAlloc{addr: {Balance: "10", Storage: map[Hash]Hash{key: {31: 1}}}})
+	state.SetState(addr, key, Hash{31: 2})
+	// Touching an empty account deletes it (EIP-161)
+	state.AddBalance(empty, new(uint256.Int))
+	state.AddRefund(100)
+	state.AddLog(&Log{Address: addr})
+	state.AddAddressToAccessList(addr)
+	state.Finalise()
+
+	if got := state.GetCommittedState(addr, key); got != (Hash{31: 2}) {
+		t.Errorf("got committed slot %v, want 2", got)
+	}
+	if state.Exist(empty) {
+		t.Error("touched empty account still exists")
+	}
+	if state.GetRefund() != 0 || len(state.Logs()) != 0 || state.AddressInAccessList(addr) {
+		t.Error("refund, logs or access list kept after the transaction")
+	}
+	// Reverting to the start of the transaction is no longer possible
+	if state.Snapshot() != 0 {
+		t.Errorf("got journal of %d entries, want 0", state.Snapshot())
+	}
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/big"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/holiman/uint256"
+)
+
+// StateTest is a test of the ethereum/tests GeneralStateTests, which the
+// VMTests are part of. It applies a matrix of transactions to a pre-state,
+// and commits to the resulting state root and logs of each, per fork.
+type StateTest struct {
+	Env         stEnv                    `json:"env"`
+	Pre         map[Address]stAccount    `json:"pre"`
+	Transaction stTransaction            `json:"transaction"`
+	Post        map[string][]stPostState `json:"post"`
+}
+
+type stEnv struct {
+	Coinbase   Address `json:"currentCoinbase"`
+	Difficulty string  `json:"currentDifficulty"`
+	Random     string  `json:"currentRandom"`
+	GasLimit   string  `json:"currentGasLimit"`
+	Number     string  `json:"currentNumber"`
+	Timestamp  string  `json:"currentTimestamp"`
+	BaseFee    string  `json:"currentBaseFee"`
+
+	ExcessBlobGas string `json:"currentExcessBlobGas"`
+}
+
+type stAccount struct {
+	Balance string        `json:"balance"`
+	Nonce   string        `json:"nonce"`
+	Code    string        `json:"code"`
+	Storage map[Hash]Hash `json:"storage"`
+}
+
+// stTransaction is the transaction matrix, a post-state picks one entry of
+// each of data, gasLimit and value by index.
+type stTransaction struct {
+	Data                 []string      `json:"data"`
+	AccessLists          []*AccessList `json:"accessLists"`
+	GasLimit             []string      `json:"gasLimit"`
+	Value                []string      `json:"value"`
+	GasPrice             string        `json:"gasPrice"`
+	MaxFeePerGas         string        `json:"maxFeePerGas"`
+	MaxPriorityFeePerGas string        `json:"maxPriorityFeePerGas"`
+	Nonce                string        `json:"nonce"`
+	To                   string        `json:"to"`
+	Sender               *Address      `json:"sender"`
+	SecretKey            string        `json:"secretKey"`
+	BlobVersionedHashes  []Hash        `json:"blobVersionedHashes"`
+	MaxFeePerBlobGas     string        `json:"maxFeePerBlobGas"`
+}
+
+type stPostState struct {
+	Root    Hash `json:"hash"`
+	Logs    Hash `json:"logs"`
+	Indexes struct {
+		Data  int `json:"data"`
+		Gas   int `json:"gas"`
+		Value int `json:"value"`
+	} `json:"indexes"`
+	ExpectException string `json:"expectException"`
+}
+
+// StateSubtest selects a post-state of a StateTest.
+type StateSubtest struct {
+	Name  string
+	Fork  string
+	Index int
+}
+
+// LoadStateTests reads the state tests in path, a fixture file or a
+// directory searched for them.
+func LoadStateTests(path string) (map[string]*StateTest, error) {
+	tests := make(map[string]*StateTest)
+	err := filepath.WalkDir(path, func(file string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || filepath.Ext(file) != ".json" {
+			return err
+		}
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		var fixtures map[string]*StateTest
+		if err := json.Unmarshal(content, &fixtures); err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+		for name, test := range fixtures {
+			tests[name] = test
+		}
+		return nil
+	})
+	return tests, err
+}
+
+// Subtests returns the post-states of the test for fork.
+func (t *StateTest) Subtests(name, fork string) []StateSubtest {
+	var subtests []StateSubtest
+	for i := range t.Post[fork] {
+		subtests = append(subtests, StateSubtest{Name: name, Fork: fork, Index: i})
+	}
+	return subtests
+}
+
+func (s StateSubtest) String() string {
+	return fmt.Sprintf("%s/%s/%d", s.Name, s.Fork, s.Index)
+}
+
+// alloc returns the pre-state of the test.
+func (t *StateTest) alloc() GenesisAlloc {
+	alloc := make(GenesisAlloc, len(t.Pre))
+	for addr, account := range t.Pre {
+		alloc[addr] = GenesisAccount{
+			Balance: account.Balance,
+			Nonce:   account.Nonce,
+			Code:    code{Bin: strings.TrimPrefix(account.Code, "0x")},
+			Storage: account.Storage,
+		}
+	}
+	return alloc
+}
+
+// block returns the block the test runs in.
+func (t *StateTest) block() (*Block, error) {
+	env := t.Env
+	block := &Block{
+		Coinbase:   env.Coinbase,
+		Timestamp:  env.Timestamp,
+		Number:     env.Number,
+		Difficulty: env.Difficulty,
+		GasLimit:   env.GasLimit,
+		BaseFee:    env.BaseFee,
+		ChainId:    "1",
+		GetHash:    vmTestBlockHash,
+
+		ExcessBlobGas: env.ExcessBlobGas,
+	}
+	// After the merge, DIFFICULTY returns the randomness of the beacon chain
+	if env.Random != "" {
+		block.Difficulty = env.Random
+	}
+	if _, err := block.values(); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+// vmTestBlockHash is the hash of the blocks preceding the test's block.
+func vmTestBlockHash(n uint64) Hash {
+	return Keccak256Hash([]byte(new(big.Int).SetUint64(n).String()))
+}
+
+// transaction returns the transaction of the post-state.
+func (t *StateTest) transaction(post *stPostState) (*Transaction, error) {
+	stTx := t.Transaction
+	if post.Indexes.Data >= len(stTx.Data) {
+		return nil, fmt.Errorf("tx data index %d out of bounds", post.Indexes.Data)
+	}
+	if post.Indexes.Gas >= len(stTx.GasLimit) {
+		return nil, fmt.Errorf("tx gas limit index %d out of bounds", post.Indexes.Gas)
+	}
+	if post.Indexes.Value >= len(stTx.Value) {
+		return nil, fmt.Errorf("tx value index %d out of bounds", post.Indexes.Value)
+	}
+
+	tx := &Transaction{
+		Nonce:                stTx.Nonce,
+		Gas:                  stTx.GasLimit[post.Indexes.Gas],
+		GasPrice:             stTx.GasPrice,
+		MaxFeePerGas:         stTx.MaxFeePerGas,
+		MaxPriorityFeePerGas: stTx.MaxPriorityFeePerGas,
+		Value:                stTx.Value[post.Indexes.Value],
+		Data:                 strings.TrimPrefix(stTx.Data[post.Indexes.Data], "0x"),
+		BlobVersionedHashes:  stTx.BlobVersionedHashes,
+		MaxFeePerBlobGas:     stTx.MaxFeePerBlobGas,
+	}
+	if post.Indexes.Data < len(stTx.AccessLists) && stTx.AccessLists[post.Indexes.Data] != nil {
+		tx.AccessList = *stTx.AccessLists[post.Indexes.Data]
+	}
+	if stTx.To != "" {
+		var to Address
+		if err := to.UnmarshalText([]byte(stTx.To)); err != nil {
+			return nil, err
+		}
+		tx.To = &to
+	}
+	switch {
+	case stTx.Sender != nil:
+		tx.From = *stTx.Sender
+	case stTx.SecretKey != "":
+		key, err := crypto.HexToECDSA(strings.TrimPrefix(stTx.SecretKey, "0x"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid secret key: %v", err)
+		}
+		tx.From = Address(crypto.PubkeyToAddress(key.PublicKey))
+	default:
+		return nil, errors.New("transaction has no sender")
+	}
+	return tx, nil
+}
+
+// Run applies the transaction of the subtest to the pre-state and compares
+// the state root and logs to the post-state. The execution is observed by
+// the tracer of config, if any.
+func (t *StateTest) Run(subtest StateSubtest, config Config) *TestResult {
+	result := &TestResult{Test: &TestCase{Name: subtest.String()}}
+	runProtected(result, func() { result.checkState(t, subtest, config) })
+	return result
+}
+
+func (r *TestResult) checkState(t *StateTest, subtest StateSubtest, config Config) {
+	posts := t.Post[subtest.Fork]
+	if subtest.Index >= len(posts) {
+		r.Err = fmt.Errorf("no post-state %d for fork %s", subtest.Index, subtest.Fork)
+		return
+	}
+	post := &posts[subtest.Index]
+	tx, err := t.transaction(post)
+	if err != nil {
+		r.Err = err
+		return
+	}
+	block, err := t.block()
+	if err != nil {
+		r.Err = err
+		return
+	}
+	r.Test.Tx, r.Test.Block, r.Test.State = *tx, *block, t.alloc()
+
+	state, err := NewStateDBFromAlloc(r.Test.State)
+	if err != nil {
+		r.Err = fmt.Errorf("invalid state: %v", err)
+		return
+	}
+	config, locator := locateFailures(config)
+	receipt, err := ApplyTransaction(state, block, tx, config)
+	if locator != nil {
+		r.FailedAt = locator.failures
+	}
+	switch {
+	case err != nil && post.ExpectException == "":
+		r.Failures = append(r.Failures, fmt.Sprintf("Unexpected invalid transaction\nGot: %v", err))
+	case err == nil && post.ExpectException != "":
+		r.Failures = append(r.Failures, fmt.Sprintf("Expected invalid transaction\nExpected: %v", post.ExpectException))
+	}
+	var logs []*Log
+	if receipt != nil {
+		logs = receipt.Logs
+		r.Return = fmt.Sprintf("%x", receipt.ReturnData)
+		r.Success = receipt.Status == ReceiptStatusSuccessful
+		r.GasUsed, r.Logs, r.ExecErr = receipt.GasUsed, receipt.Logs, receipt.Err
+		r.RevertReason = revertReason(receipt.Err, receipt.ReturnData, config.ABI)
+	}
+	// The coinbase is touched even when it earns nothing, so an empty
+	// coinbase is removed
+	state.AddBalance(block.Coinbase, new(uint256.Int))
+	state.Finalise()
+
+	root, err := state.IntermediateRoot()
+	if err != nil {
+		r.Err = err
+		return
+	}
+	if root != post.Root {
+		r.Failures = append(r.Failures, fmt.Sprintf("State root mismatch\nExpected: %v\nGot: %v", post.Root, root))
+	}
+	logsHash, err := LogsHash(logs)
+	if err != nil {
+		r.Err = err
+		return
+	}
+	if logsHash != post.Logs {
+		r.Failures = append(r.Failures, fmt.Sprintf("Logs hash mismatch\nExpected: %v\nGot: %v", post.Logs, logsHash))
+	}
+}
+
+// runStateTests runs the subtests for fork of the state tests in paths
+// whose name matches filter, a nil filter matching all.
+func runStateTests(w io.Writer, paths []string, fork string, filter *regexp.Regexp, config Config) ([]*TestResult, error) {
+	tests := make(map[string]*StateTest)
+	for _, path := range paths {
+		loaded, err := LoadStateTests(path)
+		if err != nil {
+			return nil, err
+		}
+		for name, test := range loaded {
+			tests[name] = test
+		}
+	}
+	names := make([]string, 0, len(tests))
+	for name := range tests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var subtests []StateSubtest
+	for _, name := range names {
+		for _, subtest := range tests[name].Subtests(name, fork) {
+			if filter == nil || filter.MatchString(subtest.String()) {
+				subtests = append(subtests, subtest)
+			}
+		}
+	}
+	subtestNames := make([]string, len(subtests))
+	for i, subtest := range subtests {
+		subtestNames[i] = subtest.String()
+	}
+	return runCases(w, subtestNames, func(i int) *TestResult {
+		return tests[subtests[i].Name].Run(subtests[i], config)
+	}), nil
+}
+
+// stateTestFork is the only fork whose rules the EVM implements, the
+// post-states of other forks would be checked against the wrong rules.
+const stateTestFork = "Cancun"
+
+// stateTestCommand runs the GeneralStateTests fixtures found in the files
+// and directories given as arguments.
+func stateTestCommand(args []string) int {
+	fs := flag.NewFlagSet("statetest", flag.ContinueOnError)
+	fork := fs.String("fork", stateTestFork, "run the post-states of `fork`, only "+stateTestFork+" is supported")
+	flags := newRunFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: statetest [flags] path...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	if *fork != stateTestFork {
+		fmt.Fprintf(os.Stderr, "Unsupported fork %q, only %s is implemented\n", *fork, stateTestFork)
+		return 2
+	}
+	return flags.runAndReport(*fork, func(w io.Writer, filter *regexp.Regexp, config Config) ([]*TestResult, error) {
+		return runStateTests(w, fs.Args(), *fork, filter, config)
+	})
+}
@@ -0,0 +1,11 @@
+package main
+
+import "testing"
+
+func TestStateTestCommandRejectsOtherForks(t *testing.T) {
+	for _, fork := range []string{"London", "Shanghai", "Prague"} {
+		if code := stateTestCommand([]string{"-fork", fork, "testdata"}); code != 2 {
+			t.Errorf("%v: got exit code %d, want 2", fork, code)
+		}
+	}
+}
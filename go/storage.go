@@ -1,21 +1,24 @@
 package main
 
 type storageStruct struct {
-	// key  [32]byte
-	// data []byte
-	store map[[32]byte][]byte
+	store map[Hash]Hash
 }
 
 func newStorage() *storageStruct {
 	return &storageStruct{
-		store: make(map[[32]byte][]byte),
+		store: make(map[Hash]Hash),
 	}
 }
 
-func (s *storageStruct) set(key [32]byte, data []byte) {
-	s.store[key] = data
+// set writes value to the slot key, a zero value deletes the slot.
+func (s *storageStruct) set(key Hash, value Hash) {
+	if value == (Hash{}) {
+		delete(s.store, key)
+		return
+	}
+	s.store[key] = value
 }
 
-func (s *storageStruct) get(key [32]byte) []byte {
+func (s *storageStruct) get(key Hash) Hash {
 	return s.store[key]
 }
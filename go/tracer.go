@@ -0,0 +1,118 @@
+package main
+
+import (
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/holiman/uint256"
+)
+
+// Tracer is called by the VM as it executes, to observe the execution of a
+// transaction. It is set in the Config of the VM.
+//
+// CaptureTxStart and CaptureTxEnd are called around the whole transaction,
+// the end once the gas is refunded and the state is finalised. The outermost
+// frame is reported by CaptureStart and CaptureEnd, the frames of the calls
+// and creations it makes by CaptureEnter and CaptureExit.
+// CaptureState is called before each opcode is executed, with the gas left
+// before the opcode and its cost. Opcodes that fail before they execute, for
+// lack of gas or stack items, are reported by CaptureState with the error,
+// while the failure of an opcode that was executed is reported by
+// CaptureFault.
+//
+// The scope is the context of the frame running the opcode, tracers must not
+// modify it.
+type Tracer interface {
+	CaptureTxStart(gasLimit uint64)
+	CaptureTxEnd(restGas uint64)
+	CaptureStart(vm *VM, from Address, to Address, create bool, input []byte, gas uint64, value *uint256.Int)
+	CaptureState(pc uint64, op OpCode, gas, cost uint64, scope *executionContext, rData []byte, depth int, err error)
+	CaptureFault(pc uint64, op OpCode, gas, cost uint64, scope *executionContext, depth int, err error)
+	CaptureEnd(output []byte, gasUsed uint64, err error)
+	CaptureEnter(typ OpCode, from Address, to Address, input []byte, gas uint64, value *uint256.Int)
+	CaptureExit(output []byte, gasUsed uint64, err error)
+}
+
+// Config are the options of the VM.
+type Config struct {
+	// Tracer observes the execution, when set
+	Tracer Tracer
+	// Sources maps the code executed to the Solidity sources it was
+	// compiled from, for tracers and test results to report source positions
+	Sources *SourceMaps
+	// ABI declares the custom errors revert data is decoded with, along with
+	// the errors and panics of solidity
+	ABI *abi.ABI
+	// Unmetered runs the code without charging gas or checking balances, as
+	// the test cases of scripts/evm.yaml are written. Value sent beyond the
+	// balance of the sender is created.
+	Unmetered bool
+}
+
+// captureFrame reports a call frame of the given type to the tracer, as the
+// start of the transaction when no frame is running yet. It returns the
+// function that reports the end of the frame, given its output and the gas
+// it has left.
+func (vm *VM) captureFrame(typ OpCode, from, to Address, input []byte, gas uint64, value *uint256.Int) func(output []byte, gasLeft uint64, err error) {
+	tracer := vm.Config.Tracer
+	if vm.depth == 0 {
+		tracer.CaptureStart(vm, from, to, typ == CREATE || typ == CREATE2, input, gas, value)
+		return func(output []byte, gasLeft uint64, err error) {
+			tracer.CaptureEnd(output, gas-gasLeft, err)
+		}
+	}
+	tracer.CaptureEnter(typ, from, to, input, gas, value)
+	return func(output []byte, gasLeft uint64, err error) {
+		tracer.CaptureExit(output, gas-gasLeft, err)
+	}
+}
+
+// multiTracer is a Tracer passing every call on to each of its tracers, in
+// order.
+type multiTracer []Tracer
+
+func (m multiTracer) CaptureTxStart(gasLimit uint64) {
+	for _, t := range m {
+		t.CaptureTxStart(gasLimit)
+	}
+}
+
+func (m multiTracer) CaptureTxEnd(restGas uint64) {
+	for _, t := range m {
+		t.CaptureTxEnd(restGas)
+	}
+}
+
+func (m multiTracer) CaptureStart(vm *VM, from Address, to Address, create bool, input []byte, gas uint64, value *uint256.Int) {
+	for _, t := range m {
+		t.CaptureStart(vm, from, to, create, input, gas, value)
+	}
+}
+
+func (m multiTracer) CaptureState(pc uint64, op OpCode, gas, cost uint64, scope *executionContext, rData []byte, depth int, err error) {
+	for _, t := range m {
+		t.CaptureState(pc, op, gas, cost, scope, rData, depth, err)
+	}
+}
+
+func (m multiTracer) CaptureFault(pc uint64, op OpCode, gas, cost uint64, scope *executionContext, depth int, err error) {
+	for _, t := range m {
+		t.CaptureFault(pc, op, gas, cost, scope, depth, err)
+	}
+}
+
+func (m multiTracer) CaptureEnd(output []byte, gasUsed uint64, err error) {
+	for _, t := range m {
+		t.CaptureEnd(output, gasUsed, err)
+	}
+}
+
+func (m multiTracer) CaptureEnter(typ OpCode, from Address, to Address, input []byte, gas uint64, value *uint256.Int) {
+	for _, t := range m {
+		t.CaptureEnter(typ, from, to, input, gas, value)
+	}
+}
+
+func (m multiTracer) CaptureExit(output []byte, gasUsed uint64, err error) {
+	for _, t := range m {
+		t.CaptureExit(output, gasUsed, err)
+	}
+}
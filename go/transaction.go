@@ -0,0 +1,336 @@
+// Copyright 2014 The go-ethereum Authors
+// This file is derived from core/state_transition.go and core/error.go of
+// the go-ethereum library, and modified for this EVM.
+//
+// This file is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This file is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this file. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/holiman/uint256"
+)
+
+// List of transaction validation errors. A transaction failing one of these
+// checks is invalid and leaves the state untouched.
+var (
+	ErrNonceTooLow             = errors.New("nonce too low")
+	ErrNonceTooHigh            = errors.New("nonce too high")
+	ErrNonceMax                = errors.New("nonce has max value")
+	ErrGasLimitReached         = errors.New("gas limit reached")
+	ErrInsufficientFunds       = errors.New("insufficient funds for gas * price + value")
+	ErrIntrinsicGas            = errors.New("intrinsic gas too low")
+	ErrSenderNoEOA             = errors.New("sender not an eoa")
+	ErrMaxInitCodeSizeExceeded = errors.New("max initcode size exceeded")
+	ErrBlobTxCreate            = errors.New("blob transaction of type create")
+	ErrMissingBlobHashes       = errors.New("blob transaction missing blob hashes")
+	ErrTooManyBlobs            = errors.New("blob transaction has too many blobs")
+)
+
+const (
+	// ReceiptStatusFailed is the status code of a transaction if execution failed.
+	ReceiptStatusFailed = uint64(0)
+
+	// ReceiptStatusSuccessful is the status code of a transaction if execution succeeded.
+	ReceiptStatusSuccessful = uint64(1)
+)
+
+// AccessTuple is the element type of an access list.
+type AccessTuple struct {
+	Address     Address `json:"address"`
+	StorageKeys []Hash  `json:"storageKeys"`
+}
+
+// AccessList is an EIP-2930 access list.
+type AccessList []AccessTuple
+
+// StorageKeys returns the total number of storage keys in the access list.
+func (al AccessList) StorageKeys() int {
+	sum := 0
+	for _, tuple := range al {
+		sum += len(tuple.StorageKeys)
+	}
+	return sum
+}
+
+// Receipt is the outcome of applying a transaction to the state.
+type Receipt struct {
+	Status          uint64   `json:"status"`
+	GasUsed         uint64   `json:"gasUsed"`
+	Logs            []*Log   `json:"logs"`
+	ContractAddress *Address `json:"contractAddress"`
+	ReturnData      []byte   `json:"returnData"`
+	// BlobGasUsed is the blob gas bought for the blobs of the transaction
+	BlobGasUsed uint64 `json:"blobGasUsed,omitempty"`
+
+	// Err is the error that aborted the execution, if any
+	Err error `json:"-"`
+}
+
+// IntrinsicGas computes the 'intrinsic gas' for a transaction with the given
+// data, the gas paid before any code runs.
+func IntrinsicGas(data []byte, accessList AccessList, isContractCreation bool) (uint64, error) {
+	// Set the starting gas for the raw transaction
+	var gas uint64
+	if isContractCreation {
+		gas = TxGasContractCreation
+	} else {
+		gas = TxGas
+	}
+	dataLen := uint64(len(data))
+	// Bump the required gas by the amount of transactional data
+	if dataLen > 0 {
+		// Zero and non-zero bytes are priced differently
+		var nz uint64
+		for _, byt := range data {
+			if byt != 0 {
+				nz++
+			}
+		}
+		// Make sure we don't exceed uint64 for all data combinations
+		if (math.MaxUint64-gas)/TxDataNonZeroGasEIP2028 < nz {
+			return 0, ErrGasUintOverflow
+		}
+		gas += nz * TxDataNonZeroGasEIP2028
+
+		z := dataLen - nz
+		if (math.MaxUint64-gas)/TxDataZeroGas < z {
+			return 0, ErrGasUintOverflow
+		}
+		gas += z * TxDataZeroGas
+
+		if isContractCreation {
+			lenWords := toWordSize(dataLen)
+			if (math.MaxUint64-gas)/InitCodeWordGas < lenWords {
+				return 0, ErrGasUintOverflow
+			}
+			gas += lenWords * InitCodeWordGas
+		}
+	}
+	if accessList != nil {
+		gas += uint64(len(accessList)) * TxAccessListAddressGas
+		gas += uint64(accessList.StorageKeys()) * TxAccessListStorageKeyGas
+	}
+	return gas, nil
+}
+
+// ApplyTransaction executes tx on top of state in the context of block. It
+// checks the sender's nonce and funds, buys the gas up front, charges the
+// intrinsic gas, transfers the value, runs the code, refunds the unused gas
+// and pays the tip to the coinbase, burning the base fee. The blob gas of
+// EIP-4844 transactions is bought along with the gas, and burnt.
+//
+// An error is returned when the transaction is invalid, in which case the
+// state is left untouched. A transaction whose execution fails is valid: it
+// is reported through the receipt status. The execution is observed by the
+// tracer of config, if any.
+func ApplyTransaction(state *StateDB, block *Block, tx *Transaction, config Config) (*Receipt, error) {
+	nonce, err := parseUint256(tx.Nonce)
+	if err != nil {
+		return nil, fmt.Errorf("invalid nonce: %v", err)
+	}
+	gasLimit, err := parseUint256(tx.Gas)
+	if err != nil || !gasLimit.IsUint64() {
+		return nil, fmt.Errorf("invalid gas limit %q", tx.Gas)
+	}
+	feeCap, tip, err := tx.fees()
+	if err != nil {
+		return nil, err
+	}
+	value, err := parseUint256(tx.Value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value: %v", err)
+	}
+	data, err := hex.DecodeString(tx.Data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid data: %v", err)
+	}
+	blobFeeCap, err := parseUint256(tx.MaxFeePerBlobGas)
+	if err != nil {
+		return nil, fmt.Errorf("invalid max fee per blob gas: %v", err)
+	}
+	msg := *tx
+	msg.Origin = tx.From
+	vm, err := NewVM(block, &msg, state, config)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		baseFee       = vm.blockValues.BaseFee
+		blockGasLimit = vm.blockValues.GasLimit
+		gasPrice      = vm.gasPrice
+		blobBaseFee   = vm.blockValues.BlobBaseFee
+		blobGas       = new(uint256.Int).SetUint64(tx.BlobGas())
+	)
+	gas := gasLimit.Uint64()
+	contractCreation := tx.To == nil
+
+	// Make sure this transaction's nonce is correct.
+	stNonce := state.GetNonce(tx.From)
+	if !nonce.IsUint64() || nonce.Uint64() > stNonce {
+		return nil, fmt.Errorf("%w: address %v, tx: %v state: %d", ErrNonceTooHigh, tx.From, nonce, stNonce)
+	} else if nonce.Uint64() < stNonce {
+		return nil, fmt.Errorf("%w: address %v, tx: %d state: %d", ErrNonceTooLow, tx.From, nonce.Uint64(), stNonce)
+	} else if stNonce+1 < stNonce {
+		return nil, fmt.Errorf("%w: address %v, nonce: %d", ErrNonceMax, tx.From, stNonce)
+	}
+	// Make sure the sender is an EOA (EIP-3607)
+	if len(state.GetCode(tx.From)) != 0 {
+		return nil, fmt.Errorf("%w: address %v", ErrSenderNoEOA, tx.From)
+	}
+	// A block without a gas limit doesn't restrict the transaction
+	if !blockGasLimit.IsZero() && gasLimit.Gt(blockGasLimit) {
+		return nil, fmt.Errorf("%w: have %d, want %d", ErrGasLimitReached, gas, blockGasLimit)
+	}
+
+	// Make sure the transaction's fees are consistent and that it pays at
+	// least the base fee of the block (EIP-1559).
+	if tip.Gt(feeCap) {
+		return nil, fmt.Errorf("%w: address %v, maxPriorityFeePerGas: %v, maxFeePerGas: %v", ErrTipAboveFeeCap, tx.From, tip, feeCap)
+	}
+	if feeCap.Lt(baseFee) {
+		return nil, fmt.Errorf("%w: address %v, maxFeePerGas: %v baseFee: %v", ErrFeeCapTooLow, tx.From, feeCap, baseFee)
+	}
+
+	// Make sure a blob transaction calls an account with some blobs of the
+	// current version, and pays at least the blob base fee (EIP-4844).
+	if tx.IsBlob() {
+		if contractCreation {
+			return nil, fmt.Errorf("%w: address %v", ErrBlobTxCreate, tx.From)
+		}
+		if len(tx.BlobVersionedHashes) == 0 {
+			return nil, fmt.Errorf("%w: address %v", ErrMissingBlobHashes, tx.From)
+		}
+		if tx.BlobGas() > MaxBlobGasPerBlock {
+			return nil, fmt.Errorf("%w: address %v, blobs: %d", ErrTooManyBlobs, tx.From, len(tx.BlobVersionedHashes))
+		}
+		for i, hash := range tx.BlobVersionedHashes {
+			if hash[0] != blobCommitmentVersionKZG {
+				return nil, fmt.Errorf("blob %d has invalid hash version", i)
+			}
+		}
+		if blobFeeCap.Lt(blobBaseFee) {
+			return nil, fmt.Errorf("%w: address %v, maxFeePerBlobGas: %v, blobBaseFee: %v", ErrBlobFeeCapTooLow, tx.From, blobFeeCap, blobBaseFee)
+		}
+	}
+
+	// Make sure the sender can pay for the gas, the blob gas and the value
+	// up front. The balance has to cover the fee caps, only the effective
+	// prices are charged.
+	mgval, overflow := new(uint256.Int).MulOverflow(gasLimit, gasPrice)
+	if overflow {
+		return nil, fmt.Errorf("%w: address %v", ErrInsufficientFunds, tx.From)
+	}
+	blobFee, overflow := new(uint256.Int).MulOverflow(blobGas, blobBaseFee)
+	if overflow {
+		return nil, fmt.Errorf("%w: address %v", ErrInsufficientFunds, tx.From)
+	}
+	if mgval, overflow = mgval.AddOverflow(mgval, blobFee); overflow {
+		return nil, fmt.Errorf("%w: address %v", ErrInsufficientFunds, tx.From)
+	}
+	balanceCheck, overflow := new(uint256.Int).MulOverflow(gasLimit, feeCap)
+	if overflow {
+		return nil, fmt.Errorf("%w: address %v", ErrInsufficientFunds, tx.From)
+	}
+	blobBalanceCheck, overflow := new(uint256.Int).MulOverflow(blobGas, blobFeeCap)
+	if overflow {
+		return nil, fmt.Errorf("%w: address %v", ErrInsufficientFunds, tx.From)
+	}
+	if balanceCheck, overflow = balanceCheck.AddOverflow(balanceCheck, blobBalanceCheck); overflow {
+		return nil, fmt.Errorf("%w: address %v", ErrInsufficientFunds, tx.From)
+	}
+	balanceCheck, overflow = balanceCheck.AddOverflow(balanceCheck, value)
+	if overflow {
+		return nil, fmt.Errorf("%w: address %v", ErrInsufficientFunds, tx.From)
+	}
+	if have := state.GetBalance(tx.From); have.Lt(balanceCheck) {
+		return nil, fmt.Errorf("%w: address %v have %v want %v", ErrInsufficientFunds, tx.From, have, balanceCheck)
+	}
+
+	// Check clauses 4-5, subtract intrinsic gas if everything is correct
+	intrinsicGas, err := IntrinsicGas(data, tx.AccessList, contractCreation)
+	if err != nil {
+		return nil, err
+	}
+	if gas < intrinsicGas {
+		return nil, fmt.Errorf("%w: have %d, want %d", ErrIntrinsicGas, gas, intrinsicGas)
+	}
+	// Check whether the init code size has been exceeded.
+	if contractCreation && len(data) > MaxInitCodeSize {
+		return nil, fmt.Errorf("%w: code size %v limit %v", ErrMaxInitCodeSizeExceeded, len(data), MaxInitCodeSize)
+	}
+
+	// The transaction is valid from here on, buy the gas.
+	state.SubBalance(tx.From, mgval)
+	gasLeft := gas - intrinsicGas
+
+	state.PrepareAccessList(tx.From, block.Coinbase, tx.To, PrecompiledAddresses, tx.AccessList)
+
+	if config.Tracer != nil {
+		config.Tracer.CaptureTxStart(gas)
+	}
+
+	var (
+		ret     []byte
+		vmerr   error
+		receipt = new(Receipt)
+	)
+	if contractCreation {
+		var address Address
+		ret, address, gasLeft, vmerr = vm.Create(tx.From, data, gasLeft, value)
+		receipt.ContractAddress = &address
+	} else {
+		// Increment the nonce for the next transaction
+		state.SetNonce(tx.From, stNonce+1)
+		ret, gasLeft, vmerr = vm.Call(tx.From, *tx.To, data, gasLeft, value)
+	}
+
+	// Apply refund counter, capped to a refund quotient (EIP-3529)
+	refund := (gas - gasLeft) / RefundQuotientEIP3529
+	if refund > state.GetRefund() {
+		refund = state.GetRefund()
+	}
+	gasLeft += refund
+
+	// Return ETH for remaining gas, exchanged at the original rate.
+	remaining := new(uint256.Int).Mul(new(uint256.Int).SetUint64(gasLeft), gasPrice)
+	state.AddBalance(tx.From, remaining)
+
+	// The coinbase only receives the tip, the base fee is burnt.
+	gasUsed := gas - gasLeft
+	effectiveTip := new(uint256.Int).Sub(gasPrice, baseFee)
+	fee := new(uint256.Int).Mul(new(uint256.Int).SetUint64(gasUsed), effectiveTip)
+	state.AddBalance(block.Coinbase, fee)
+
+	receipt.GasUsed = gasUsed
+	receipt.BlobGasUsed = tx.BlobGas()
+	receipt.ReturnData = ret
+	receipt.Err = vmerr
+	if vmerr == nil {
+		receipt.Status = ReceiptStatusSuccessful
+		receipt.Logs = state.Logs()
+	} else {
+		receipt.Status = ReceiptStatusFailed
+	}
+	state.Finalise()
+	if config.Tracer != nil {
+		config.Tracer.CaptureTxEnd(gas - gasUsed)
+	}
+
+	return receipt, nil
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/holiman/uint256"
+)
+
+var (
+	testSender    = HexToAddress("0x1000000000000000000000000000000000000001")
+	testRecipient = HexToAddress("0x2000000000000000000000000000000000000002")
+	testCoinbase  = HexToAddress("0xc014ba5e00000000000000000000000000000000")
+)
+
+// blobHashes returns n versioned hashes of the current version.
+func blobHashes(n int) []Hash {
+	hashes := make([]Hash, n)
+	for i := range hashes {
+		hashes[i][0] = blobCommitmentVersionKZG
+		hashes[i][31] = byte(i)
+	}
+	return hashes
+}
+
+func TestIntrinsicGas(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       string
+		accessList AccessList
+		create     bool
+		gas        uint64
+	}{
+		{name: "call", gas: TxGas},
+		{name: "create", create: true, gas: TxGasContractCreation},
+		// 2 zero and 2 non-zero bytes
+		{name: "call data", data: "00010002", gas: 21000 + 2*4 + 2*16},
+		// 2 non-zero and 31 zero bytes, 2 words of init code
+		{name: "init code", data: "ff" + strings.Repeat("00", 31) + "ff", create: true, gas: 53000 + 2*16 + 31*4 + 2*2},
+		{name: "access list", accessList: AccessList{
+			{Address: testRecipient, StorageKeys: []Hash{{}, {31: 1}}},
+			{Address: testCoinbase},
+		}, gas: 21000 + 2*2400 + 2*1900},
+	}
+	for _, test := range tests {
+		data, err := hex.DecodeString(test.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gas, err := IntrinsicGas(data, test.accessList, test.create)
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		if gas != test.gas {
+			t.Errorf("%v: got %d, want %d", test.name, gas, test.gas)
+		}
+	}
+}
+
+func TestApplyTransaction(t *testing.T) {
+	// clearSlot clears the slot 0 of the recipient, which holds 1
+	const clearSlot = "PUSH1 0 PUSH1 0 SSTORE"
+	tests := []struct {
+		name string
+		tx   Transaction
+		// nonce and code are those of the sender
+		nonce string
+		code  string
+		// asm is the code of the recipient
+		asm string
+		err error
+		// gasUsed is charged at price, the coinbase receiving the part
+		// above the base fee of 10
+		gasUsed uint64
+		price   uint64
+	}{
+		{name: "legacy", tx: Transaction{Gas: "50000", GasPrice: "15", Value: "1000"}, gasUsed: 21000, price: 15},
+		{name: "dynamic fee", tx: Transaction{Gas: "50000", MaxFeePerGas: "20", MaxPriorityFeePerGas: "3"}, gasUsed: 21000, price: 13},
+		{name: "tip capped by fee cap", tx: Transaction{Gas: "50000", MaxFeePerGas: "12", MaxPriorityFeePerGas: "5"}, gasUsed: 21000, price: 12},
+		{name: "no tip", tx: Transaction{Gas: "50000", GasPrice: "10"}, gasUsed: 21000, price: 10},
+		// 21000 + 2 PUSH1 + a cold SSTORE resetting the slot, 5000, with a
+		// refund of 4800 below the cap of a fifth of the gas used
+		{name: "refund", tx: Transaction{Gas: "50000", GasPrice: "15"}, asm: clearSlot, gasUsed: 26006 - 4800, price: 15},
+		// Clearing, restoring and clearing the slot again costs 21000 + 6
+		// PUSH1 + 5000 + 100 + 2900 and earns 4800 - 4800 + 2800 + 4800,
+		// above the cap of a fifth of the gas used
+		{name: "refund cap", tx: Transaction{Gas: "50000", GasPrice: "15"}, asm: clearSlot + " PUSH1 1 PUSH1 0 SSTORE " + clearSlot, gasUsed: 29018 - 29018/5, price: 15},
+		// A failed execution is valid, and uses up the gas
+		{name: "failed execution", tx: Transaction{Gas: "50000", GasPrice: "15"}, asm: "INVALID", gasUsed: 50000, price: 15},
+
+		{name: "nonce too low", nonce: "1", tx: Transaction{Gas: "50000", GasPrice: "15"}, err: ErrNonceTooLow},
+		{name: "nonce too high", tx: Transaction{Nonce: "1", Gas: "50000", GasPrice: "15"}, err: ErrNonceTooHigh},
+		{name: "sender with code", code: "STOP", tx: Transaction{Gas: "50000", GasPrice: "15"}, err: ErrSenderNoEOA},
+		{name: "above block gas limit", tx: Transaction{Gas: "30000001", GasPrice: "15"}, err: ErrGasLimitReached},
+		{name: "fee cap below base fee", tx: Transaction{Gas: "50000", MaxFeePerGas: "9", MaxPriorityFeePerGas: "1"}, err: ErrFeeCapTooLow},
+		{name: "gas price below base fee", tx: Transaction{Gas: "50000", GasPrice: "9"}, err: ErrFeeCapTooLow},
+		{name: "tip above fee cap", tx: Transaction{Gas: "50000", MaxFeePerGas: "20", MaxPriorityFeePerGas: "21"}, err: ErrTipAboveFeeCap},
+		// The balance covers gas * price + value, but not gas * fee cap + value
+		{name: "funds for the fee cap", tx: Transaction{Gas: "50000", MaxFeePerGas: "21", MaxPriorityFeePerGas: "1"}, err: ErrInsufficientFunds},
+		{name: "funds for the value", tx: Transaction{Gas: "50000", GasPrice: "15", Value: "250001"}, err: ErrInsufficientFunds},
+		{name: "intrinsic gas", tx: Transaction{Gas: "20999", GasPrice: "15"}, err: ErrIntrinsicGas},
+	}
+	const balance = 1000000
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var senderCode []byte
+			if test.code != "" {
+				senderCode = assemble(t, test.code)
+			}
+			state := newTestState(t, GenesisAlloc{
+				testSender: {Balance: "1000000", Nonce: test.nonce, Code: code{Bin: hex.EncodeToString(senderCode)}},
+				testRecipient: {
+					Code:    code{Bin: hex.EncodeToString(assemble(t, test.asm))},
+					Storage: map[Hash]Hash{{}: {31: 1}},
+				},
+			})
+			tx := test.tx
+			tx.From, tx.To = testSender, &testRecipient
+			block := &Block{Coinbase: testCoinbase, BaseFee: "10", GasLimit: "30000000"}
+			receipt, err := ApplyTransaction(state, block, &tx, Config{})
+			if !errors.Is(err, test.err) {
+				t.Fatalf("got error %v, want %v", err, test.err)
+			}
+			if err != nil {
+				if got := state.GetBalance(testSender); !got.Eq(uint256.NewInt(balance)) {
+					t.Errorf("invalid transaction changed the balance to %v", got)
+				}
+				if got := state.GetNonce(testSender); got != mustParseUint256(t, test.nonce).Uint64() {
+					t.Errorf("invalid transaction changed the nonce to %d", got)
+				}
+				return
+			}
+			if receipt.GasUsed != test.gasUsed {
+				t.Errorf("got gas used %d, want %d", receipt.GasUsed, test.gasUsed)
+			}
+			value := mustParseUint256(t, tx.Value).Uint64()
+			if got, want := state.GetBalance(testSender), uint256.NewInt(balance-test.gasUsed*test.price-value); !got.Eq(want) {
+				t.Errorf("got sender balance %v, want %v", got, want)
+			}
+			if got := state.GetBalance(testRecipient); !got.Eq(uint256.NewInt(value)) {
+				t.Errorf("got recipient balance %v, want %d", got, value)
+			}
+			// The coinbase receives the tip, the base fee is burnt
+			if got, want := state.GetBalance(testCoinbase), uint256.NewInt(test.gasUsed*(test.price-10)); !got.Eq(want) {
+				t.Errorf("got coinbase balance %v, want %v", got, want)
+			}
+			if got := state.GetNonce(testSender); got != 1 {
+				t.Errorf("got sender nonce %d, want 1", got)
+			}
+		})
+	}
+}
+
+func TestApplyBlobTransaction(t *testing.T) {
+	tests := []struct {
+		name          string
+		to            *Address
+		hashes        []Hash
+		feeCap        string
+		excessBlobGas string
+		balance       string
+		err           error
+	}{
+		{name: "valid", to: &testRecipient, hashes: blobHashes(2), feeCap: "10"},
+		{name: "create", hashes: blobHashes(1), feeCap: "10", err: ErrBlobTxCreate},
+		{name: "no blobs", to: &testRecipient, hashes: []Hash{}, feeCap: "10", err: ErrMissingBlobHashes},
+		{name: "too many blobs", to: &testRecipient, hashes: blobHashes(7), feeCap: "10", err: ErrTooManyBlobs},
+		{name: "fee cap below blob base fee", to: &testRecipient, hashes: blobHashes(1), feeCap: "22", excessBlobGas: "10485760", err: ErrBlobFeeCapTooLow},
+		// The balance pays for the blob base fee, but not the fee cap
+		{name: "funds for the fee cap", to: &testRecipient, hashes: blobHashes(1), feeCap: "10", balance: "152072", err: ErrInsufficientFunds},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			balance := test.balance
+			if balance == "" {
+				balance = "10000000"
+			}
+			state := newTestState(t, GenesisAlloc{testSender: {Balance: balance}})
+			tx := &Transaction{
+				To:                  test.to,
+				From:                testSender,
+				Gas:                 "21000",
+				GasPrice:            "1",
+				BlobVersionedHashes: test.hashes,
+				MaxFeePerBlobGas:    test.feeCap,
+			}
+			block := &Block{Coinbase: testCoinbase, ExcessBlobGas: test.excessBlobGas}
+			receipt, err := ApplyTransaction(state, block, tx, Config{})
+			if !errors.Is(err, test.err) {
+				t.Fatalf("got error %v, want %v", err, test.err)
+			}
+			if err != nil {
+				if got, want := state.GetBalance(testSender), mustParseUint256(t, balance); !got.Eq(want) {
+					t.Errorf("invalid transaction changed the balance to %v", got)
+				}
+				return
+			}
+			// The blob gas is bought at the blob base fee, 1, and burnt
+			blobGas := uint64(len(test.hashes)) * BlobTxBlobGasPerBlob
+			if receipt.BlobGasUsed != blobGas {
+				t.Errorf("got blob gas used %d, want %d", receipt.BlobGasUsed, blobGas)
+			}
+			want := uint256.NewInt(10000000 - 21000 - blobGas)
+			if got := state.GetBalance(testSender); !got.Eq(want) {
+				t.Errorf("got sender balance %v, want %v", got, want)
+			}
+			if got := state.GetBalance(testCoinbase); !got.Eq(uint256.NewInt(21000)) {
+				t.Errorf("got coinbase balance %v, want 21000", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/holiman/uint256"
+)
+
+// traceFrame is a call frame of a recorded step: how it was entered and the
+// account whose code it runs.
+type traceFrame struct {
+	typ     OpCode
+	address Address
+}
+
+// traceStep is the state of the execution before an opcode, as recorded by
+// a traceRecorder.
+type traceStep struct {
+	pc, gas, cost uint64
+	op            OpCode
+	depth         int
+	code          []byte
+	stack         []uint256.Int // top first
+	memory        []byte
+	storage       map[Hash]Hash // of the account of the frame
+	returnData    []byte
+	frames        []traceFrame // the outermost first
+	source        string       // the source position, if known
+	err           error
+}
+
+// traceRecorder is a Tracer recording a copy of the state of the execution
+// at every opcode, to replay it in any direction.
+type traceRecorder struct {
+	frames  []traceFrame
+	steps   []traceStep
+	sources *SourceMaps
+}
+
+func (r *traceRecorder) CaptureTxStart(gasLimit uint64) {}
+
+func (r *traceRecorder) CaptureTxEnd(restGas uint64) {}
+
+func (r *traceRecorder) CaptureStart(vm *VM, from Address, to Address, create bool, input []byte, gas uint64, value *uint256.Int) {
+	typ := CALL
+	if create {
+		typ = CREATE
+	}
+	r.frames = []traceFrame{{typ, to}}
+	r.sources = vm.Config.Sources
+}
+
+func (r *traceRecorder) CaptureState(pc uint64, op OpCode, gas, cost uint64, scope *executionContext, rData []byte, depth int, err error) {
+	var source string
+	if pos, ok := r.sources.Position(scope.code, pc); ok {
+		source = pos.String()
+	}
+	r.steps = append(r.steps, traceStep{
+		pc:         pc,
+		gas:        gas,
+		cost:       cost,
+		op:         op,
+		depth:      depth,
+		code:       scope.code,
+		stack:      append([]uint256.Int(nil), scope.stack.data...),
+		memory:     append([]byte(nil), scope.memory.data...),
+		storage:    scope.state.storage(scope.contract.Address),
+		returnData: append([]byte(nil), rData...),
+		frames:     append([]traceFrame(nil), r.frames...),
+		source:     source,
+		err:        err,
+	})
+}
+
+func (r *traceRecorder) CaptureFault(pc uint64, op OpCode, gas, cost uint64, scope *executionContext, depth int, err error) {
+	// The opcode was recorded before it executed
+	r.steps[len(r.steps)-1].err = err
+}
+
+func (r *traceRecorder) CaptureEnd(output []byte, gasUsed uint64, err error) {}
+
+func (r *traceRecorder) CaptureEnter(typ OpCode, from Address, to Address, input []byte, gas uint64, value *uint256.Int) {
+	r.frames = append(r.frames, traceFrame{typ, to})
+}
+
+func (r *traceRecorder) CaptureExit(output []byte, gasUsed uint64, err error) {
+	r.frames = r.frames[:len(r.frames)-1]
+}
+
+// tuiBreakpoint is a breakpoint of the TUI, at a pc of the code of a frame.
+type tuiBreakpoint struct {
+	code string
+	pc   uint64
+}
+
+// tui shows a recorded execution full-screen, moving through its steps
+// forwards and backwards.
+type tui struct {
+	name   string
+	result string
+	steps  []traceStep
+	// current is the index of the step shown
+	current     int
+	breakpoints map[tuiBreakpoint]bool
+	// disassembly caches the disassembly of the codes executed
+	disassembly map[string][]disasmInstruction
+}
+
+const (
+	ansiReset   = "\x1b[0m"
+	ansiBold    = "\x1b[1m"
+	ansiReverse = "\x1b[7m"
+	ansiRed     = "\x1b[31m"
+)
+
+const tuiKeys = "→/l step  ←/h back  n/p step over  c/r continue/reverse to breakpoint  space breakpoint  g/G start/end  q quit"
+
+// runTUI executes code with a traceRecorder as the tracer of config, then
+// shows the recorded steps in the terminal until the user quits.
+func runTUI(test *TestCase, code []byte, state *StateDB, config Config) int {
+	recorder := &traceRecorder{}
+	config.Tracer = recorder
+	res, err := evm(code, &test.Tx, state, &test.Block, config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		return 1
+	}
+	if len(recorder.steps) == 0 {
+		fmt.Println("Nothing to debug, no opcode was executed")
+		return 0
+	}
+	result := fmt.Sprintf("result: success, gas used %d, return 0x%s", res.GasUsed, res.Return)
+	if !res.Success {
+		result = fmt.Sprintf("result: failure (%v), gas used %d, return 0x%s", res.Err, res.GasUsed, res.Return)
+		if ret, err := hex.DecodeString(res.Return); err == nil {
+			if reason := revertReason(res.Err, ret, config.ABI); reason != "" {
+				result += ", reason: " + reason
+			}
+		}
+	}
+	t := &tui{
+		name:        test.Name,
+		result:      result,
+		steps:       recorder.steps,
+		breakpoints: make(map[tuiBreakpoint]bool),
+		disassembly: make(map[string][]disasmInstruction),
+	}
+
+	fd := int(os.Stdin.Fd())
+	restore, err := makeRaw(fd)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "The TUI needs a terminal:", err)
+		return 1
+	}
+	defer restore()
+	// Use the alternate screen, without cursor
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	defer fmt.Print("\x1b[?25h\x1b[?1049l")
+
+	buf := make([]byte, 16)
+	for {
+		cols, rows, err := terminalSize(fd)
+		if err != nil {
+			cols, rows = 80, 24
+		}
+		os.Stdout.Write(t.render(cols, rows))
+		n, err := os.Stdin.Read(buf)
+		if err != nil {
+			return 0
+		}
+		if !t.key(buf[:n]) {
+			return 0
+		}
+	}
+}
+
+// key handles a key press, and returns false when it quits.
+func (t *tui) key(k []byte) bool {
+	switch string(k) {
+	case "q", "\x03":
+		return false
+	case "l", "s", "\x1b[C", "\x1b[B", "j":
+		t.move(t.current + 1)
+	case "h", "b", "\x1b[D", "\x1b[A", "k":
+		t.move(t.current - 1)
+	case "n":
+		t.move(t.find(1, t.overStep))
+	case "p":
+		t.move(t.find(-1, t.overStep))
+	case "c":
+		t.move(t.find(1, t.atBreakpoint))
+	case "r":
+		t.move(t.find(-1, t.atBreakpoint))
+	case "g":
+		t.move(0)
+	case "G":
+		t.move(len(t.steps) - 1)
+	case " ":
+		step := &t.steps[t.current]
+		b := tuiBreakpoint{string(step.code), step.pc}
+		if t.breakpoints[b] {
+			delete(t.breakpoints, b)
+		} else {
+			t.breakpoints[b] = true
+		}
+	}
+	return true
+}
+
+func (t *tui) move(i int) {
+	if i >= 0 && i < len(t.steps) {
+		t.current = i
+	}
+}
+
+// find returns the first step from the current one in direction dir that
+// matches, or the first or last step when none does.
+func (t *tui) find(dir int, match func(i int) bool) int {
+	i := t.current + dir
+	for ; i > 0 && i < len(t.steps)-1; i += dir {
+		if match(i) {
+			return i
+		}
+	}
+	return i
+}
+
+// overStep reports whether step i is in the frame of the current step or
+// in one of its callers, to step over the calls it makes.
+func (t *tui) overStep(i int) bool {
+	return t.steps[i].depth <= t.steps[t.current].depth
+}
+
+func (t *tui) atBreakpoint(i int) bool {
+	return t.breakpoints[tuiBreakpoint{string(t.steps[i].code), t.steps[i].pc}]
+}
+
+// tuiLine is a line of a pane with its ANSI style.
+type tuiLine struct {
+	text  string
+	style string
+}
+
+// render draws the current step on a screen of the given size: the
+// disassembly on the left, the stack, memory, storage and call frames on the
+// right.
+func (t *tui) render(cols, rows int) []byte {
+	var b bytes.Buffer
+	b.WriteString("\x1b[2J")
+	if cols < 60 || rows < 12 {
+		b.WriteString("\x1b[1;1HThe terminal is too small")
+		return b.Bytes()
+	}
+	step := &t.steps[t.current]
+
+	header := fmt.Sprintf(" %v  step %d/%d  depth %d  pc %05x %v  gas %d  cost %d", t.name, t.current+1, len(t.steps), step.depth, step.pc, step.op, step.gas, step.cost)
+	if step.source != "" {
+		header += "  at " + step.source
+	}
+	status, statusStyle := t.result, ""
+	if step.err != nil {
+		status, statusStyle = fmt.Sprintf("fault: %v", step.err), ansiRed
+	}
+	writeCell(&b, 1, 1, tuiLine{header, ansiReverse + ansiBold}, cols)
+	writeCell(&b, 2, 1, tuiLine{" " + status, statusStyle}, cols)
+	writeCell(&b, rows, 1, tuiLine{" " + tuiKeys, ansiReverse}, cols)
+
+	height := rows - 3
+	leftWidth := cols * 2 / 5
+	rightWidth := cols - leftWidth - 1
+	left := t.codePane(step, height)
+
+	stackHeight := height * 3 / 10
+	memoryHeight := height * 3 / 10
+	storageHeight := height / 5
+	var right []tuiLine
+	right = append(right, pane("Stack", stackLines(step.stack), stackHeight)...)
+	right = append(right, pane(fmt.Sprintf("Memory (%d bytes)", len(step.memory)), memoryLines(step.memory, rightWidth), memoryHeight)...)
+	right = append(right, pane("Storage", storageLines(step.storage), storageHeight)...)
+	calls := append(frameLines(step.frames), fmt.Sprintf("return data: 0x%x", step.returnData))
+	right = append(right, pane("Calls", calls, height-stackHeight-memoryHeight-storageHeight)...)
+
+	for i := 0; i < height; i++ {
+		row := i + 3
+		if i < len(left) {
+			writeCell(&b, row, 1, left[i], leftWidth)
+		}
+		fmt.Fprintf(&b, "\x1b[%d;%dH│", row, leftWidth+1)
+		if i < len(right) {
+			writeCell(&b, row, leftWidth+2, right[i], rightWidth)
+		}
+	}
+	return b.Bytes()
+}
+
+// codePane lists the instructions around the current one, marking it and
+// the breakpoints.
+func (t *tui) codePane(step *traceStep, height int) []tuiLine {
+	instructions, ok := t.disassembly[string(step.code)]
+	if !ok {
+		instructions = Disassemble(step.code)
+		t.disassembly[string(step.code)] = instructions
+	}
+	current := sort.Search(len(instructions), func(i int) bool { return instructions[i].PC >= step.pc })
+	lines := []tuiLine{{" Code", ansiBold}}
+	start := current - (height-1)/2
+	if start > len(instructions)-(height-1) {
+		start = len(instructions) - (height - 1)
+	}
+	if start < 0 {
+		start = 0
+	}
+	for i := start; i < len(instructions) && len(lines) < height; i++ {
+		ins := instructions[i]
+		marker, style := "  ", ""
+		if t.breakpoints[tuiBreakpoint{string(step.code), ins.PC}] {
+			marker = " *"
+		}
+		if i == current {
+			style = ansiReverse
+		}
+		lines = append(lines, tuiLine{fmt.Sprintf("%s %05x: %v", marker, ins.PC, ins), style})
+	}
+	return lines
+}
+
+// pane returns the lines of a pane of the given height, its title first.
+// Lines that don't fit are counted in the last line.
+func pane(title string, content []string, height int) []tuiLine {
+	if height <= 0 {
+		return nil
+	}
+	lines := []tuiLine{{" " + title, ansiBold}}
+	for i, text := range content {
+		if len(lines) == height-1 && i < len(content)-1 {
+			lines = append(lines, tuiLine{fmt.Sprintf("  … %d more", len(content)-i), ""})
+			break
+		}
+		if len(lines) == height {
+			break
+		}
+		lines = append(lines, tuiLine{"  " + text, ""})
+	}
+	for len(lines) < height {
+		lines = append(lines, tuiLine{})
+	}
+	return lines
+}
+
+func stackLines(stack []uint256.Int) []string {
+	lines := make([]string, len(stack))
+	for i := range stack {
+		lines[i] = fmt.Sprintf("%3d: %v", i, stack[i].Hex())
+	}
+	return lines
+}
+
+// memoryLines dumps memory in as many bytes per line as fit in width.
+func memoryLines(memory []byte, width int) []string {
+	perLine := 32
+	for perLine > 8 && 2*perLine+8 > width {
+		perLine /= 2
+	}
+	var lines []string
+	for offset := 0; offset < len(memory); offset += perLine {
+		end := offset + perLine
+		if end > len(memory) {
+			end = len(memory)
+		}
+		lines = append(lines, fmt.Sprintf("%04x: %x", offset, memory[offset:end]))
+	}
+	return lines
+}
+
+func storageLines(storage map[Hash]Hash) []string {
+	lines := make([]string, 0, len(storage))
+	for key, value := range storage {
+		k := new(uint256.Int).SetBytes(key[:])
+		v := new(uint256.Int).SetBytes(value[:])
+		lines = append(lines, fmt.Sprintf("%v: %v", k.Hex(), v.Hex()))
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+func frameLines(frames []traceFrame) []string {
+	lines := make([]string, len(frames))
+	for i, frame := range frames {
+		lines[i] = fmt.Sprintf("%d %-12v %v", i+1, frame.typ, frame.address)
+	}
+	return lines
+}
+
+// writeCell writes line at row and col, cut or padded to width.
+func writeCell(w io.Writer, row, col int, line tuiLine, width int) {
+	text := []rune(line.text)
+	if len(text) > width {
+		text = text[:width]
+	}
+	fmt.Fprintf(w, "\x1b[%d;%dH%s%s%s%s", row, col, line.style, string(text), strings.Repeat(" ", width-len(text)), ansiReset)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// recordTUI records the execution of code that calls a contract at 0xc42,
+// and returns a tui showing it. The steps are:
+//
+//	0-4    PUSH1 0          depth 1
+//	5      PUSH2 0x0c42
+//	6      GAS
+//	7      CALL
+//	8      PUSH1 3          depth 2
+//	9      POP
+//	10     STOP
+//	11     POP              depth 1
+//	12, 13 PUSH1
+//	14     ADD
+//	15     STOP
+func recordTUI(t *testing.T) *tui {
+	t.Helper()
+	state := newTestState(t, GenesisAlloc{
+		HexToAddress("0xc42"): {Code: code{Bin: hex.EncodeToString(assemble(t, "PUSH1 3 POP STOP"))}},
+	})
+	asm := "PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 0 PUSH2 0x0c42 GAS CALL POP PUSH1 1 PUSH1 2 ADD STOP"
+	recorder := &traceRecorder{}
+	tx := &Transaction{To: &testRecipient, From: testSender}
+	if _, err := evm(assemble(t, asm), tx, state, &Block{}, Config{Tracer: recorder}); err != nil {
+		t.Fatal(err)
+	}
+	return &tui{
+		steps:       recorder.steps,
+		breakpoints: make(map[tuiBreakpoint]bool),
+		disassembly: make(map[string][]disasmInstruction),
+	}
+}
+
+func TestTraceRecorder(t *testing.T) {
+	steps := recordTUI(t).steps
+	if len(steps) != 16 {
+		t.Fatalf("recorded %d steps, want 16", len(steps))
+	}
+	for i, want := range map[int]struct {
+		op     OpCode
+		depth  int
+		frames int
+		stack  int
+	}{
+		0:  {PUSH1, 1, 1, 0},
+		7:  {CALL, 1, 1, 7},
+		8:  {PUSH1, 2, 2, 0},
+		9:  {POP, 2, 2, 1},
+		11: {POP, 1, 1, 1},
+		15: {STOP, 1, 1, 1},
+	} {
+		step := steps[i]
+		if step.op != want.op || step.depth != want.depth || len(step.frames) != want.frames || len(step.stack) != want.stack {
+			t.Errorf("step %d: got %v at depth %d with %d frames and %d stack items, want %v at depth %d with %d frames and %d stack items",
+				i, step.op, step.depth, len(step.frames), len(step.stack), want.op, want.depth, want.frames, want.stack)
+		}
+	}
+	if frame := steps[8].frames[1]; frame.typ != CALL || frame.address != HexToAddress("0xc42") {
+		t.Errorf("got frame %v %v, want CALL 0xc42", frame.typ, frame.address)
+	}
+	// The stack is copied at every step
+	if stack := steps[9].stack; len(stack) != 1 || stack[0].Uint64() != 3 {
+		t.Errorf("got stack %v before the POP, want [0x3]", toStrings(stack))
+	}
+}
+
+func TestTraceRecorderFault(t *testing.T) {
+	recorder := &traceRecorder{}
+	tx := &Transaction{To: &testRecipient, From: testSender}
+	if _, err := evm(assemble(t, "PUSH1 1 PUSH1 0 JUMP"), tx, NewStateDB(), &Block{}, Config{Tracer: recorder}); err != nil {
+		t.Fatal(err)
+	}
+	steps := recorder.steps
+	// The fault is recorded on the step of the opcode that failed
+	if len(steps) != 3 || steps[1].err != nil || steps[2].err != ErrInvalidJump {
+		t.Errorf("got %d steps, the last with error %v, want 3 steps, the JUMP failing with %v", len(steps), steps[len(steps)-1].err, ErrInvalidJump)
+	}
+}
+
+func TestTUIKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		// keys are pressed in turn, each followed by the step it moves to
+		keys  []string
+		steps []int
+	}{
+		{"step", []string{"l", "\x1b[C", "j", "s", "h", "\x1b[D", "k", "b"}, []int{1, 2, 3, 4, 3, 2, 1, 0}},
+		{"start and end", []string{"G", "g", "G"}, []int{15, 0, 15}},
+		// Moving past either end keeps the step
+		{"past the start", []string{"h", "p", "r"}, []int{0, 0, 0}},
+		{"past the end", []string{"G", "l", "n", "c"}, []int{15, 15, 15, 15}},
+		{"step into call", []string{"G", "g", "l", "l", "l", "l", "l", "l", "l", "l"}, []int{15, 0, 1, 2, 3, 4, 5, 6, 7, 8}},
+		{"step over call", []string{"n", "n", "n", "n", "n", "n", "n", "n", "p", "p"}, []int{1, 2, 3, 4, 5, 6, 7, 11, 7, 6}},
+		// Stepping over in the callee goes on into its caller
+		{"step over in callee", []string{"l", "l", "l", "l", "l", "l", "l", "l", "l", "n", "n", "p"}, []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 7}},
+		// Without breakpoints, continue and reverse go to the ends
+		{"continue", []string{"c", "r"}, []int{15, 0}},
+		{"continue from before the end", []string{"G", "h", "c"}, []int{15, 14, 15}},
+		{"reverse from after the start", []string{"l", "r"}, []int{1, 0}},
+	}
+	for _, test := range tests {
+		ui := recordTUI(t)
+		for i, key := range test.keys {
+			if !ui.key([]byte(key)) {
+				t.Fatalf("%v: key %q quit", test.name, key)
+			}
+			if ui.current != test.steps[i] {
+				t.Errorf("%v: key %d %q moved to step %d, want %d", test.name, i, key, ui.current, test.steps[i])
+				break
+			}
+		}
+	}
+}
+
+func TestTUIBreakpoints(t *testing.T) {
+	ui := recordTUI(t)
+	press := func(keys ...string) {
+		for _, key := range keys {
+			ui.key([]byte(key))
+		}
+	}
+	// Breakpoints at the POP of the callee and the ADD
+	ui.move(9)
+	press(" ")
+	ui.move(14)
+	press(" ", "g")
+	for i, want := range []struct {
+		key  string
+		step int
+	}{
+		{"c", 9}, {"c", 14}, {"c", 15}, {"r", 14}, {"r", 9}, {"r", 0},
+	} {
+		press(want.key)
+		if ui.current != want.step {
+			t.Fatalf("key %d %q moved to step %d, want %d", i, want.key, ui.current, want.step)
+		}
+	}
+	// Space toggles the breakpoint off again
+	ui.move(9)
+	press(" ", "g", "c")
+	if ui.current != 14 {
+		t.Errorf("continued to step %d, want 14", ui.current)
+	}
+	if !ui.key([]byte("x")) || ui.key([]byte("q")) || ui.key([]byte("\x03")) {
+		t.Error("want only q and ctrl-c to quit")
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/holiman/uint256"
+)
+
+const (
+	// AddressLength is the expected length of an address
+	AddressLength = 20
+	// HashLength is the expected length of a hash
+	HashLength = 32
+)
+
+// Address represents the 20 byte address of an account.
+type Address [AddressLength]byte
+
+// BytesToAddress returns Address with value b.
+// If b is larger than len(a), b will be cropped from the left.
+func BytesToAddress(b []byte) Address {
+	var a Address
+	a.SetBytes(b)
+	return a
+}
+
+// HexToAddress returns Address with byte values of s. It panics if s is not
+// valid hex, use UnmarshalText for untrusted input.
+func HexToAddress(s string) Address {
+	var a Address
+	if err := a.UnmarshalText([]byte(s)); err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// SetBytes sets the address to the value of b.
+// If b is larger than len(a), b will be cropped from the left.
+func (a *Address) SetBytes(b []byte) {
+	if len(b) > len(a) {
+		b = b[len(b)-AddressLength:]
+	}
+	*a = Address{}
+	copy(a[AddressLength-len(b):], b)
+}
+
+// Bytes gets the byte representation of the underlying address.
+func (a Address) Bytes() []byte { return a[:] }
+
+// Hash converts an address to a hash by left-padding it with zeros.
+func (a Address) Hash() Hash { return BytesToHash(a[:]) }
+
+// Uint256 returns the address as a stack word.
+func (a Address) Uint256() *uint256.Int { return new(uint256.Int).SetBytes(a[:]) }
+
+// Hex returns an EIP-55 compliant hex string representation of the address.
+func (a Address) Hex() string {
+	buf := []byte(hex.EncodeToString(a[:]))
+	sha := crypto.Keccak256(buf)
+	for i := 0; i < len(buf); i++ {
+		hashByte := sha[i/2]
+		if i%2 == 0 {
+			hashByte = hashByte >> 4
+		} else {
+			hashByte &= 0xf
+		}
+		if buf[i] > '9' && hashByte > 7 {
+			buf[i] -= 32
+		}
+	}
+	return "0x" + string(buf)
+}
+
+// String implements fmt.Stringer.
+func (a Address) String() string { return a.Hex() }
+
+// MarshalText returns the checksummed hex representation of a.
+func (a Address) MarshalText() ([]byte, error) {
+	return []byte(a.Hex()), nil
+}
+
+// UnmarshalText parses an address in hex syntax. Short values such as
+// "0xaaa" are left-padded with zeros, the casing of the input is ignored.
+func (a *Address) UnmarshalText(input []byte) error {
+	b, err := decodeHexWord(string(input), AddressLength)
+	if err != nil {
+		return fmt.Errorf("invalid address %q: %v", input, err)
+	}
+	a.SetBytes(b)
+	return nil
+}
+
+// Hash represents the 32 byte Keccak256 hash of arbitrary data, it is also
+// used for storage keys and values.
+type Hash [HashLength]byte
+
+// BytesToHash sets b to hash.
+// If b is larger than len(h), b will be cropped from the left.
+func BytesToHash(b []byte) Hash {
+	var h Hash
+	h.SetBytes(b)
+	return h
+}
+
+// HexToHash returns Hash with byte values of s. It panics if s is not valid
+// hex, use UnmarshalText for untrusted input.
+func HexToHash(s string) Hash {
+	var h Hash
+	if err := h.UnmarshalText([]byte(s)); err != nil {
+		panic(err)
+	}
+	return h
+}
+
+// SetBytes sets the hash to the value of b.
+// If b is larger than len(h), b will be cropped from the left.
+func (h *Hash) SetBytes(b []byte) {
+	if len(b) > len(h) {
+		b = b[len(b)-HashLength:]
+	}
+	*h = Hash{}
+	copy(h[HashLength-len(b):], b)
+}
+
+// Bytes gets the byte representation of the underlying hash.
+func (h Hash) Bytes() []byte { return h[:] }
+
+// Uint256 returns the hash as a stack word.
+func (h Hash) Uint256() *uint256.Int { return new(uint256.Int).SetBytes(h[:]) }
+
+// Hex converts a hash to a hex string.
+func (h Hash) Hex() string { return "0x" + hex.EncodeToString(h[:]) }
+
+// String implements fmt.Stringer.
+func (h Hash) String() string { return h.Hex() }
+
+// MarshalText returns the hex representation of h.
+func (h Hash) MarshalText() ([]byte, error) {
+	return []byte(h.Hex()), nil
+}
+
+// UnmarshalText parses a hash in hex syntax, short values are left-padded.
+func (h *Hash) UnmarshalText(input []byte) error {
+	b, err := decodeHexWord(string(input), HashLength)
+	if err != nil {
+		return fmt.Errorf("invalid hash %q: %v", input, err)
+	}
+	h.SetBytes(b)
+	return nil
+}
+
+// Keccak256Hash calculates and returns the Keccak256 hash of the input data,
+// converting it to a Hash.
+func Keccak256Hash(data ...[]byte) Hash {
+	return BytesToHash(crypto.Keccak256(data...))
+}
+
+// decodeHexWord decodes a hex string of at most size bytes. The 0x prefix is
+// optional and odd-length input is accepted, as the fixtures strip leading
+// zeros from addresses and slots.
+func decodeHexWord(s string, size int) ([]byte, error) {
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X")
+	if len(s)%2 == 1 {
+		s = "0" + s
+	}
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > size {
+		return nil, fmt.Errorf("hex string has length %d, want at most %d", len(b), size)
+	}
+	return b, nil
+}
+
+// parseUint256 parses a fixture number, which is either decimal or 0x
+// prefixed hex. An empty string is zero.
+func parseUint256(s string) (*uint256.Int, error) {
+	if s == "" {
+		return new(uint256.Int), nil
+	}
+	b, ok := new(big.Int).SetString(s, 0)
+	if !ok {
+		return nil, fmt.Errorf("invalid number %q", s)
+	}
+	v, overflow := uint256.FromBig(b)
+	if overflow || b.Sign() < 0 {
+		return nil, fmt.Errorf("number %q out of range", s)
+	}
+	return v, nil
+}
+
+// Log is an event emitted by one of the LOG0-LOG4 instructions.
+type Log struct {
+	Address Address `json:"address"`
+	Topics  []Hash  `json:"topics"`
+	Data    []byte  `json:"data"`
+}
@@ -1,10 +1,28 @@
+// Copyright 2014 The go-ethereum Authors
+// This file is derived from core/vm/evm.go of the go-ethereum library, and
+// modified for this EVM.
+//
+// This file is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This file is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with this file. If not, see <http://www.gnu.org/licenses/>.
+
 package main
 
 import (
-	"fmt"
+	"encoding/hex"
 	"math"
 	"math/bits"
 
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/holiman/uint256"
 )
 
@@ -15,14 +33,42 @@ var (
 type VM struct {
 	Context        *executionContext
 	EVMInterpreter *Interpreter
+
+	Block       *Block
+	Transaction *Transaction
+	StateDB     *StateDB
+	Config      Config
+
+	// depth is the current call stack
+	depth int
+
+	// blockValues are the numbers of Block, and gasPrice is the price per
+	// gas Transaction pays in it
+	blockValues *blockValues
+	gasPrice    *uint256.Int
 }
 
-func NewVM(ctx *executionContext) *VM {
+// NewVM returns a VM executing tx in block on top of state. It fails when
+// the numbers of the block or the fees of the transaction are malformed.
+func NewVM(block *Block, tx *Transaction, state *StateDB, config Config) (*VM, error) {
+	values, err := block.values()
+	if err != nil {
+		return nil, err
+	}
+	gasPrice, err := tx.EffectiveGasPrice(block)
+	if err != nil {
+		return nil, err
+	}
 	vm := &VM{
-		Context: ctx,
+		Block:       block,
+		Transaction: tx,
+		StateDB:     state,
+		Config:      config,
+		blockValues: values,
+		gasPrice:    gasPrice,
 	}
 	vm.EVMInterpreter = NewInterpreter(vm)
-	return vm
+	return vm, nil
 }
 
 // returns the current intererpreter
@@ -30,43 +76,454 @@ func (vm *VM) Interpreter() *Interpreter {
 	return vm.EVMInterpreter
 }
 
+// newContext creates the execution context that runs the code of c.
+func (vm *VM) newContext(c *contract) *executionContext {
+	return &executionContext{
+		pc:          0,
+		code:        c.Code,
+		contract:    c,
+		block:       vm.Block,
+		state:       vm.StateDB,
+		stack:       newStack(),
+		memory:      newMemory(),
+		transaction: vm.Transaction,
+		unmetered:   vm.Config.Unmetered,
+	}
+}
+
+// run executes ctx as a new call frame and returns the output of the frame.
+// The context of the caller is restored once the frame is done. A readOnly
+// frame, and every frame below it, can't modify the state.
+func (vm *VM) run(ctx *executionContext, readOnly bool) ([]byte, error) {
+	parent := vm.Context
+	vm.Context = ctx
+	vm.depth++
+	ctx.depth = vm.depth
+	defer func() {
+		vm.depth--
+		vm.Context = parent
+	}()
+
+	// Make sure the readOnly is only set if we aren't in readOnly yet.
+	// This also makes sure that the readOnly flag isn't removed for child calls.
+	if readOnly && !vm.EVMInterpreter.readOnly {
+		vm.EVMInterpreter.readOnly = true
+		defer func() { vm.EVMInterpreter.readOnly = false }()
+	}
+
+	vm.execute(ctx.code)
+	return ctx.returnData, ctx.err
+}
+
+// transfer moves amount from sender to recipient. Unmetered, the sender
+// pays what it can of it.
+func (vm *VM) transfer(sender, recipient Address, amount *uint256.Int) {
+	debit := amount
+	if balance := vm.StateDB.GetBalance(sender); vm.Config.Unmetered && balance.Lt(amount) {
+		debit = balance
+	}
+	vm.StateDB.SubBalance(sender, debit)
+	vm.StateDB.AddBalance(recipient, amount)
+}
+
+// canTransfer checks whether there are enough funds in the address' account
+// to make a transfer, which there always are unmetered.
+func (vm *VM) canTransfer(addr Address, amount *uint256.Int) bool {
+	return vm.Config.Unmetered || !vm.StateDB.GetBalance(addr).Lt(amount)
+}
+
+// runPrecompile runs the precompiled contract p, for free when unmetered.
+func (vm *VM) runPrecompile(p PrecompiledContract, input []byte, gas uint64) ([]byte, uint64, error) {
+	if vm.Config.Unmetered {
+		ret, err := p.Run(input)
+		return ret, gas, err
+	}
+	return RunPrecompiledContract(p, input, gas)
+}
+
+// Call executes the contract associated with the addr with the given input as
+// parameters. It also handles any necessary value transfer required and takes
+// the necessary steps to create accounts and reverses the state in case of an
+// execution error or failed value transfer.
+func (vm *VM) Call(caller Address, addr Address, input []byte, gas uint64, value *uint256.Int) (ret []byte, leftOverGas uint64, err error) {
+	if vm.Config.Tracer != nil {
+		end := vm.captureFrame(CALL, caller, addr, input, gas, value)
+		defer func() { end(ret, leftOverGas, err) }()
+	}
+	// Fail if we're trying to execute above the call depth limit
+	if vm.depth > int(CallCreateDepth) {
+		return nil, gas, ErrDepth
+	}
+	// Fail if we're trying to transfer more than the available balance
+	if !value.IsZero() && !vm.canTransfer(caller, value) {
+		return nil, gas, ErrInsufficientBalance
+	}
+	snapshot := vm.StateDB.Snapshot()
+	p, isPrecompile := vm.precompile(addr)
+
+	if !vm.StateDB.Exist(addr) {
+		if !isPrecompile && value.IsZero() {
+			// Calling a non existing account, don't do anything.
+			return nil, gas, nil
+		}
+		vm.StateDB.CreateAccount(addr)
+	}
+	vm.transfer(caller, addr, value)
+
+	if isPrecompile {
+		ret, gas, err = vm.runPrecompile(p, input, gas)
+	} else {
+		ret, gas, err = vm.runCode(&contract{
+			CallerAddress: caller,
+			Address:       addr,
+			CallValue:     value,
+			Input:         input,
+			Gas:           gas,
+			Code:          vm.StateDB.GetCode(addr),
+		}, false)
+	}
+	// When an error was returned by the VM or when setting the creation code
+	// above we revert to the snapshot and consume any gas remaining. Additionally
+	// when we're in homestead this also counts for code storage gas errors.
+	if err != nil {
+		vm.StateDB.RevertToSnapshot(snapshot)
+		if err != ErrExecutionReverted {
+			gas = 0
+		}
+	}
+	return ret, gas, err
+}
+
+// CallCode executes the contract associated with the addr with the given input
+// as parameters. It also handles any necessary value transfer required and takes
+// the necessary steps to create accounts and reverses the state in case of an
+// execution error or failed value transfer.
+//
+// CallCode differs from Call in the sense that it executes the given address'
+// code with the caller as context.
+func (vm *VM) CallCode(caller Address, addr Address, input []byte, gas uint64, value *uint256.Int) (ret []byte, leftOverGas uint64, err error) {
+	if vm.Config.Tracer != nil {
+		end := vm.captureFrame(CALLCODE, caller, addr, input, gas, value)
+		defer func() { end(ret, leftOverGas, err) }()
+	}
+	// Fail if we're trying to execute above the call depth limit
+	if vm.depth > int(CallCreateDepth) {
+		return nil, gas, ErrDepth
+	}
+	// Fail if we're trying to transfer more than the available balance
+	// Note although it's noop to transfer X ether to caller itself. But
+	// if caller doesn't have enough balance, it would be an error to allow
+	// over-charging itself. So the check here is necessary.
+	if !vm.canTransfer(caller, value) {
+		return nil, gas, ErrInsufficientBalance
+	}
+	snapshot := vm.StateDB.Snapshot()
+
+	// It is allowed to call precompiles, even via delegatecall
+	if p, isPrecompile := vm.precompile(addr); isPrecompile {
+		ret, gas, err = vm.runPrecompile(p, input, gas)
+	} else {
+		ret, gas, err = vm.runCode(&contract{
+			CallerAddress: caller,
+			Address:       caller,
+			CallValue:     value,
+			Input:         input,
+			Gas:           gas,
+			Code:          vm.StateDB.GetCode(addr),
+		}, false)
+	}
+	if err != nil {
+		vm.StateDB.RevertToSnapshot(snapshot)
+		if err != ErrExecutionReverted {
+			gas = 0
+		}
+	}
+	return ret, gas, err
+}
+
+// DelegateCall executes the contract associated with the addr with the given input
+// as parameters. It reverses the state in case of an execution error.
+//
+// DelegateCall differs from CallCode in the sense that it executes the given address'
+// code with the caller as context and the caller is set to the caller of the caller.
+func (vm *VM) DelegateCall(caller *contract, addr Address, input []byte, gas uint64) (ret []byte, leftOverGas uint64, err error) {
+	if vm.Config.Tracer != nil {
+		end := vm.captureFrame(DELEGATECALL, caller.Address, addr, input, gas, nil)
+		defer func() { end(ret, leftOverGas, err) }()
+	}
+	// Fail if we're trying to execute above the call depth limit
+	if vm.depth > int(CallCreateDepth) {
+		return nil, gas, ErrDepth
+	}
+	snapshot := vm.StateDB.Snapshot()
+
+	// It is allowed to call precompiles, even via delegatecall
+	if p, isPrecompile := vm.precompile(addr); isPrecompile {
+		ret, gas, err = vm.runPrecompile(p, input, gas)
+	} else {
+		ret, gas, err = vm.runCode(&contract{
+			CallerAddress: caller.CallerAddress,
+			Address:       caller.Address,
+			CallValue:     caller.CallValue,
+			Input:         input,
+			Gas:           gas,
+			Code:          vm.StateDB.GetCode(addr),
+		}, false)
+	}
+	if err != nil {
+		vm.StateDB.RevertToSnapshot(snapshot)
+		if err != ErrExecutionReverted {
+			gas = 0
+		}
+	}
+	return ret, gas, err
+}
+
+// StaticCall executes the contract associated with the addr with the given input
+// as parameters while disallowing any modifications to the state during the call.
+// Opcodes that attempt to perform such modifications will result in exceptions
+// instead of performing the modifications.
+func (vm *VM) StaticCall(caller Address, addr Address, input []byte, gas uint64) (ret []byte, leftOverGas uint64, err error) {
+	if vm.Config.Tracer != nil {
+		end := vm.captureFrame(STATICCALL, caller, addr, input, gas, nil)
+		defer func() { end(ret, leftOverGas, err) }()
+	}
+	// Fail if we're trying to execute above the call depth limit
+	if vm.depth > int(CallCreateDepth) {
+		return nil, gas, ErrDepth
+	}
+	// We take a snapshot here. This is a bit counter-intuitive, and could probably be skipped.
+	// However, even a staticcall is considered a 'touch'. On mainnet, static calls were introduced
+	// after all empty accounts were deleted, so this is not required. However, if we omit this,
+	// then certain tests start failing; stRevertTest/RevertPrecompiledTouchExactOOG.json.
+	// We could change this, but for now it's left for legacy reasons
+	snapshot := vm.StateDB.Snapshot()
+
+	// We do an AddBalance of zero here, just in order to trigger a touch.
+	// This doesn't matter on Mainnet, where all empties are gone at the time of Byzantium,
+	// but is the correct thing to do and matters on other networks, in tests, and potential
+	// future scenarios
+	vm.StateDB.AddBalance(addr, new(uint256.Int))
+
+	if p, isPrecompile := vm.precompile(addr); isPrecompile {
+		ret, gas, err = vm.runPrecompile(p, input, gas)
+	} else {
+		ret, gas, err = vm.runCode(&contract{
+			CallerAddress: caller,
+			Address:       addr,
+			CallValue:     new(uint256.Int),
+			Input:         input,
+			Gas:           gas,
+			Code:          vm.StateDB.GetCode(addr),
+		}, true)
+	}
+	if err != nil {
+		vm.StateDB.RevertToSnapshot(snapshot)
+		if err != ErrExecutionReverted {
+			gas = 0
+		}
+	}
+	return ret, gas, err
+}
+
+// runCode runs the code of c in a new frame and returns its output and the
+// gas it has left. Code without instructions returns straight away.
+func (vm *VM) runCode(c *contract, readOnly bool) ([]byte, uint64, error) {
+	if len(c.Code) == 0 {
+		return nil, c.Gas, nil
+	}
+	ctx := vm.newContext(c)
+	ret, err := vm.run(ctx, readOnly)
+	return ret, ctx.contract.Gas, err
+}
+
+// Create creates a new contract using code as deployment code, the address
+// is derived from the caller and its nonce.
+func (vm *VM) Create(caller Address, code []byte, gas uint64, value *uint256.Int) (ret []byte, contractAddr Address, leftOverGas uint64, err error) {
+	address := createAddress(caller, vm.StateDB.GetNonce(caller))
+	return vm.create(caller, code, gas, value, address, CREATE)
+}
+
+// Create2 creates a new contract using code as deployment code, the address
+// is derived from the caller, the salt and the hash of the code (EIP-1014).
+func (vm *VM) Create2(caller Address, code []byte, gas uint64, value *uint256.Int, salt *uint256.Int) (ret []byte, contractAddr Address, leftOverGas uint64, err error) {
+	address := create2Address(caller, salt.Bytes32(), crypto.Keccak256(code))
+	return vm.create(caller, code, gas, value, address, CREATE2)
+}
+
+// create creates a new contract at address using code as deployment code.
+// typ is the opcode creating it, CREATE or CREATE2.
+func (vm *VM) create(caller Address, code []byte, gas uint64, value *uint256.Int, address Address, typ OpCode) (ret []byte, contractAddr Address, leftOverGas uint64, err error) {
+	if vm.Config.Tracer != nil {
+		end := vm.captureFrame(typ, caller, address, code, gas, value)
+		defer func() { end(ret, leftOverGas, err) }()
+	}
+	// Depth check execution. Fail if we're trying to execute above the
+	// limit.
+	if vm.depth > int(CallCreateDepth) {
+		return nil, Address{}, gas, ErrDepth
+	}
+	if !vm.canTransfer(caller, value) {
+		return nil, Address{}, gas, ErrInsufficientBalance
+	}
+	nonce := vm.StateDB.GetNonce(caller)
+	if nonce+1 < nonce {
+		return nil, Address{}, gas, ErrNonceUintOverflow
+	}
+	vm.StateDB.SetNonce(caller, nonce+1)
+
+	// We add this to the access list _before_ taking a snapshot. Even if the
+	// creation fails, the access-list change should not be rolled back.
+	vm.StateDB.AddAddressToAccessList(address)
+	// Ensure there's no existing contract already at the designated address
+	if vm.StateDB.GetNonce(address) != 0 || len(vm.StateDB.GetCode(address)) != 0 {
+		return nil, Address{}, 0, ErrContractAddressCollision
+	}
+	// Create a new account on the state
+	snapshot := vm.StateDB.Snapshot()
+	vm.StateDB.CreateAccount(address)
+	vm.StateDB.CreateContract(address)
+	vm.StateDB.SetNonce(address, 1)
+	vm.transfer(caller, address, value)
+
+	ctx := vm.newContext(&contract{
+		CallerAddress: caller,
+		Address:       address,
+		CallValue:     value,
+		Gas:           gas,
+		Code:          code,
+	})
+	ret, err = vm.run(ctx, false)
+
+	// Check whether the max code size has been exceeded, assign err if the case.
+	if err == nil && len(ret) > MaxCodeSize {
+		err = ErrMaxCodeSizeExceeded
+	}
+
+	// Reject code starting with 0xEF if EIP-3541 is enabled.
+	if err == nil && len(ret) >= 1 && ret[0] == 0xEF {
+		err = ErrInvalidCode
+	}
+
+	// if the contract creation ran successfully and no errors were returned
+	// calculate the gas required to store the code. If the code could not
+	// be stored due to not enough gas set an error and let it be handled
+	// by the error checking condition below.
+	if err == nil {
+		createDataGas := uint64(len(ret)) * CreateDataGas
+		if ctx.useGas(createDataGas) {
+			vm.StateDB.SetCode(address, ret)
+		} else {
+			err = ErrCodeStoreOutOfGas
+		}
+	}
+
+	// When an error was returned by the EVM or when setting the creation code
+	// above we revert to the snapshot and consume any gas remaining.
+	gas = ctx.contract.Gas
+	if err != nil {
+		vm.StateDB.RevertToSnapshot(snapshot)
+		if err != ErrExecutionReverted {
+			gas = 0
+		}
+	}
+	return ret, address, gas, err
+}
+
+// execute runs the code of the current context until it halts, and returns
+// the stack, the hex encoded return data and whether it succeeded.
 func (vm *VM) execute(code []byte) ([]uint256.Int, string, bool) {
-	var stack []uint256.Int
+	ctx := vm.Context
 	in := len(code)
 
-	for !vm.Context.halt && vm.Context.pc < uint64(in) {
-		opCode, n := decodeOp(vm.Context)
+	// The opcode being executed, as reported to the tracer. An opcode that
+	// fails before it is logged is reported with its error, afterwards it's
+	// reported as a fault.
+	tracer := vm.Config.Tracer
+	var (
+		opCode  byte
+		pcCopy  uint64
+		gasCopy uint64
+		cost    uint64
+		logged  bool
+	)
+
+	for !ctx.halt && ctx.pc < uint64(in) {
+		var n uint64
+		opCode, n = decodeOp(ctx)
 		op := vm.EVMInterpreter.instructionSet[OpCode(opCode)]
+		pcCopy, gasCopy, cost, logged = ctx.pc, ctx.contract.Gas, op.constantGas, false
+
+		// Validate stack
+		if sLen := ctx.stack.len(); sLen < op.minStack {
+			ctx.fail(&ErrStackUnderflow{stackLen: sLen, required: op.minStack})
+			break
+		} else if sLen > op.maxStack {
+			ctx.fail(&ErrStackOverflow{stackLen: sLen, limit: op.maxStack})
+			break
+		}
+		if !ctx.useGas(op.constantGas) {
+			ctx.fail(ErrOutOfGas)
+			break
+		}
 
 		var memorySize uint64
 
 		if op.memorySize != nil {
-			memSize, overflow := op.memorySize(vm.Context.stack)
+			memSize, overflow := op.memorySize(ctx.stack)
 			if overflow {
-				fmt.Println("memory size overflow")
-				return stack, "", false
+				ctx.fail(ErrGasUintOverflow)
+				break
 			}
 
 			if memorySize, overflow = SafeMul(toWordSize(memSize), 32); overflow {
-				fmt.Println("memory size overflow")
-				return stack, "", false
+				ctx.fail(ErrGasUintOverflow)
+				break
 			}
+		}
 
-			if memorySize > 0 {
-				vm.Context.memory.resize(memorySize)
+		if op.dynamicGas != nil {
+			dynamicCost, err := op.dynamicGas(ctx, ctx.stack, memorySize)
+			cost += dynamicCost
+			if err != nil || !ctx.useGas(dynamicCost) {
+				ctx.fail(ErrOutOfGas)
+				break
 			}
 		}
 
+		// Do tracing before memory expansion, tracers see the memory the
+		// opcode starts with
+		if tracer != nil {
+			tracer.CaptureState(ctx.pc, OpCode(opCode), gasCopy, cost, ctx, ctx.callReturnData, ctx.depth, nil)
+			logged = true
+		}
+
+		if memorySize > 0 {
+			ctx.memory.resize(memorySize)
+		}
+
 		// execute the instruction
-		stack = op.execute(vm.Context.pc, vm.Context, vm.EVMInterpreter)
-		vm.Context.pc += n
+		op.execute(ctx.pc, ctx, vm.EVMInterpreter)
+		if ctx.unmetered {
+			// drop the gas calls gave back, unmetered frames keep theirs
+			ctx.contract.Gas = gasCopy
+		}
+		if ctx.err != nil {
+			break
+		}
+		ctx.pc += n
 	}
 
-	if vm.Context.pc >= uint64(in) && vm.Context.halt {
-		vm.Context.done = true
+	if tracer != nil && ctx.err != nil {
+		if logged {
+			tracer.CaptureFault(pcCopy, OpCode(opCode), gasCopy, cost, ctx, ctx.depth, ctx.err)
+		} else {
+			tracer.CaptureState(pcCopy, OpCode(opCode), gasCopy, cost, ctx, ctx.callReturnData, ctx.depth, ctx.err)
+		}
 	}
 
-	return stack, vm.Context.returnData, vm.Context.done
+	ctx.done = ctx.err == nil
+	return ctx.stack.data, hex.EncodeToString(ctx.returnData), ctx.done
 }
 
 func decodeOp(ctx *executionContext) (byte, uint64) {
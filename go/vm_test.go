@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/holiman/uint256"
+)
+
+// assemble assembles src, failing the test when it doesn't.
+func assemble(t *testing.T, src string) []byte {
+	t.Helper()
+	code, err := Assemble(src)
+	if err != nil {
+		t.Fatalf("assembling %q: %v", src, err)
+	}
+	return code
+}
+
+// mustParseUint256 parses s with parseUint256, failing the test when it
+// can't.
+func mustParseUint256(t *testing.T, s string) *uint256.Int {
+	t.Helper()
+	v, err := parseUint256(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+// newTestState returns a state with the given accounts, failing the test
+// when it can't.
+func newTestState(t *testing.T, alloc GenesisAlloc) *StateDB {
+	t.Helper()
+	state, err := NewStateDBFromAlloc(alloc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+func TestCallTracerRecordsFramesFailingEarly(t *testing.T) {
+	var (
+		sender   = HexToAddress("0x1000000000000000000000000000000000000001")
+		contract = HexToAddress("0x2000000000000000000000000000000000000002")
+	)
+	tests := []struct {
+		name string
+		asm  string
+		typ  string
+	}{
+		{"call", "PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 1 PUSH1 0xaa GAS CALL", "CALL"},
+		{"callcode", "PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 1 PUSH1 0xaa GAS CALLCODE", "CALLCODE"},
+		{"create", "PUSH1 0 PUSH1 0 PUSH1 1 CREATE", "CREATE"},
+		{"create2", "PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 1 CREATE2", "CREATE2"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// The contract has no balance to send along
+			state := newTestState(t, GenesisAlloc{
+				sender:   {Balance: "1000000000"},
+				contract: {Code: code{Bin: hex.EncodeToString(assemble(t, test.asm))}},
+			})
+			tracer := NewCallTracer(nil)
+			tx := &Transaction{To: &contract, From: sender, Gas: "100000", GasPrice: "1"}
+			if _, err := ApplyTransaction(state, &Block{}, tx, Config{Tracer: tracer}); err != nil {
+				t.Fatal(err)
+			}
+			calls := tracer.Result().Calls
+			if len(calls) != 1 {
+				t.Fatalf("got %d calls, want 1", len(calls))
+			}
+			if calls[0].Type != test.typ || calls[0].Error != ErrInsufficientBalance.Error() {
+				t.Errorf("got %v frame with error %q, want %v with %q", calls[0].Type, calls[0].Error, test.typ, ErrInsufficientBalance)
+			}
+		})
+	}
+}
+
+func TestSelfdestructEIP6780(t *testing.T) {
+	var (
+		beneficiary = HexToAddress("0xbe00000000000000000000000000000000000001")
+		existing    = HexToAddress("0x2000000000000000000000000000000000000002")
+		created     = createAddress(testSender, 0)
+	)
+	tests := []struct {
+		name string
+		asm  string
+		// create runs asm as the init code of a contract creation
+		// transaction, otherwise it's the code of an existing contract
+		create      bool
+		deleted     bool
+		balance     uint64
+		beneficiary uint64
+	}{
+		{name: "existing contract", asm: "PUSH20 0xbe00000000000000000000000000000000000001 SELFDESTRUCT", beneficiary: 1000},
+		{name: "existing contract to itself", asm: "ADDRESS SELFDESTRUCT", balance: 1000},
+		{name: "created contract", asm: "PUSH20 0xbe00000000000000000000000000000000000001 SELFDESTRUCT", create: true, deleted: true, beneficiary: 1000},
+		// The balance is burnt
+		{name: "created contract to itself", asm: "ADDRESS SELFDESTRUCT", create: true, deleted: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			bin := assemble(t, test.asm)
+			state := newTestState(t, GenesisAlloc{
+				testSender: {Balance: "10000000"},
+				existing:   {Balance: "1000", Code: code{Bin: hex.EncodeToString(bin)}},
+			})
+			tx := &Transaction{To: &existing, From: testSender, Gas: "100000", GasPrice: "1"}
+			contract := existing
+			if test.create {
+				tx.To, tx.Data, tx.Value = nil, hex.EncodeToString(bin), "1000"
+				contract = created
+			}
+			if _, err := ApplyTransaction(state, &Block{}, tx, Config{}); err != nil {
+				t.Fatal(err)
+			}
+			if state.Exist(contract) == test.deleted {
+				t.Errorf("got deleted %v, want %v", !state.Exist(contract), test.deleted)
+			}
+			if got := state.GetBalance(contract); !got.Eq(uint256.NewInt(test.balance)) {
+				t.Errorf("got contract balance %v, want %d", got, test.balance)
+			}
+			if got := state.GetBalance(beneficiary); !got.Eq(uint256.NewInt(test.beneficiary)) {
+				t.Errorf("got beneficiary balance %v, want %d", got, test.beneficiary)
+			}
+		})
+	}
+}
+
+// TestSelfdestructEIP6780LaterTransaction checks that a contract stops
+// being new once the transaction that created it ends.
+func TestSelfdestructEIP6780LaterTransaction(t *testing.T) {
+	// The init code returns the 2 bytes of runtime code ADDRESS SELFDESTRUCT
+	initCode := assemble(t, "PUSH2 0x30ff PUSH1 0 MSTORE PUSH1 2 PUSH1 30 RETURN")
+	state := newTestState(t, GenesisAlloc{testSender: {Balance: "10000000"}})
+	contract := createAddress(testSender, 0)
+	create := &Transaction{From: testSender, Gas: "100000", GasPrice: "1", Data: hex.EncodeToString(initCode)}
+	if _, err := ApplyTransaction(state, &Block{}, create, Config{}); err != nil {
+		t.Fatal(err)
+	}
+	call := &Transaction{To: &contract, From: testSender, Nonce: "1", Gas: "100000", GasPrice: "1", Value: "1000"}
+	if _, err := ApplyTransaction(state, &Block{}, call, Config{}); err != nil {
+		t.Fatal(err)
+	}
+	if !state.Exist(contract) {
+		t.Fatal("contract created by an earlier transaction was deleted")
+	}
+	if got := state.GetBalance(contract); !got.Eq(uint256.NewInt(1000)) {
+		t.Errorf("got contract balance %v, want 1000", got)
+	}
+}
+
+func TestTransientStorage(t *testing.T) {
+	var (
+		contract = HexToAddress("0x2000000000000000000000000000000000000002")
+		callee   = HexToAddress("0x3000000000000000000000000000000000000003")
+	)
+	tests := []struct {
+		name string
+		asm  string
+		want string
+	}{
+		// The slot is read back within the transaction
+		{"tstore then tload", "PUSH1 42 PUSH1 1 TSTORE PUSH1 1 TLOAD PUSH1 0 SSTORE", "0x2a"},
+		// The callee sees the slots of its own account
+		{"other account", "PUSH1 42 PUSH1 1 TSTORE PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 0 PUSH20 0x3000000000000000000000000000000000000003 GAS CALL POP PUSH1 1 TLOAD PUSH1 0 SSTORE", "0x2a"},
+		// The callee stores 7 in its slot 1 unless it runs in a static context
+		{"call", "PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 0 PUSH20 0x3000000000000000000000000000000000000003 GAS CALL PUSH1 0 SSTORE", "0x1"},
+		{"staticcall", "PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 0 PUSH20 0x3000000000000000000000000000000000000003 GAS STATICCALL PUSH1 0 SSTORE", "0x0"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			state := newTestState(t, GenesisAlloc{
+				testSender: {Balance: "10000000"},
+				contract:   {Code: code{Bin: hex.EncodeToString(assemble(t, test.asm))}},
+				callee:     {Code: code{Bin: hex.EncodeToString(assemble(t, "PUSH1 7 PUSH1 1 TSTORE"))}},
+			})
+			tx := &Transaction{To: &contract, From: testSender, Gas: "1000000", GasPrice: "1"}
+			if _, err := ApplyTransaction(state, &Block{}, tx, Config{}); err != nil {
+				t.Fatal(err)
+			}
+			if got := state.GetState(contract, Hash{}).Uint256().Hex(); got != test.want {
+				t.Errorf("got slot 0 %v, want %v", got, test.want)
+			}
+			// The transient storage is discarded at the end of the
+			// transaction
+			if got := state.GetTransientState(contract, Hash{31: 1}); got != (Hash{}) {
+				t.Errorf("transient slot kept %v after the transaction", got)
+			}
+		})
+	}
+}
+
+func TestTransientStorageRevert(t *testing.T) {
+	var (
+		addr = HexToAddress("0x2000000000000000000000000000000000000002")
+		key  = Hash{31: 1}
+	)
+	state := NewStateDB()
+	state.SetTransientState(addr, key, Hash{31: 1})
+	snapshot := state.Snapshot()
+	state.SetTransientState(addr, key, Hash{31: 2})
+	state.SetTransientState(addr, Hash{31: 2}, Hash{31: 3})
+	state.RevertToSnapshot(snapshot)
+	if got := state.GetTransientState(addr, key); got != (Hash{31: 1}) {
+		t.Errorf("got %v after revert, want %v", got, Hash{31: 1})
+	}
+	if got := state.GetTransientState(addr, Hash{31: 2}); got != (Hash{}) {
+		t.Errorf("got %v after revert, want the zero hash", got)
+	}
+}
+
+func TestCallVariants(t *testing.T) {
+	var (
+		caller = HexToAddress("0x2000000000000000000000000000000000000002")
+		callee = HexToAddress("0x3000000000000000000000000000000000000003")
+	)
+	const (
+		callArgs = "PUSH1 0 PUSH1 0 PUSH1 0 PUSH1 0 "
+		target   = " PUSH20 0x3000000000000000000000000000000000000003 GAS "
+	)
+	tests := []struct {
+		name string
+		asm  string
+		// storage is the account whose storage the callee writes
+		storage Address
+		// the callee stores its CALLER, ADDRESS and CALLVALUE, the caller
+		// whether the call succeeded
+		callerSlot, addressSlot Address
+		valueSlot               uint64
+		success                 bool
+	}{
+		{"call", callArgs + "PUSH1 5" + target + "CALL", callee, caller, callee, 5, true},
+		{"callcode", callArgs + "PUSH1 5" + target + "CALLCODE", caller, caller, caller, 5, true},
+		// The callee runs in the context of the caller's own call
+		{"delegatecall", callArgs + target + "DELEGATECALL", caller, testSender, caller, 7, true},
+		// The callee can't write storage
+		{"staticcall", callArgs + target + "STATICCALL", callee, Address{}, Address{}, 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			state := newTestState(t, GenesisAlloc{
+				testSender: {Balance: "10000000"},
+				caller:     {Balance: "100", Code: code{Bin: hex.EncodeToString(assemble(t, test.asm+" PUSH1 3 SSTORE"))}},
+				callee:     {Code: code{Bin: hex.EncodeToString(assemble(t, "CALLER PUSH1 0 SSTORE ADDRESS PUSH1 1 SSTORE CALLVALUE PUSH1 2 SSTORE"))}},
+			})
+			tx := &Transaction{To: &caller, From: testSender, Gas: "1000000", GasPrice: "1", Value: "7"}
+			if _, err := ApplyTransaction(state, &Block{}, tx, Config{}); err != nil {
+				t.Fatal(err)
+			}
+			if got := Address(state.GetState(test.storage, Hash{}).Uint256().Bytes20()); got != test.callerSlot {
+				t.Errorf("got CALLER %v, want %v", got, test.callerSlot)
+			}
+			if got := Address(state.GetState(test.storage, Hash{31: 1}).Uint256().Bytes20()); got != test.addressSlot {
+				t.Errorf("got ADDRESS %v, want %v", got, test.addressSlot)
+			}
+			if got := state.GetState(test.storage, Hash{31: 2}).Uint256(); !got.Eq(uint256.NewInt(test.valueSlot)) {
+				t.Errorf("got CALLVALUE %v, want %d", got, test.valueSlot)
+			}
+			if got := state.GetState(caller, Hash{31: 3}).Uint256(); got.IsZero() == test.success {
+				t.Errorf("got success %v, want %v", got, test.success)
+			}
+		})
+	}
+}
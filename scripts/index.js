@@ -26,7 +26,7 @@ function main() {
       return parseYamlBigInt(value);
     }
 
-    if (key === 'state') {
+    if (key === 'state' || key === 'storage') {
       return Object.fromEntries(Object.entries(value).map(([address, account]) => [parseYamlBigInt(address), account]));
     }
 